@@ -0,0 +1,240 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fem/internal/middleware"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+//! page size limits for GET /admin/users
+const (
+	defaultUsersPageSize = 50
+	maxUsersPageSize     = 200
+)
+
+//! types declaration
+type AdminHandler struct {
+	userStore  store.UserStore  //* users being managed
+	tokenStore store.TokenStore //* revoking sessions on suspension
+	auditStore store.AuditStore //* every admin action is audited
+	logger     *slog.Logger      //* for error logging
+}
+
+//! setRoleRequest --> body for PUT /admin/users/{id}/role
+type setRoleRequest struct {
+	Role string `json:"role"` //* user, coach or admin
+}
+
+//! NewAdminHandler --> constructor for staff-only user management endpoints
+func NewAdminHandler(userStore store.UserStore, tokenStore store.TokenStore, auditStore store.AuditStore, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		auditStore: auditStore,
+		logger:     logger,
+	}
+}
+
+//! HandleListUsers --> GET /admin/users?limit=50&offset=0
+func (h *AdminHandler) HandleListUsers(w http.ResponseWriter, req *http.Request) {
+	limit, err := readIntQuery(req, "limit", defaultUsersPageSize)
+	if err != nil || limit < 1 || limit > maxUsersPageSize {
+		utils.BadRequest(w, req, "limit must be between 1 and 200")
+		return
+	}
+	offset, err := readIntQuery(req, "offset", 0)
+	if err != nil || offset < 0 {
+		utils.BadRequest(w, req, "offset must be a positive number")
+		return
+	}
+
+	users, total, err := h.userStore.ListUsers(req.Context(), limit, offset)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListUsers", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"users": users, "total": total, "limit": limit, "offset": offset})
+}
+
+//! HandleSuspendUser --> POST /admin/users/{id}/suspend
+//! Blocks logins and kills existing sessions; API keys stop working via the suspension check
+func (h *AdminHandler) HandleSuspendUser(w http.ResponseWriter, req *http.Request) {
+	admin := middleware.GetUser(req)
+	target, ok := h.loadTargetUser(w, req)
+	if !ok {
+		return
+	}
+	if target.ID == admin.ID {
+		utils.BadRequest(w, req, "you cannot suspend your own account")
+		return
+	}
+
+	err := h.userStore.SetUserSuspended(req.Context(), int64(target.ID), true)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "SetUserSuspended", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	//* revoke every login token --> suspension takes effect on the very next request
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeMFAPending} {
+		err = h.tokenStore.DeleteAllTokensForUser(req.Context(), target.ID, scope)
+		if err != nil {
+			h.logger.ErrorContext(req.Context(), "DeleteAllTokensForUser", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+	}
+
+	h.audit(req, store.AuditUserSuspended, target, admin, "")
+	h.respondWithUser(w, req, target.ID)
+}
+
+//! HandleUnsuspendUser --> POST /admin/users/{id}/unsuspend
+func (h *AdminHandler) HandleUnsuspendUser(w http.ResponseWriter, req *http.Request) {
+	admin := middleware.GetUser(req)
+	target, ok := h.loadTargetUser(w, req)
+	if !ok {
+		return
+	}
+
+	err := h.userStore.SetUserSuspended(req.Context(), int64(target.ID), false)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "SetUserSuspended", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	h.audit(req, store.AuditUserRestored, target, admin, "")
+	h.respondWithUser(w, req, target.ID)
+}
+
+//! HandleSetUserRole --> PUT /admin/users/{id}/role
+func (h *AdminHandler) HandleSetUserRole(w http.ResponseWriter, req *http.Request) {
+	admin := middleware.GetUser(req)
+
+	var body setRoleRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	if !store.ValidRole(body.Role) {
+		utils.BadRequest(w, req, "role must be one of user, coach, admin")
+		return
+	}
+
+	target, ok := h.loadTargetUser(w, req)
+	if !ok {
+		return
+	}
+	//? an admin demoting themselves could leave the gym without any admin
+	if target.ID == admin.ID && body.Role != store.RoleAdmin {
+		utils.BadRequest(w, req, "you cannot remove your own admin role")
+		return
+	}
+
+	err = h.userStore.SetUserRole(req.Context(), int64(target.ID), body.Role)
+	if err != nil {
+		if writeConstraintError(w, req, err) {
+			return
+		}
+		h.logger.ErrorContext(req.Context(), "SetUserRole", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	h.audit(req, store.AuditRoleChanged, target, admin, target.Role+" -> "+body.Role)
+	h.respondWithUser(w, req, target.ID)
+}
+
+//! HandleDeleteUser --> DELETE /admin/users/{id}
+//! Removes the account and (through ON DELETE CASCADE) everything it owns
+func (h *AdminHandler) HandleDeleteUser(w http.ResponseWriter, req *http.Request) {
+	admin := middleware.GetUser(req)
+	target, ok := h.loadTargetUser(w, req)
+	if !ok {
+		return
+	}
+	if target.ID == admin.ID {
+		utils.BadRequest(w, req, "you cannot delete your own account here")
+		return
+	}
+
+	err := h.userStore.DeleteUser(req.Context(), int64(target.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteUser", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	//* user row is gone --> keep only the username in the audit entry
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditUserDeleted, Username: target.Username, IPAddress: utils.ClientIP(req), Detail: "by " + admin.Username})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//! loadTargetUser --> reads {id} and fetches the user, writing 400/404/500 itself
+func (h *AdminHandler) loadTargetUser(w http.ResponseWriter, req *http.Request) (*store.User, bool) {
+	userID, err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w, req, "invalid user id")
+		return nil, false
+	}
+
+	user, err := h.userStore.GetUserByID(req.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetUserByID", "error", err)
+		utils.InternalError(w, req)
+		return nil, false
+	}
+	if user == nil {
+		utils.NotFound(w, req, "user not found")
+		return nil, false
+	}
+	return user, true
+}
+
+//! respondWithUser --> re-reads the user so the response shows the stored state
+func (h *AdminHandler) respondWithUser(w http.ResponseWriter, req *http.Request, userID int) {
+	user, err := h.userStore.GetUserByID(req.Context(), int64(userID))
+	if err != nil || user == nil {
+		h.logger.ErrorContext(req.Context(), "GetUserByID", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+func (h *AdminHandler) audit(req *http.Request, event string, target *store.User, admin *store.User, detail string) {
+	if detail != "" {
+		detail = " (" + detail + ")"
+	}
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{
+		Event:     event,
+		UserID:    &target.ID,
+		Username:  target.Username,
+		IPAddress: utils.ClientIP(req),
+		Detail:    "by " + admin.Username + detail,
+	})
+}
+
+//! readIntQuery --> optional integer query parameter with a fallback
+func readIntQuery(req *http.Request, key string, fallback int) (int, error) {
+	raw := req.URL.Query().Get(key)
+	if raw == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(raw)
+}
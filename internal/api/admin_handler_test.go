@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/middleware"
+	"fem/internal/rbac"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestAdminRoutesNeedPermission --> plain users and coaches are refused on every /admin/users route
+func TestAdminRoutesNeedPermission(t *testing.T) {
+	test := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: http.MethodGet, path: "/admin/users"},
+		{method: http.MethodPost, path: "/admin/users/3/suspend"},
+		{method: http.MethodPost, path: "/admin/users/3/unsuspend"},
+		{method: http.MethodPut, path: "/admin/users/3/role", body: `{"role": "admin"}`},
+		{method: http.MethodDelete, path: "/admin/users/3"},
+	}
+
+	env := newAdminTestEnv(t)
+	for _, role := range []string{store.RoleUser, store.RoleCoach} {
+		for _, tt := range test {
+			status, body := env.send(t, tt.method, tt.path, env.tokens[role], tt.body)
+			assert.Equal(t, http.StatusForbidden, status, "%s %s as %s", tt.method, tt.path, role)
+			assert.Equal(t, utils.CodeForbidden, body["code"])
+		}
+	}
+	assert.Equal(t, store.RoleUser, env.user(t, 3).Role)
+	assert.False(t, env.user(t, 3).IsSuspended())
+
+	status, _ := env.send(t, http.MethodGet, "/admin/users", env.tokens[store.RoleAdmin], "")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+// ! TestAdminCannotLockThemselvesOut --> no self suspension, demotion or deletion
+func TestAdminCannotLockThemselvesOut(t *testing.T) {
+	env := newAdminTestEnv(t)
+	admin := env.tokens[store.RoleAdmin]
+
+	for _, request := range [][3]string{
+		{http.MethodPost, "/admin/users/1/suspend", ""},
+		{http.MethodPut, "/admin/users/1/role", `{"role": "coach"}`},
+		{http.MethodDelete, "/admin/users/1", ""},
+	} {
+		status, _ := env.send(t, request[0], request[1], admin, request[2])
+		assert.Equal(t, http.StatusBadRequest, status, "%s %s", request[0], request[1])
+	}
+
+	self := env.user(t, 1)
+	assert.Equal(t, store.RoleAdmin, self.Role)
+	assert.False(t, self.IsSuspended())
+	status, _ := env.send(t, http.MethodGet, "/admin/users", admin, "")
+	assert.Equal(t, http.StatusOK, status) // * still logged in, still an admin
+}
+
+// ! TestSuspendRevokesSessions --> login and mfa-pending tokens are gone, logging in again is refused
+func TestSuspendRevokesSessions(t *testing.T) {
+	env := newAdminTestEnv(t)
+	ctx := context.Background()
+	_, err := env.tokenStore.CreateNewToken(ctx, 3, 5*time.Minute, tokens.ScopeMFAPending)
+	require.NoError(t, err)
+
+	status, body := env.send(t, http.MethodPost, "/admin/users/3/suspend", env.tokens[store.RoleAdmin], "")
+	require.Equal(t, http.StatusOK, status)
+	assert.NotNil(t, body["user"].(map[string]interface{})["suspended_at"])
+	assert.Equal(t, 1, env.audit.count(store.AuditUserSuspended))
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeMFAPending} {
+		left, err := env.tokenStore.HasTokenExpiringAfter(ctx, 3, scope, time.Now())
+		require.NoError(t, err)
+		assert.False(t, left, scope)
+	}
+
+	status, _ = env.send(t, http.MethodGet, "/admin/users", env.tokens[store.RoleUser], "")
+	assert.Equal(t, http.StatusUnauthorized, status) // ? the revoked token is unknown now, not just refused
+
+	status, body = env.send(t, http.MethodPost, "/tokens/authentication", "", `{"username": "plain", "password": "Secret123!"}`)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, utils.CodeAccountSuspended, body["code"])
+
+	// * a session minted before the suspension landed is refused by Authenticate as well
+	late, err := env.tokenStore.CreateNewToken(ctx, 3, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	status, body = env.send(t, http.MethodGet, "/admin/users", late.Plaintext, "")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, utils.CodeAccountSuspended, body["code"])
+}
+
+// ! TestListUsersPaging --> limit and offset outside the allowed range are a 400
+func TestListUsersPaging(t *testing.T) {
+	env := newAdminTestEnv(t)
+	admin := env.tokens[store.RoleAdmin]
+
+	for _, query := range []string{"limit=0", "limit=201", "limit=ten", "offset=-1", "offset=x"} {
+		status, _ := env.send(t, http.MethodGet, "/admin/users?"+query, admin, "")
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+
+	status, body := env.send(t, http.MethodGet, "/admin/users?limit=2&offset=1", admin, "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["users"], 2)
+	assert.Equal(t, float64(3), body["total"])
+	assert.Equal(t, "coach", body["users"].([]interface{})[0].(map[string]interface{})["username"])
+}
+
+// * adminTestEnv --> admin (id 1), coach (id 2) and plain user (id 3), each logged in, routes wired like routes.go
+type adminTestEnv struct {
+	server     *httptest.Server
+	users      *store.MemoryUserStore
+	tokenStore *store.MemoryTokenStore
+	audit      *memoryAudit
+	tokens     map[string]string // * role --> login token
+}
+
+func newAdminTestEnv(t *testing.T) *adminTestEnv {
+	t.Helper()
+	db := store.NewMemoryDB()
+	env := &adminTestEnv{users: store.NewMemoryUserStore(db), tokenStore: store.NewMemoryTokenStore(db), audit: &memoryAudit{}, tokens: map[string]string{}}
+	for _, seed := range []struct{ username, role string }{{"admin", store.RoleAdmin}, {"coach", store.RoleCoach}, {"plain", store.RoleUser}} {
+		user := &store.User{Username: seed.username, Email: seed.username + "@example.com", Role: seed.role}
+		require.NoError(t, user.PasswordHash.Set("Secret123!"))
+		require.NoError(t, env.users.CreateUser(context.Background(), user))
+		token, err := env.tokenStore.CreateNewToken(context.Background(), user.ID, time.Hour, tokens.ScopeAuth)
+		require.NoError(t, err)
+		env.tokens[seed.role] = token.Plaintext
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	h := NewAdminHandler(env.users, env.tokenStore, env.audit, logger)
+	tokenHandler := NewTokenHandler(env.tokenStore, env.users, &noTOTP{}, env.audit, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, metrics.New(), logger)
+	mw := middleware.UserMiddleware{UserStore: env.users, TokenStore: env.tokenStore}
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return mw.RequireUser(mw.RequireLoginSession(mw.RequirePermission(rbac.PermManageUsers, next)))
+	}
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Authenticate)
+		r.Get("/admin/users", admin(h.HandleListUsers))
+		r.Post("/admin/users/{id}/suspend", admin(h.HandleSuspendUser))
+		r.Post("/admin/users/{id}/unsuspend", admin(h.HandleUnsuspendUser))
+		r.Put("/admin/users/{id}/role", admin(h.HandleSetUserRole))
+		r.Delete("/admin/users/{id}", admin(h.HandleDeleteUser))
+	})
+	r.Post("/tokens/authentication", tokenHandler.HandleCreateToken)
+
+	env.server = httptest.NewServer(r)
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+func (e *adminTestEnv) user(t *testing.T, id int64) *store.User {
+	t.Helper()
+	user, err := e.users.GetUserByID(context.Background(), id)
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	return user
+}
+
+func (e *adminTestEnv) send(t *testing.T, method string, path string, token string, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(method, e.server.URL+path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	decoded := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
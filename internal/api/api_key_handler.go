@@ -0,0 +1,145 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fem/internal/middleware"
+	"fem/internal/scopes"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+//! key lifetime limits --> keys always expire, even if the client doesn't ask for it
+const (
+	defaultAPIKeyLifetimeDays = 90
+	maxAPIKeyLifetimeDays     = 365
+)
+
+//! types declaration
+type APIKeyHandler struct {
+	apiKeyStore store.APIKeyStore //* database operations for api keys
+	logger      *slog.Logger       //* for error logging
+}
+
+//! createAPIKeyRequest --> incoming JSON payload for a new key
+type createAPIKeyRequest struct {
+	Name          string   `json:"name"`            //* label shown in the key list
+	Scopes        []string `json:"scopes"`          //* e.g. ["workouts:read"]
+	ExpiresInDays *int     `json:"expires_in_days"` //* optional, defaults to 90
+}
+
+//! NewAPIKeyHandler --> constructor for api key management endpoints
+func NewAPIKeyHandler(apiKeyStore store.APIKeyStore, logger *slog.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyStore: apiKeyStore,
+		logger:      logger,
+	}
+}
+
+//! HandleCreateAPIKey --> POST /users/me/api-keys
+//! Plaintext key is only part of this response, afterwards only the prefix is visible
+func (h *APIKeyHandler) HandleCreateAPIKey(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	var body createAPIKeyRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	if body.Name == "" || len(body.Name) > 100 {
+		utils.BadRequest(w, req, "name is required and cannot be greater than 100 characters")
+		return
+	}
+	if len(body.Scopes) == 0 {
+		utils.BadRequest(w, req, "at least one scope is required")
+		return
+	}
+	grantedScopes, err := scopes.Normalize(body.Scopes)
+	if err != nil {
+		utils.BadRequest(w, req, err.Error())
+		return
+	}
+
+	lifetimeDays := defaultAPIKeyLifetimeDays
+	if body.ExpiresInDays != nil {
+		lifetimeDays = *body.ExpiresInDays
+	}
+	if lifetimeDays < 1 || lifetimeDays > maxAPIKeyLifetimeDays {
+		utils.BadRequest(w, req, "expires_in_days must be between 1 and 365")
+		return
+	}
+
+	plaintext, prefix, hash, err := tokens.GenerateAPIKey()
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GenerateAPIKey", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	apiKey := &store.APIKey{
+		UserID: user.ID,
+		Name:   body.Name,
+		Prefix: prefix,
+		Scopes: grantedScopes,
+		Expiry: time.Now().Add(time.Duration(lifetimeDays) * 24 * time.Hour),
+		Hash:   hash,
+	}
+	err = h.apiKeyStore.CreateAPIKey(req.Context(), apiKey)
+	if err != nil {
+		//? names are unique per user so keys can be told apart in the list --> 409 from the constraint
+		if writeConstraintError(w, req, err) {
+			return
+		}
+		h.logger.ErrorContext(req.Context(), "CreateAPIKey", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	apiKey.Plaintext = plaintext
+	utils.WriteJson(w, http.StatusCreated, utils.Envelope{"api_key": apiKey})
+}
+
+//! HandleListAPIKeys --> GET /users/me/api-keys
+func (h *APIKeyHandler) HandleListAPIKeys(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	keys, err := h.apiKeyStore.ListAPIKeys(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListAPIKeys", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"api_keys": keys})
+}
+
+//! HandleDeleteAPIKey --> DELETE /users/me/api-keys/{id}
+//! Revocation is immediate, the next request with the key gets a 401
+func (h *APIKeyHandler) HandleDeleteAPIKey(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	keyID, err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w, req, "invalid api key id")
+		return
+	}
+
+	err = h.apiKeyStore.DeleteAPIKey(req.Context(), user.ID, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "api key not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteAPIKey", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/middleware"
+	"fem/internal/scopes"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestAPIKeyScopes --> a read-only key reads workouts, can't write them and can't manage keys
+func TestAPIKeyScopes(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, _ := env.createKey(t, scopes.WorkoutsRead)
+
+	res := send(t, http.MethodPost, env.server.URL+"/workouts", env.loginToken, `{"title": "long run", "duration_minutes": 90}`)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct {
+		Workout store.Workout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+
+	assert.Equal(t, http.StatusOK, send(t, http.MethodGet, env.server.URL+"/workouts/"+strconv.Itoa(created.Workout.ID), key, "").StatusCode)
+
+	res = send(t, http.MethodPost, env.server.URL+"/workouts", key, `{"title": "tempo run", "duration_minutes": 40}`)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Contains(t, res.Header.Get("WWW-Authenticate"), `scope="workouts:write"`)
+
+	// ? a leaked key can't mint more keys
+	res = send(t, http.MethodPost, env.server.URL+"/users/me/api-keys", key, `{"name": "escalated", "scopes": ["workouts:write"]}`)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	keys, err := env.keys.ListAPIKeys(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
+
+// ! TestAPIKeyExpired --> an expired key is a 401 and doesn't count as used
+func TestAPIKeyExpired(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	plaintext, prefix, hash, err := tokens.GenerateAPIKey()
+	require.NoError(t, err)
+	require.NoError(t, env.keys.CreateAPIKey(context.Background(), &store.APIKey{UserID: 1, Name: "old script", Prefix: prefix, Hash: hash, Scopes: []string{scopes.WorkoutsRead}, Expiry: time.Now().Add(-time.Minute)}))
+
+	assert.Equal(t, http.StatusUnauthorized, send(t, http.MethodGet, env.server.URL+"/workouts/1", plaintext, "").StatusCode)
+	assert.Nil(t, env.keys.keys[0].LastUsedAt)
+}
+
+// ! TestAPIKeyRevoked --> deleting a key locks it out on the very next request
+func TestAPIKeyRevoked(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, id := env.createKey(t, scopes.WorkoutsWrite)
+
+	assert.Equal(t, http.StatusCreated, send(t, http.MethodPost, env.server.URL+"/workouts", key, `{"title": "tempo run", "duration_minutes": 40}`).StatusCode)
+
+	revoke := fmt.Sprintf("%s/users/me/api-keys/%d", env.server.URL, id)
+	require.Equal(t, http.StatusNoContent, send(t, http.MethodDelete, revoke, env.loginToken, "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, send(t, http.MethodPost, env.server.URL+"/workouts", key, `{"title": "tempo run", "duration_minutes": 40}`).StatusCode)
+	assert.Equal(t, http.StatusNotFound, send(t, http.MethodDelete, revoke, env.loginToken, "").StatusCode)
+}
+
+// ! TestAPIKeyLastUsed --> the key list shows when a key was last presented
+func TestAPIKeyLastUsed(t *testing.T) {
+	env := newAPIKeyTestEnv(t)
+	key, _ := env.createKey(t, scopes.WorkoutsRead)
+
+	lastUsed := func() interface{} {
+		res := send(t, http.MethodGet, env.server.URL+"/users/me/api-keys", env.loginToken, "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var body struct {
+			APIKeys []map[string]interface{} `json:"api_keys"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		require.Len(t, body.APIKeys, 1)
+		assert.NotContains(t, body.APIKeys[0], "key") // * the plaintext is never listed
+		return body.APIKeys[0]["last_used_at"]
+	}
+
+	assert.Nil(t, lastUsed())
+	send(t, http.MethodGet, env.server.URL+"/workouts/1", key, "")
+	assert.NotNil(t, lastUsed())
+}
+
+// * apiKeyTestEnv --> one logged in user (id 1), key management and workout routes wired like routes.go
+type apiKeyTestEnv struct {
+	server     *httptest.Server
+	keys       *memoryAPIKeys
+	loginToken string
+}
+
+func newAPIKeyTestEnv(t *testing.T) *apiKeyTestEnv {
+	t.Helper()
+	db := store.NewMemoryDB()
+	users := store.NewMemoryUserStore(db)
+	tokenStore := store.NewMemoryTokenStore(db)
+	env := &apiKeyTestEnv{keys: newMemoryAPIKeys(users)}
+	env.loginToken = loginAs(t, users, tokenStore, "ayush")
+
+	logger := slog.New(slog.DiscardHandler)
+	workouts := NewWorkoutHandler(store.NewMemoryWorkoutStore(db), newMemoryCoaching(users), metrics.New(), logger)
+	apiKeys := NewAPIKeyHandler(env.keys, logger)
+	mw := middleware.UserMiddleware{UserStore: users, APIKeyStore: env.keys, TokenStore: tokenStore}
+
+	r := chi.NewRouter()
+	r.Use(mw.Authenticate)
+	r.Get("/workouts/{id}", mw.RequireUser(mw.RequireScope(scopes.WorkoutsRead, workouts.HandleWorkoutByID)))
+	r.Post("/workouts", mw.RequireUser(mw.RequireScope(scopes.WorkoutsWrite, workouts.HandleCreateWorkout)))
+	r.Get("/users/me/api-keys", mw.RequireUser(mw.RequireLoginSession(apiKeys.HandleListAPIKeys)))
+	r.Post("/users/me/api-keys", mw.RequireUser(mw.RequireLoginSession(apiKeys.HandleCreateAPIKey)))
+	r.Delete("/users/me/api-keys/{id}", mw.RequireUser(mw.RequireLoginSession(apiKeys.HandleDeleteAPIKey)))
+
+	env.server = httptest.NewServer(r)
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+// * createKey --> POST /users/me/api-keys with the login token, returns the plaintext key and its id
+func (e *apiKeyTestEnv) createKey(t *testing.T, scope string) (string, int64) {
+	t.Helper()
+	res := send(t, http.MethodPost, e.server.URL+"/users/me/api-keys", e.loginToken, `{"name": "script", "scopes": ["`+scope+`"]}`)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var body struct {
+		APIKey store.APIKey `json:"api_key"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.NotEmpty(t, body.APIKey.Plaintext)
+	return body.APIKey.Plaintext, body.APIKey.ID
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"fem/internal/store"
+	"log/slog"
+)
+
+//! recordAuditEvent --> best effort write to the audit log
+//? a failing audit insert is logged but never turns a successful request into an error
+func recordAuditEvent(ctx context.Context, auditStore store.AuditStore, logger *slog.Logger, event *store.AuditEvent) {
+	err := auditStore.InsertAuditEvent(ctx, event)
+	if err != nil {
+		logger.ErrorContext(ctx, "InsertAuditEvent", "event", event.Event, "error", err)
+	}
+}
+
+//! auditUserID --> nil for unknown users so the column stays NULL
+func auditUserID(user *store.User) *int {
+	if user == nil {
+		return nil
+	}
+	return &user.ID
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fem/internal/middleware"
+	"fem/internal/store"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+)
+
+//! types declaration
+type CoachHandler struct {
+	coachStore store.CoachStore //* coach/athlete grants
+	userStore  store.UserStore  //* resolving athletes by username
+	logger     *slog.Logger      //* for error logging
+}
+
+//! inviteAthleteRequest --> body for POST /coach/invitations
+type inviteAthleteRequest struct {
+	AthleteUsername string `json:"athlete_username"` //* who should be coached
+	Access          string `json:"access"`           //* read or read_write
+}
+
+//! NewCoachHandler --> constructor for coach and athlete relationship endpoints
+func NewCoachHandler(coachStore store.CoachStore, userStore store.UserStore, logger *slog.Logger) *CoachHandler {
+	return &CoachHandler{
+		coachStore: coachStore,
+		userStore:  userStore,
+		logger:     logger,
+	}
+}
+
+//! HandleInviteAthlete --> POST /coach/invitations
+//! Nothing is shared until the athlete accepts
+func (h *CoachHandler) HandleInviteAthlete(w http.ResponseWriter, req *http.Request) {
+	coach := middleware.GetUser(req)
+
+	var body inviteAthleteRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	if body.Access != store.CoachAccessRead && body.Access != store.CoachAccessReadWrite {
+		utils.BadRequest(w, req, "access must be read or read_write")
+		return
+	}
+
+	athlete, err := h.userStore.GetUserByUsername(req.Context(), body.AthleteUsername)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetUserByUsername", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if athlete == nil {
+		utils.NotFound(w, req, "athlete not found")
+		return
+	}
+	if athlete.ID == coach.ID {
+		utils.BadRequest(w, req, "you cannot coach yourself")
+		return
+	}
+
+	invitation, err := h.coachStore.CreateInvitation(req.Context(), coach.ID, athlete.ID, body.Access)
+	if err != nil {
+		if writeConstraintError(w, req, err) {
+			return
+		}
+		h.logger.ErrorContext(req.Context(), "CreateInvitation", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusCreated, utils.Envelope{"invitation": invitation})
+}
+
+//! HandleListAthletes --> GET /coach/athletes
+func (h *CoachHandler) HandleListAthletes(w http.ResponseWriter, req *http.Request) {
+	coach := middleware.GetUser(req)
+
+	athletes, err := h.coachStore.ListAthletes(req.Context(), coach.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListAthletes", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"athletes": athletes})
+}
+
+//! HandleRemoveAthlete --> DELETE /coach/athletes/{id}
+func (h *CoachHandler) HandleRemoveAthlete(w http.ResponseWriter, req *http.Request) {
+	coach := middleware.GetUser(req)
+
+	athleteID, err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w, req, "invalid athlete id")
+		return
+	}
+
+	h.deleteRelationship(w, req, coach.ID, int(athleteID))
+}
+
+//! HandleListInvitations --> GET /users/me/invitations (pending invitations for the athlete)
+func (h *CoachHandler) HandleListInvitations(w http.ResponseWriter, req *http.Request) {
+	athlete := middleware.GetUser(req)
+
+	invitations, err := h.coachStore.ListPendingInvitations(req.Context(), athlete.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListPendingInvitations", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"invitations": invitations})
+}
+
+//! HandleAcceptInvitation --> POST /users/me/invitations/{id}/accept
+func (h *CoachHandler) HandleAcceptInvitation(w http.ResponseWriter, req *http.Request) {
+	h.respondToInvitation(w, req, true)
+}
+
+//! HandleDeclineInvitation --> POST /users/me/invitations/{id}/decline
+func (h *CoachHandler) HandleDeclineInvitation(w http.ResponseWriter, req *http.Request) {
+	h.respondToInvitation(w, req, false)
+}
+
+//! HandleListCoaches --> GET /users/me/coaches
+func (h *CoachHandler) HandleListCoaches(w http.ResponseWriter, req *http.Request) {
+	athlete := middleware.GetUser(req)
+
+	coaches, err := h.coachStore.ListCoaches(req.Context(), athlete.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListCoaches", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"coaches": coaches})
+}
+
+//! HandleRevokeCoach --> DELETE /users/me/coaches/{id}
+//! Athlete withdraws access, effective on the coach's next request
+func (h *CoachHandler) HandleRevokeCoach(w http.ResponseWriter, req *http.Request) {
+	athlete := middleware.GetUser(req)
+
+	coachID, err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w, req, "invalid coach id")
+		return
+	}
+
+	h.deleteRelationship(w, req, int(coachID), athlete.ID)
+}
+
+func (h *CoachHandler) respondToInvitation(w http.ResponseWriter, req *http.Request, accept bool) {
+	athlete := middleware.GetUser(req)
+
+	invitationID, err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w, req, "invalid invitation id")
+		return
+	}
+
+	//* athlete id is part of the update --> nobody can answer someone else's invitation
+	err = h.coachStore.RespondToInvitation(req.Context(), invitationID, athlete.ID, accept)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "pending invitation not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "RespondToInvitation", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	invitation, err := h.coachStore.GetRelationship(req.Context(), invitationID)
+	if err != nil || invitation == nil {
+		h.logger.ErrorContext(req.Context(), "GetRelationship", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"invitation": invitation})
+}
+
+func (h *CoachHandler) deleteRelationship(w http.ResponseWriter, req *http.Request, coachID int, athleteID int) {
+	err := h.coachStore.DeleteRelationship(req.Context(), coachID, athleteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "coaching relationship not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteRelationship", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/middleware"
+	"fem/internal/rbac"
+	"fem/internal/store"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestCoachReadAccess --> a read grant shows the athlete's workouts but never changes them
+func TestCoachReadAccess(t *testing.T) {
+	env := newCoachTestEnv(t)
+	env.coach(t, store.CoachAccessRead)
+	url := env.server.URL + "/workouts/" + strconv.Itoa(env.athleteWorkout(t))
+
+	assert.Equal(t, http.StatusOK, send(t, http.MethodGet, url, env.coachToken, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodPut, url, env.coachToken, `{"title": "coach was here"}`).StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodDelete, url, env.coachToken, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodPost, env.server.URL+"/coach/athletes/1/workouts", env.coachToken, `{"title": "intervals"}`).StatusCode)
+	assert.Equal(t, http.StatusOK, send(t, http.MethodGet, url, env.athleteToken, "").StatusCode) // * untouched
+}
+
+// ! TestCoachReadWriteAccess --> read_write coaches edit and program workouts, the athlete stays the owner
+func TestCoachReadWriteAccess(t *testing.T) {
+	env := newCoachTestEnv(t)
+	env.coach(t, store.CoachAccessReadWrite)
+	url := env.server.URL + "/workouts/" + strconv.Itoa(env.athleteWorkout(t))
+
+	assert.Equal(t, http.StatusOK, send(t, http.MethodPut, url, env.coachToken, `{"title": "long run (easier)"}`).StatusCode)
+
+	res := send(t, http.MethodPost, env.server.URL+"/coach/athletes/1/workouts", env.coachToken, `{"title": "intervals", "duration_minutes": 40}`)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct {
+		Workout store.Workout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	assert.Equal(t, 1, created.Workout.UserID)
+	assert.Equal(t, 2, created.Workout.CreatedBy)
+
+	// ? the athlete can read what the coach programmed
+	assert.Equal(t, http.StatusOK, send(t, http.MethodGet, env.server.URL+"/workouts/"+strconv.Itoa(created.Workout.ID), env.athleteToken, "").StatusCode)
+	// ? a grant is per athlete, nobody else's workouts can be programmed
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodPost, env.server.URL+"/coach/athletes/3/workouts", env.coachToken, `{"title": "intervals"}`).StatusCode)
+}
+
+// ! TestCoachLosesAccess --> demotion and revocation both take effect on the next request
+func TestCoachLosesAccess(t *testing.T) {
+	test := []struct {
+		name   string
+		remove func(t *testing.T, env *coachTestEnv)
+	}{
+		{name: "demoted", remove: func(t *testing.T, env *coachTestEnv) {
+			require.NoError(t, env.users.SetUserRole(context.Background(), 2, store.RoleUser))
+		}},
+		{name: "revoked by the athlete", remove: func(t *testing.T, env *coachTestEnv) {
+			require.Equal(t, http.StatusNoContent, send(t, http.MethodDelete, env.server.URL+"/users/me/coaches/2", env.athleteToken, "").StatusCode)
+		}},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newCoachTestEnv(t)
+			env.coach(t, store.CoachAccessReadWrite)
+			url := env.server.URL + "/workouts/" + strconv.Itoa(env.athleteWorkout(t))
+			require.Equal(t, http.StatusOK, send(t, http.MethodGet, url, env.coachToken, "").StatusCode)
+
+			tt.remove(t, env)
+
+			assert.Equal(t, http.StatusForbidden, send(t, http.MethodGet, url, env.coachToken, "").StatusCode)
+			assert.Equal(t, http.StatusForbidden, send(t, http.MethodPut, url, env.coachToken, `{"title": "x"}`).StatusCode)
+			assert.Equal(t, http.StatusForbidden, send(t, http.MethodPost, env.server.URL+"/coach/athletes/1/workouts", env.coachToken, `{"title": "x"}`).StatusCode)
+		})
+	}
+}
+
+// ! TestAcceptOthersInvitation --> only the invited athlete can answer, anyone else gets a 404
+func TestAcceptOthersInvitation(t *testing.T) {
+	env := newCoachTestEnv(t)
+	invitation := env.invite(t, store.CoachAccessReadWrite)
+
+	for _, action := range []string{"accept", "decline"} {
+		path := fmt.Sprintf("%s/users/me/invitations/%d/%s", env.server.URL, invitation, action)
+		assert.Equal(t, http.StatusNotFound, send(t, http.MethodPost, path, env.otherToken, "").StatusCode, action)
+	}
+
+	relationship, err := env.coaching.GetRelationship(context.Background(), invitation)
+	require.NoError(t, err)
+	assert.Equal(t, store.CoachingPending, relationship.Status)
+	access, err := env.coaching.GetCoachAccess(context.Background(), 2, 1)
+	require.NoError(t, err)
+	assert.Empty(t, access)
+}
+
+// ! TestReinviteResetsToPending --> a new invitation (e.g. asking for more access) needs the athlete's yes again
+func TestReinviteResetsToPending(t *testing.T) {
+	env := newCoachTestEnv(t)
+	env.coach(t, store.CoachAccessRead)
+	url := env.server.URL + "/workouts/" + strconv.Itoa(env.athleteWorkout(t))
+	require.Equal(t, http.StatusOK, send(t, http.MethodGet, url, env.coachToken, "").StatusCode)
+
+	invitation := env.invite(t, store.CoachAccessReadWrite)
+	relationship, err := env.coaching.GetRelationship(context.Background(), invitation)
+	require.NoError(t, err)
+	assert.Equal(t, store.CoachingPending, relationship.Status)
+	assert.Nil(t, relationship.RespondedAt)
+
+	// ? the old read grant is gone until the athlete accepts again
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodGet, url, env.coachToken, "").StatusCode)
+	coaches, err := env.coaching.ListCoaches(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Empty(t, coaches)
+}
+
+// * coachTestEnv --> athlete (id 1), coach (id 2) and another user (id 3), workout and coach routes wired like routes.go
+type coachTestEnv struct {
+	server       *httptest.Server
+	users        store.UserStore
+	coaching     *memoryCoaching
+	athleteToken string
+	coachToken   string
+	otherToken   string
+}
+
+func newCoachTestEnv(t *testing.T) *coachTestEnv {
+	t.Helper()
+	db := store.NewMemoryDB()
+	users := store.NewMemoryUserStore(db)
+	tokenStore := store.NewMemoryTokenStore(db)
+	env := &coachTestEnv{users: users, coaching: newMemoryCoaching(users)}
+	env.athleteToken = loginAs(t, users, tokenStore, "athlete")
+	env.coachToken = loginAs(t, users, tokenStore, "coach")
+	env.otherToken = loginAs(t, users, tokenStore, "other")
+	require.NoError(t, users.SetUserRole(context.Background(), 2, store.RoleCoach))
+
+	logger := slog.New(slog.DiscardHandler)
+	workouts := NewWorkoutHandler(store.NewMemoryWorkoutStore(db), env.coaching, metrics.New(), logger)
+	coaches := NewCoachHandler(env.coaching, users, logger)
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+	coachOnly := func(next http.HandlerFunc) http.HandlerFunc {
+		return mw.RequireUser(mw.RequirePermission(rbac.PermCoachAthletes, next))
+	}
+
+	r := chi.NewRouter()
+	r.Use(mw.Authenticate)
+	r.Get("/workouts/{id}", mw.RequireUser(workouts.HandleWorkoutByID))
+	r.Post("/workouts", mw.RequireUser(workouts.HandleCreateWorkout))
+	r.Put("/workouts/{id}", mw.RequireUser(workouts.HandleUpdateWorkoutByID))
+	r.Delete("/workouts/{id}", mw.RequireUser(workouts.HandleDeleteWorkoutByID))
+	r.Post("/coach/athletes/{id}/workouts", coachOnly(workouts.HandleCreateAthleteWorkout))
+	r.Post("/coach/invitations", coachOnly(coaches.HandleInviteAthlete))
+	r.Post("/users/me/invitations/{id}/accept", mw.RequireUser(coaches.HandleAcceptInvitation))
+	r.Post("/users/me/invitations/{id}/decline", mw.RequireUser(coaches.HandleDeclineInvitation))
+	r.Delete("/users/me/coaches/{id}", mw.RequireUser(coaches.HandleRevokeCoach))
+
+	env.server = httptest.NewServer(r)
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+// * invite --> the coach invites the athlete, returns the invitation id
+func (e *coachTestEnv) invite(t *testing.T, access string) int64 {
+	t.Helper()
+	res := send(t, http.MethodPost, e.server.URL+"/coach/invitations", e.coachToken, `{"athlete_username": "athlete", "access": "`+access+`"}`)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var body struct {
+		Invitation store.CoachingRelationship `json:"invitation"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return body.Invitation.ID
+}
+
+// * coach --> invitation sent and accepted, the coach now has access
+func (e *coachTestEnv) coach(t *testing.T, access string) {
+	t.Helper()
+	invitation := e.invite(t, access)
+	res := send(t, http.MethodPost, fmt.Sprintf("%s/users/me/invitations/%d/accept", e.server.URL, invitation), e.athleteToken, "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+// * athleteWorkout --> a workout the athlete logged themselves
+func (e *coachTestEnv) athleteWorkout(t *testing.T) int {
+	t.Helper()
+	res := send(t, http.MethodPost, e.server.URL+"/workouts", e.athleteToken, `{"title": "long run", "duration_minutes": 90}`)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var body struct {
+		Workout store.Workout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return body.Workout.ID
+}
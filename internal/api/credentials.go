@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/utils"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//! loginError --> why a credential check failed and how to answer it
+//? handlers decide the format (JSON for /tokens, html for the oauth consent page)
+type loginError struct {
+	status     int
+	code       string //* utils.Code* --> problem code of the JSON answer
+	message    string
+	retryAfter time.Duration //* only set for 429 responses
+}
+
+var errLoginInternal = &loginError{status: http.StatusInternalServerError, code: utils.CodeInternal, message: "internal server error"}
+
+//! credentialChecker --> password and second factor checks shared by every login flow
+//! Keeps throttling and the audit trail identical no matter where credentials are typed in
+type credentialChecker struct {
+	userStore  store.UserStore
+	totpStore  store.TOTPStore
+	auditStore store.AuditStore
+	throttler  *throttle.LoginThrottler
+	logger     *slog.Logger
+	now        func() time.Time //* swappable clock --> tests pass a fixed time
+}
+
+//! checkThrottle --> refuses early while username or ip is backing off / locked out
+func (c *credentialChecker) checkThrottle(ctx context.Context, username string, ip string) *loginError {
+	wait, err := c.throttler.Check(ctx, username, ip)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "checking login throttle", "error", err)
+		return errLoginInternal
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	recordAuditEvent(ctx, c.auditStore, c.logger, &store.AuditEvent{Event: store.AuditLoginThrottled, Username: username, IPAddress: ip})
+	return &loginError{status: http.StatusTooManyRequests, code: utils.CodeTooManyAttempts, message: "too many failed login attempts, try again later", retryAfter: wait}
+}
+
+//! checkPassword --> throttle, lookup and password comparison in one step
+//! Unknown usernames and wrong passwords fail with the same message
+func (c *credentialChecker) checkPassword(ctx context.Context, username string, password string, ip string) (*store.User, *loginError) {
+	if loginErr := c.checkThrottle(ctx, username, ip); loginErr != nil {
+		return nil, loginErr
+	}
+
+	user, err := c.userStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "GetUserByUsername", "error", err)
+		return nil, errLoginInternal
+	}
+	if user == nil {
+		//? unknown usernames count as failures too, otherwise they'd be free to probe
+		c.recordFailure(ctx, username, ip, nil, store.AuditLoginFailed, "unknown username")
+		return nil, &loginError{status: http.StatusUnauthorized, code: utils.CodeInvalidCredentials, message: "invalid credentials"}
+	}
+
+	passwordsDoMatch, err := user.PasswordHash.Matches(password)
+	if err != nil {
+		//? a corrupted hash is our bug, not a wrong password --> 500 and a log line, no failure counted
+		c.logger.ErrorContext(ctx, "PasswordHash.Matches", "user_id", user.ID, "error", err)
+		return nil, errLoginInternal
+	}
+	if !passwordsDoMatch {
+		c.recordFailure(ctx, user.Username, ip, user, store.AuditLoginFailed, "wrong password")
+		return nil, &loginError{status: http.StatusUnauthorized, code: utils.CodeInvalidCredentials, message: "invalid credentials"}
+	}
+
+	//* the plaintext is only ever known here --> upgrade bcrypt / outdated argon2 parameters now
+	if user.PasswordHash.NeedsRehash() {
+		err = c.userStore.RehashPassword(ctx, user, password)
+		if err != nil {
+			//? the login itself is fine, the next one will try again
+			c.logger.ErrorContext(ctx, "RehashPassword", "user_id", user.ID, "error", err)
+		}
+	}
+
+	//? the throttle counter stays until the whole login succeeded, see recordSuccess
+	recordAuditEvent(ctx, c.auditStore, c.logger, &store.AuditEvent{Event: store.AuditLoginSucceeded, UserID: &user.ID, Username: user.Username, IPAddress: ip})
+	return user, nil
+}
+
+//! checkCurrentPassword --> re-authentication of a logged-in user (password change), same throttle and audit trail as a login
+//? a stolen session token must not become an unthrottled password oracle
+func (c *credentialChecker) checkCurrentPassword(ctx context.Context, user *store.User, password string, ip string) *loginError {
+	if loginErr := c.checkThrottle(ctx, user.Username, ip); loginErr != nil {
+		return loginErr
+	}
+
+	match, err := user.PasswordHash.Matches(password)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "PasswordHash.Matches", "user_id", user.ID, "error", err)
+		return errLoginInternal
+	}
+	if !match {
+		c.recordFailure(ctx, user.Username, ip, user, store.AuditLoginFailed, "wrong current password")
+		return &loginError{status: http.StatusForbidden, code: utils.CodeInvalidCredentials, message: "current password is incorrect"}
+	}
+	return nil
+}
+
+//! loadTOTP --> the user's 2FA credential, nil when the user never enrolled
+func (c *credentialChecker) loadTOTP(ctx context.Context, user *store.User) (*store.TOTPCredential, *loginError) {
+	credential, err := c.totpStore.GetTOTP(ctx, user.ID)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "GetTOTP", "error", err)
+		return nil, errLoginInternal
+	}
+	return credential, nil
+}
+
+//! checkSecondFactor --> TOTP or recovery code against a confirmed credential
+//! Wrong codes count against the same throttle budget as wrong passwords
+func (c *credentialChecker) checkSecondFactor(ctx context.Context, user *store.User, credential *store.TOTPCredential, code string, recoveryCode string, ip string) *loginError {
+	if loginErr := c.checkThrottle(ctx, user.Username, ip); loginErr != nil {
+		return loginErr
+	}
+
+	ok, err := verifySecondFactor(ctx, c.totpStore, credential, code, recoveryCode, c.now())
+	if err != nil {
+		c.logger.ErrorContext(ctx, "verifying second factor", "error", err)
+		return errLoginInternal
+	}
+	if !ok {
+		c.recordFailure(ctx, user.Username, ip, user, store.AuditMFAFailed, "invalid one-time code")
+		return &loginError{status: http.StatusUnauthorized, code: utils.CodeInvalidOTP, message: "invalid one-time code"}
+	}
+	return nil
+}
+
+//! checkCurrentSecondFactor --> re-authentication with the second factor (mfa settings), same throttle and audit trail as the login step
+//? without it a stolen session could guess codes at full speed and then turn 2FA off or mint recovery codes
+//? a wrong code stays a 400 here --> a 401 would read like the session itself was rejected
+func (c *credentialChecker) checkCurrentSecondFactor(ctx context.Context, user *store.User, credential *store.TOTPCredential, code string, recoveryCode string, ip string) *loginError {
+	loginErr := c.checkSecondFactor(ctx, user, credential, code, recoveryCode, ip)
+	if loginErr != nil && loginErr.code == utils.CodeInvalidOTP {
+		return &loginError{status: http.StatusBadRequest, code: utils.CodeInvalidOTP, message: loginErr.message}
+	}
+	return loginErr
+}
+
+//! recordSuccess --> clears the username's failure counter once every factor passed
+//? clearing it after the password alone would hand a password holder a fresh budget for guessing codes
+func (c *credentialChecker) recordSuccess(ctx context.Context, user *store.User) {
+	err := c.throttler.RecordSuccess(ctx, user.Username)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "clearing login attempts", "error", err)
+	}
+}
+
+//! recordFailure --> bumps the throttle counters and writes the audit trail
+func (c *credentialChecker) recordFailure(ctx context.Context, username string, ip string, user *store.User, event string, detail string) {
+	lockedOut, err := c.throttler.RecordFailure(ctx, username, ip)
+	if err != nil {
+		c.logger.ErrorContext(ctx, "recording login failure", "error", err)
+	}
+
+	recordAuditEvent(ctx, c.auditStore, c.logger, &store.AuditEvent{Event: event, UserID: auditUserID(user), Username: username, IPAddress: ip, Detail: detail})
+	if lockedOut {
+		recordAuditEvent(ctx, c.auditStore, c.logger, &store.AuditEvent{Event: store.AuditLoginLockedOut, UserID: auditUserID(user), Username: username, IPAddress: ip})
+	}
+}
+
+//! writeLoginError --> JSON answer for API login endpoints
+func writeLoginError(w http.ResponseWriter, req *http.Request, loginErr *loginError) {
+	setRetryAfter(w, loginErr)
+	utils.WriteError(w, req, utils.NewError(loginErr.status, loginErr.code, loginErr.message))
+}
+
+//! setRetryAfter --> tells throttled clients when to come back
+func setRetryAfter(w http.ResponseWriter, loginErr *loginError) {
+	if loginErr.retryAfter > 0 {
+		//* Retry-After is whole seconds --> round up so clients never retry too early
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(loginErr.retryAfter.Seconds()))))
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"fem/internal/store"
+	"fem/internal/utils"
+	"fem/internal/validator"
+	"net/http"
+)
+
+//! writeConstraintError --> 409 for duplicates, 422 for bad references/values, with the field the client can fix
+//? false when err isn't a constraint violation, the caller still answers with its 500
+func writeConstraintError(w http.ResponseWriter, req *http.Request, err error) bool {
+	var constraintErr *store.ConstraintError
+	if !errors.As(err, &constraintErr) {
+		return false
+	}
+
+	apiErr := utils.NewError(http.StatusUnprocessableEntity, utils.CodeInvalidValue, constraintErr.Message)
+	switch {
+	case errors.Is(err, store.ErrDuplicate):
+		apiErr = utils.NewError(http.StatusConflict, utils.CodeAlreadyExists, constraintErr.Message)
+	case errors.Is(err, store.ErrInvalidReference):
+		apiErr.Code = utils.CodeInvalidReference
+	}
+
+	if constraintErr.Field != "" {
+		apiErr.WithFields(map[string][]string{constraintErr.Field: {constraintErr.Message}})
+	}
+	utils.WriteError(w, req, apiErr)
+	return true
+}
+
+//! writeValidationErrors --> 422 with every failed check, field --> messages
+func writeValidationErrors(w http.ResponseWriter, req *http.Request, v *validator.Validator) {
+	utils.WriteError(w, req, utils.NewError(http.StatusUnprocessableEntity, utils.CodeValidationFailed, "one or more fields are invalid").WithFields(v.Errors))
+}
+
+//! writeReadJSONError --> answer for a body utils.ReadJSON refused, its message names the problem
+func writeReadJSONError(w http.ResponseWriter, req *http.Request, err error) {
+	if errors.Is(err, utils.ErrBodyTooLarge) {
+		utils.WriteError(w, req, utils.NewError(http.StatusRequestEntityTooLarge, utils.CodeBodyTooLarge, err.Error()))
+		return
+	}
+	utils.WriteError(w, req, utils.NewError(http.StatusBadRequest, utils.CodeInvalidBody, err.Error()))
+}
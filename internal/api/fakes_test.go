@@ -0,0 +1,474 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// * test doubles embed the store interfaces --> calling an unimplemented method panics loudly
+
+// * newMemoryStores --> the store package's in-memory users and tokens, users created in order (ids 1, 2, ...)
+func newMemoryStores(t *testing.T, users ...*store.User) (*store.MemoryUserStore, *store.MemoryTokenStore) {
+	t.Helper()
+	db := store.NewMemoryDB()
+	userStore := store.NewMemoryUserStore(db)
+	for _, user := range users {
+		require.NoError(t, userStore.CreateUser(context.Background(), user))
+	}
+	return userStore, store.NewMemoryTokenStore(db)
+}
+
+// * userCount --> rows in users
+func userCount(t *testing.T, users store.UserStore) int {
+	t.Helper()
+	_, total, err := users.ListUsers(context.Background(), 1, 0)
+	require.NoError(t, err)
+	return total
+}
+
+// * storedUser --> the user as a SELECT would return it
+func storedUser(t *testing.T, users store.UserStore, id int) *store.User {
+	t.Helper()
+	user, err := users.GetUserByID(context.Background(), int64(id))
+	require.NoError(t, err)
+	return user
+}
+
+// * memoryIdentities --> linked identities and pending oidc logins, sign-up writes into the user store
+type memoryIdentities struct {
+	users      store.UserStore
+	identities []*store.UserIdentity
+	states     map[string]*store.OIDCLoginState
+}
+
+func newMemoryIdentities(users store.UserStore) *memoryIdentities {
+	return &memoryIdentities{users: users, states: map[string]*store.OIDCLoginState{}}
+}
+
+func (m *memoryIdentities) GetUserForIdentity(ctx context.Context, provider string, subject string) (*store.User, error) {
+	identity, _ := m.GetIdentity(ctx, provider, subject)
+	if identity == nil {
+		return nil, nil
+	}
+	return m.users.GetUserByID(ctx, int64(identity.UserID))
+}
+
+func (m *memoryIdentities) GetIdentity(ctx context.Context, provider string, subject string) (*store.UserIdentity, error) {
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryIdentities) CreateUserWithIdentity(ctx context.Context, user *store.User, identity *store.UserIdentity) error {
+	err := m.users.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	identity.UserID = user.ID
+	return m.LinkIdentity(ctx, identity)
+}
+
+func (m *memoryIdentities) LinkIdentity(ctx context.Context, identity *store.UserIdentity) error {
+	identity.ID = int64(len(m.identities) + 1)
+	identity.CreatedAt = time.Now()
+	m.identities = append(m.identities, identity)
+	return nil
+}
+
+func (m *memoryIdentities) ListIdentities(ctx context.Context, userID int) ([]*store.UserIdentity, error) {
+	identities := []*store.UserIdentity{}
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (m *memoryIdentities) DeleteIdentity(ctx context.Context, userID int, id int64) error {
+	for i, identity := range m.identities {
+		if identity.ID == id && identity.UserID == userID {
+			m.identities = append(m.identities[:i], m.identities[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memoryIdentities) CreateOIDCLoginState(ctx context.Context, state *store.OIDCLoginState) error {
+	m.states[string(state.Hash)] = state
+	return nil
+}
+
+func (m *memoryIdentities) ConsumeOIDCLoginState(ctx context.Context, hash []byte) (*store.OIDCLoginState, error) {
+	state := m.states[string(hash)]
+	delete(m.states, string(hash))
+	return state, nil
+}
+
+// * memoryOAuthStore --> clients and codes in maps, consume deletes like the postgres version
+type memoryOAuthStore struct {
+	clients map[string]*store.OAuthClient
+	codes   map[string]*store.OAuthAuthorizationCode
+}
+
+func (m *memoryOAuthStore) CreateClient(ctx context.Context, client *store.OAuthClient) error {
+	client.CreatedAt = time.Now()
+	m.clients[client.ID] = client
+	return nil
+}
+
+func (m *memoryOAuthStore) GetClient(ctx context.Context, id string) (*store.OAuthClient, error) {
+	return m.clients[id], nil
+}
+
+func (m *memoryOAuthStore) ListClients(ctx context.Context, userID int) ([]*store.OAuthClient, error) {
+	clients := []*store.OAuthClient{}
+	for _, client := range m.clients {
+		if client.UserID == userID {
+			clients = append(clients, client)
+		}
+	}
+	return clients, nil
+}
+
+func (m *memoryOAuthStore) DeleteClient(ctx context.Context, userID int, id string) error {
+	delete(m.clients, id)
+	return nil
+}
+
+func (m *memoryOAuthStore) CreateAuthorizationCode(ctx context.Context, code *store.OAuthAuthorizationCode) error {
+	m.codes[string(code.Hash)] = code
+	return nil
+}
+
+func (m *memoryOAuthStore) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (*store.OAuthAuthorizationCode, error) {
+	code := m.codes[string(hash)]
+	delete(m.codes, string(hash))
+	return code, nil
+}
+
+// * noTOTP --> nobody has 2FA enrolled
+type noTOTP struct {
+	store.TOTPStore
+}
+
+func (n *noTOTP) GetTOTP(ctx context.Context, userID int) (*store.TOTPCredential, error) {
+	return nil, nil
+}
+
+// * discardAudit --> audit events are not under test here
+type discardAudit struct{}
+
+func (d *discardAudit) InsertAuditEvent(ctx context.Context, event *store.AuditEvent) error {
+	return nil
+}
+
+// * noLoginAttempts --> throttling never kicks in
+type noLoginAttempts struct{}
+
+func (n *noLoginAttempts) GetLoginAttempt(ctx context.Context, kind string, subject string) (*store.LoginAttempt, error) {
+	return nil, nil
+}
+
+func (n *noLoginAttempts) RecordLoginFailure(ctx context.Context, kind string, subject string, now time.Time, window time.Duration) (int, error) {
+	return 1, nil
+}
+
+func (n *noLoginAttempts) BlockLogin(ctx context.Context, kind string, subject string, until time.Time) error {
+	return nil
+}
+
+func (n *noLoginAttempts) ClearLoginAttempts(ctx context.Context, kind string, subject string) error {
+	return nil
+}
+
+// * memoryCoaching --> coach_athletes rows, same pending/accepted rules and upsert as the postgres store
+type memoryCoaching struct {
+	users         store.UserStore // * usernames for the joined columns
+	relationships []*store.CoachingRelationship
+}
+
+func newMemoryCoaching(users store.UserStore) *memoryCoaching {
+	return &memoryCoaching{users: users}
+}
+
+func (m *memoryCoaching) find(match func(r *store.CoachingRelationship) bool) []*store.CoachingRelationship {
+	found := []*store.CoachingRelationship{}
+	for _, r := range m.relationships {
+		if match(r) {
+			copied := *r
+			found = append(found, &copied)
+		}
+	}
+	return found
+}
+
+func (m *memoryCoaching) CreateInvitation(ctx context.Context, coachID int, athleteID int, access string) (*store.CoachingRelationship, error) {
+	for _, r := range m.relationships {
+		if r.CoachID == coachID && r.AthleteID == athleteID {
+			r.Access, r.Status, r.RespondedAt, r.CreatedAt = access, store.CoachingPending, nil, time.Now()
+			return m.GetRelationship(ctx, r.ID)
+		}
+	}
+	coach, _ := m.users.GetUserByID(ctx, int64(coachID))
+	athlete, _ := m.users.GetUserByID(ctx, int64(athleteID))
+	r := &store.CoachingRelationship{ID: int64(len(m.relationships) + 1), CoachID: coachID, CoachUsername: coach.Username, AthleteID: athleteID, AthleteUsername: athlete.Username, Access: access, Status: store.CoachingPending, CreatedAt: time.Now()}
+	m.relationships = append(m.relationships, r)
+	return m.GetRelationship(ctx, r.ID)
+}
+
+func (m *memoryCoaching) GetRelationship(ctx context.Context, id int64) (*store.CoachingRelationship, error) {
+	found := m.find(func(r *store.CoachingRelationship) bool { return r.ID == id })
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return found[0], nil
+}
+
+func (m *memoryCoaching) ListPendingInvitations(ctx context.Context, athleteID int) ([]*store.CoachingRelationship, error) {
+	return m.find(func(r *store.CoachingRelationship) bool {
+		return r.AthleteID == athleteID && r.Status == store.CoachingPending
+	}), nil
+}
+
+func (m *memoryCoaching) RespondToInvitation(ctx context.Context, id int64, athleteID int, accept bool) error {
+	for _, r := range m.relationships {
+		if r.ID == id && r.AthleteID == athleteID && r.Status == store.CoachingPending {
+			respondedAt := time.Now()
+			r.Status, r.RespondedAt = store.CoachingDeclined, &respondedAt
+			if accept {
+				r.Status = store.CoachingAccepted
+			}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memoryCoaching) ListAthletes(ctx context.Context, coachID int) ([]*store.CoachingRelationship, error) {
+	return m.find(func(r *store.CoachingRelationship) bool {
+		return r.CoachID == coachID && r.Status == store.CoachingAccepted
+	}), nil
+}
+
+func (m *memoryCoaching) ListCoaches(ctx context.Context, athleteID int) ([]*store.CoachingRelationship, error) {
+	return m.find(func(r *store.CoachingRelationship) bool {
+		return r.AthleteID == athleteID && r.Status == store.CoachingAccepted
+	}), nil
+}
+
+func (m *memoryCoaching) DeleteRelationship(ctx context.Context, coachID int, athleteID int) error {
+	for i, r := range m.relationships {
+		if r.CoachID == coachID && r.AthleteID == athleteID {
+			m.relationships = append(m.relationships[:i], m.relationships[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memoryCoaching) GetCoachAccess(ctx context.Context, coachID int, athleteID int) (string, error) {
+	for _, r := range m.relationships {
+		if r.CoachID == coachID && r.AthleteID == athleteID && r.Status == store.CoachingAccepted {
+			return r.Access, nil
+		}
+	}
+	return "", nil
+}
+
+// * memoryAPIKeys --> api_keys rows, lookups skip expired keys and stamp last_used_at like the postgres store
+type memoryAPIKeys struct {
+	users store.UserStore
+	keys  []*store.APIKey
+}
+
+func newMemoryAPIKeys(users store.UserStore) *memoryAPIKeys {
+	return &memoryAPIKeys{users: users}
+}
+
+func (m *memoryAPIKeys) CreateAPIKey(ctx context.Context, key *store.APIKey) error {
+	key.ID = int64(len(m.keys) + 1)
+	key.CreatedAt = time.Now()
+	stored := *key
+	m.keys = append(m.keys, &stored)
+	return nil
+}
+
+func (m *memoryAPIKeys) ListAPIKeys(ctx context.Context, userID int) ([]*store.APIKey, error) {
+	keys := []*store.APIKey{}
+	for _, key := range m.keys {
+		if key.UserID == userID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryAPIKeys) DeleteAPIKey(ctx context.Context, userID int, id int64) error {
+	for i, key := range m.keys {
+		if key.ID == id && key.UserID == userID {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *memoryAPIKeys) GetUserForAPIKey(ctx context.Context, plaintext string) (*store.User, *store.APIKey, error) {
+	hash := tokens.Hash(plaintext)
+	for _, key := range m.keys {
+		if string(key.Hash) == string(hash) && key.Expiry.After(time.Now()) {
+			usedAt := time.Now()
+			key.LastUsedAt = &usedAt
+			user, err := m.users.GetUserByID(ctx, int64(key.UserID))
+			copied := *key
+			return user, &copied, err
+		}
+	}
+	return nil, nil, nil
+}
+
+// * confirmedTOTP --> every user has finished 2FA enrollment with the same secret
+type confirmedTOTP struct {
+	store.TOTPStore
+	secret string
+}
+
+func (c *confirmedTOTP) GetTOTP(ctx context.Context, userID int) (*store.TOTPCredential, error) {
+	confirmedAt := time.Now()
+	return &store.TOTPCredential{UserID: userID, Secret: c.secret, ConfirmedAt: &confirmedAt}, nil
+}
+
+func (c *confirmedTOTP) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+// * memoryLoginAttempts --> counts failures like login_attempts, windows are ignored
+type memoryLoginAttempts struct {
+	attempts map[string]*store.LoginAttempt // * kind + ":" + subject
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{attempts: map[string]*store.LoginAttempt{}}
+}
+
+func (m *memoryLoginAttempts) GetLoginAttempt(ctx context.Context, kind string, subject string) (*store.LoginAttempt, error) {
+	return m.attempts[kind+":"+subject], nil
+}
+
+func (m *memoryLoginAttempts) RecordLoginFailure(ctx context.Context, kind string, subject string, now time.Time, window time.Duration) (int, error) {
+	attempt := m.attempts[kind+":"+subject]
+	if attempt == nil {
+		attempt = &store.LoginAttempt{SubjectType: kind, Subject: subject}
+		m.attempts[kind+":"+subject] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = &now
+	return attempt.Failures, nil
+}
+
+func (m *memoryLoginAttempts) BlockLogin(ctx context.Context, kind string, subject string, until time.Time) error {
+	m.attempts[kind+":"+subject].BlockedUntil = &until
+	return nil
+}
+
+func (m *memoryLoginAttempts) ClearLoginAttempts(ctx context.Context, kind string, subject string) error {
+	delete(m.attempts, kind+":"+subject)
+	return nil
+}
+
+// * memoryTOTP --> user_totp and totp_recovery_codes in maps, same replay and single-use rules
+type memoryTOTP struct {
+	credentials   map[int]*store.TOTPCredential
+	recoveryCodes map[int]map[string]bool // * user id --> code hash --> still unused
+}
+
+func newMemoryTOTP() *memoryTOTP {
+	return &memoryTOTP{credentials: map[int]*store.TOTPCredential{}, recoveryCodes: map[int]map[string]bool{}}
+}
+
+func (m *memoryTOTP) GetTOTP(ctx context.Context, userID int) (*store.TOTPCredential, error) {
+	credential := m.credentials[userID]
+	if credential == nil {
+		return nil, nil
+	}
+	copied := *credential
+	return &copied, nil
+}
+
+func (m *memoryTOTP) SaveTOTPSecret(ctx context.Context, userID int, secret string) error {
+	m.credentials[userID] = &store.TOTPCredential{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (m *memoryTOTP) ConfirmTOTP(ctx context.Context, userID int) error {
+	if m.credentials[userID] == nil {
+		return sql.ErrNoRows
+	}
+	confirmedAt := time.Now()
+	m.credentials[userID].ConfirmedAt = &confirmedAt
+	return nil
+}
+
+func (m *memoryTOTP) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	credential := m.credentials[userID]
+	if credential == nil || credential.LastUsedStep >= step {
+		return false, nil
+	}
+	credential.LastUsedStep = step
+	return true, nil
+}
+
+func (m *memoryTOTP) DeleteTOTP(ctx context.Context, userID int) error {
+	delete(m.credentials, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m *memoryTOTP) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes [][]byte) error {
+	m.recoveryCodes[userID] = map[string]bool{}
+	for _, hash := range codeHashes {
+		m.recoveryCodes[userID][string(hash)] = true
+	}
+	return nil
+}
+
+func (m *memoryTOTP) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error) {
+	if !m.recoveryCodes[userID][string(codeHash)] {
+		return false, nil
+	}
+	m.recoveryCodes[userID][string(codeHash)] = false
+	return true, nil
+}
+
+// * memoryAudit --> keeps every event so tests can check the audit trail
+type memoryAudit struct {
+	events []*store.AuditEvent
+}
+
+func (m *memoryAudit) InsertAuditEvent(ctx context.Context, event *store.AuditEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+// * count --> how many events with this name were written
+func (m *memoryAudit) count(event string) int {
+	n := 0
+	for _, recorded := range m.events {
+		if recorded.Event == event {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"fem/internal/mailer"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//! magicLinkTTL --> how long an emailed login link stays valid
+const magicLinkTTL = 10 * time.Minute
+
+//! magicLinkResendAfter --> a new link is only mailed once the previous one is this old
+//? keeps POST /tokens/magic-link from flooding someone's inbox
+const magicLinkResendAfter = time.Minute
+
+//! magicLinkSendTimeout --> delivery runs after the response, it still must not hang forever
+const magicLinkSendTimeout = 30 * time.Second
+
+type MagicLinkHandler struct {
+	tokenStore store.TokenStore
+	userStore  store.UserStore
+	auditStore store.AuditStore
+	mailer     mailer.Mailer
+	tokens     *TokenHandler //* finishes the login exactly like a password login (2FA, suspension)
+	linkURL    string        //* page the emailed link opens, it POSTs the token to the exchange endpoint
+	logger     *slog.Logger
+	now        func() time.Time
+}
+
+//! requestMagicLinkRequest --> where to send the link
+type requestMagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+//! exchangeMagicLinkRequest --> the token from the emailed link
+type exchangeMagicLinkRequest struct {
+	Token string `json:"token"`
+}
+
+//! NewMagicLinkHandler --> constructor for passwordless login endpoints
+func NewMagicLinkHandler(tokenStore store.TokenStore, userStore store.UserStore, auditStore store.AuditStore, mailer mailer.Mailer, tokenHandler *TokenHandler, linkURL string, logger *slog.Logger) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		tokenStore: tokenStore,
+		userStore:  userStore,
+		auditStore: auditStore,
+		mailer:     mailer,
+		tokens:     tokenHandler,
+		linkURL:    linkURL,
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+//! HandleRequestMagicLink --> POST /tokens/magic-link
+//! Emails a one-time login link; the answer is the same whether or not the email is registered
+func (h *MagicLinkHandler) HandleRequestMagicLink(w http.ResponseWriter, req *http.Request) {
+	var body requestMagicLinkRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	body.Email = strings.TrimSpace(body.Email)
+	if body.Email == "" {
+		utils.BadRequest(w, req, "email is required")
+		return
+	}
+
+	user, err := h.userStore.GetUserByEmail(req.Context(), body.Email)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetUserByEmail", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	//? unknown and suspended accounts get the same 202, otherwise this endpoint tells who is registered
+	if user != nil && !user.IsSuspended() {
+		err = h.sendLink(req, user)
+		if err != nil {
+			h.logger.ErrorContext(req.Context(), "sending magic link", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+	}
+
+	utils.WriteJson(w, http.StatusAccepted, utils.Envelope{"message": "if the email belongs to an account, a login link is on its way"})
+}
+
+//! sendLink --> issues the token and hands the email to the mailer in the background
+func (h *MagicLinkHandler) sendLink(req *http.Request, user *store.User) error {
+	//* a link that expires after the resend cutoff was issued less than magicLinkResendAfter ago
+	recent, err := h.tokenStore.HasTokenExpiringAfter(req.Context(), user.ID, tokens.ScopeMagicLink, h.now().Add(magicLinkTTL-magicLinkResendAfter))
+	if err != nil {
+		return err
+	}
+	if recent {
+		return nil
+	}
+
+	token, err := h.tokenStore.CreateNewToken(req.Context(), user.ID, magicLinkTTL, tokens.ScopeMagicLink)
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(h.linkURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token.Plaintext)
+	link.RawQuery = query.Encode()
+
+	msg := &mailer.Message{
+		To:      user.Email,
+		Subject: "Your FitTrack login link",
+		Body: fmt.Sprintf("Hi %s,\n\nopen this link to log in to FitTrack:\n\n%s\n\nThe link works once and expires in %d minutes. If you didn't ask for it, you can ignore this email.\n",
+			user.Username, link.String(), int(magicLinkTTL.Minutes())),
+	}
+
+	//* sending after the response keeps slow smtp servers from revealing which emails exist
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), magicLinkSendTimeout)
+	go func() {
+		defer cancel()
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			h.logger.ErrorContext(ctx, "mailing magic link", "user_id", user.ID, "error", err)
+		}
+	}()
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditMagicLinkSent, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	return nil
+}
+
+//! HandleExchangeMagicLink --> POST /tokens/magic-link/exchange
+//! Redeems the emailed token (once) for an authentication token, or an mfa token for 2FA users
+func (h *MagicLinkHandler) HandleExchangeMagicLink(w http.ResponseWriter, req *http.Request) {
+	var body exchangeMagicLinkRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	if body.Token == "" {
+		utils.BadRequest(w, req, "token is required")
+		return
+	}
+
+	user, err := h.tokenStore.ConsumeToken(req.Context(), tokens.ScopeMagicLink, body.Token)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ConsumeToken", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if user == nil {
+		utils.WriteError(w, req, utils.NewError(http.StatusUnauthorized, utils.CodeInvalidToken, "login link has been expired or invalid"))
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditLoginSucceeded, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req), Detail: "magic-link"})
+	h.tokens.respondWithLoginToken(w, req, user, session.Requested(req))
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/mailer"
+	"fem/internal/metrics"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// * outbox --> mailer that hands messages to the test
+type outbox chan *mailer.Message
+
+func (o outbox) Send(ctx context.Context, msg *mailer.Message) error {
+	o <- msg
+	return nil
+}
+
+// * newMagicLinkTestHandler --> ayush (id 1) and the suspended banned (id 2)
+func newMagicLinkTestHandler(t *testing.T) (*MagicLinkHandler, *store.MemoryTokenStore, outbox) {
+	t.Helper()
+	users, tokenStore := newMemoryStores(t,
+		&store.User{Username: "ayush", Email: "ayush@example.com"},
+		&store.User{Username: "banned", Email: "banned@example.com"},
+	)
+	require.NoError(t, users.SetUserSuspended(context.Background(), 2, true))
+	logger := slog.New(slog.DiscardHandler)
+	tokenHandler := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, metrics.New(), logger)
+	mails := make(outbox, 10)
+	return NewMagicLinkHandler(tokenStore, users, &discardAudit{}, mails, tokenHandler, "https://app.example.com/magic-link", logger), tokenStore, mails
+}
+
+func postJSON(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+// ! TestMagicLinkLogin --> emailed link logs in exactly once
+func TestMagicLinkLogin(t *testing.T) {
+	h, _, mails := newMagicLinkTestHandler(t)
+
+	rr := postJSON(h.HandleRequestMagicLink, `{"email": "Ayush@Example.com"}`)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	var msg *mailer.Message
+	select {
+	case msg = <-mails:
+	case <-time.After(time.Second):
+		t.Fatal("no email was sent")
+	}
+	assert.Equal(t, "ayush@example.com", msg.To)
+
+	link, err := url.Parse(regexp.MustCompile(`https://\S+`).FindString(msg.Body))
+	require.NoError(t, err)
+	assert.Equal(t, "app.example.com", link.Host)
+	token := link.Query().Get("token")
+	require.NotEmpty(t, token)
+
+	rr = postJSON(h.HandleExchangeMagicLink, `{"token": "`+token+`"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var body map[string]map[string]interface{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.NotEmpty(t, body["auth_token"]["token"])
+
+	// ? single use
+	rr = postJSON(h.HandleExchangeMagicLink, `{"token": "`+token+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+// ! TestMagicLinkRequest --> same answer for everyone, mail only for active accounts and not too often
+func TestMagicLinkRequest(t *testing.T) {
+	test := []struct {
+		name      string
+		emails    []string
+		wantMails int
+	}{
+		{name: "unknown email", emails: []string{"nobody@example.com"}, wantMails: 0},
+		{name: "suspended account", emails: []string{"banned@example.com"}, wantMails: 0},
+		{name: "repeated requests", emails: []string{"ayush@example.com", "ayush@example.com", "ayush@example.com"}, wantMails: 1},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, mails := newMagicLinkTestHandler(t)
+			for _, email := range tt.emails {
+				rr := postJSON(h.HandleRequestMagicLink, `{"email": "`+email+`"}`)
+				assert.Equal(t, http.StatusAccepted, rr.Code)
+			}
+
+			// * mails go out in the background --> wait for the expected ones, then make sure nothing else follows
+			for i := 0; i < tt.wantMails; i++ {
+				select {
+				case <-mails:
+				case <-time.After(time.Second):
+					t.Fatalf("only %d of %d emails were sent", i, tt.wantMails)
+				}
+			}
+			select {
+			case msg := <-mails:
+				t.Errorf("unexpected email to %s", msg.To)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+// ! TestMagicLinkExpired --> old links are refused
+func TestMagicLinkExpired(t *testing.T) {
+	h, tokenStore, _ := newMagicLinkTestHandler(t)
+	token, err := tokenStore.CreateNewToken(context.Background(), 1, -time.Minute, tokens.ScopeMagicLink)
+	require.NoError(t, err)
+
+	rr := postJSON(h.HandleExchangeMagicLink, `{"token": "`+token.Plaintext+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"fem/internal/middleware"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/totp"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+//! recoveryCodeCount --> how many backup codes a user gets per (re)generation
+const recoveryCodeCount = 10
+
+//! types declaration
+type MFAHandler struct {
+	totpStore   store.TOTPStore    //* secrets and recovery codes
+	auditStore  store.AuditStore   //* 2FA turned on/off, recovery codes renewed
+	credentials *credentialChecker //* every code check is throttled and audited like a login
+	logger      *slog.Logger       //* for error logging
+	issuer      string             //* name shown in the authenticator app
+}
+
+//! totpCodeRequest --> body for every endpoint that needs proof of the second factor
+type totpCodeRequest struct {
+	Code         string `json:"code"`          //* 6 digit code from the authenticator app
+	RecoveryCode string `json:"recovery_code"` //* alternatively one unused recovery code
+}
+
+//! NewMFAHandler --> constructor for two-factor enrollment endpoints
+func NewMFAHandler(totpStore store.TOTPStore, auditStore store.AuditStore, throttler *throttle.LoginThrottler, issuer string, logger *slog.Logger) *MFAHandler {
+	return &MFAHandler{
+		totpStore:  totpStore,
+		auditStore: auditStore,
+		credentials: &credentialChecker{
+			totpStore:  totpStore,
+			auditStore: auditStore,
+			throttler:  throttler,
+			logger:     logger,
+			now:        time.Now,
+		},
+		logger: logger,
+		issuer: issuer,
+	}
+}
+
+//! HandleEnrollTOTP --> POST /users/me/mfa/totp
+//! Generates a fresh secret; 2FA is not enforced until the user confirms it with a code
+func (h *MFAHandler) HandleEnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	credential, err := h.totpStore.GetTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	//? already enabled --> must disable first, otherwise anyone with a stolen session could swap the secret
+	if credential.IsConfirmed() {
+		utils.Conflict(w, req, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GenerateSecret", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	err = h.totpStore.SaveTOTPSecret(req.Context(), user.ID, secret)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "SaveTOTPSecret", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusCreated, utils.Envelope{"totp": utils.Envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.DefaultConfig.URI(h.issuer, user.Username, secret),
+		"qr_code_url": "/users/me/mfa/totp/qr.png",
+	}})
+}
+
+//! HandleTOTPQRCode --> GET /users/me/mfa/totp/qr.png
+//! Only available while enrollment is pending so the secret can't be re-read later
+func (h *MFAHandler) HandleTOTPQRCode(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	credential, err := h.totpStore.GetTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if credential == nil || credential.IsConfirmed() {
+		utils.NotFound(w, req, "no pending two-factor enrollment")
+		return
+	}
+
+	png, err := totp.QRCodePNG(totp.DefaultConfig.URI(h.issuer, user.Username, credential.Secret), 256)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "QRCodePNG", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store") //* never cache an image that encodes a secret
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+//! HandleConfirmTOTP --> POST /users/me/mfa/totp/confirm
+//! Proves the authenticator works, turns 2FA on and hands out recovery codes
+func (h *MFAHandler) HandleConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	var body totpCodeRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	credential, err := h.totpStore.GetTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if credential == nil {
+		utils.NotFound(w, req, "no pending two-factor enrollment")
+		return
+	}
+	if credential.IsConfirmed() {
+		utils.Conflict(w, req, "two-factor authentication is already enabled")
+		return
+	}
+
+	//* only a code from the app proves it is set up, there are no recovery codes yet
+	loginErr := h.credentials.checkCurrentSecondFactor(req.Context(), user, credential, body.Code, "", utils.ClientIP(req))
+	if loginErr != nil {
+		writeLoginError(w, req, loginErr)
+		return
+	}
+
+	err = h.totpStore.ConfirmTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ConfirmTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	codes, err := h.replaceRecoveryCodes(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "replacing recovery codes", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditMFAEnabled, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	//* plaintext codes are only ever shown in this response
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+//! HandleRegenerateRecoveryCodes --> POST /users/me/mfa/recovery-codes
+//! Requires a current TOTP code; old recovery codes stop working immediately
+func (h *MFAHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	var body totpCodeRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	credential, err := h.totpStore.GetTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if !credential.IsConfirmed() {
+		utils.NotFound(w, req, "two-factor authentication is not enabled")
+		return
+	}
+
+	//? recovery codes can't be used to mint new recovery codes
+	loginErr := h.credentials.checkCurrentSecondFactor(req.Context(), user, credential, body.Code, "", utils.ClientIP(req))
+	if loginErr != nil {
+		writeLoginError(w, req, loginErr)
+		return
+	}
+
+	codes, err := h.replaceRecoveryCodes(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "replacing recovery codes", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditRecoveryCodesRenewed, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"recovery_codes": codes})
+}
+
+//! HandleDisableTOTP --> DELETE /users/me/mfa/totp
+//! Turning 2FA off needs the second factor too, a stolen session alone is not enough
+func (h *MFAHandler) HandleDisableTOTP(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	var body totpCodeRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	credential, err := h.totpStore.GetTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if credential == nil {
+		utils.NotFound(w, req, "two-factor authentication is not enabled")
+		return
+	}
+
+	//* a pending (unconfirmed) enrollment can be dropped without a code
+	if credential.IsConfirmed() {
+		loginErr := h.credentials.checkCurrentSecondFactor(req.Context(), user, credential, body.Code, body.RecoveryCode, utils.ClientIP(req))
+		if loginErr != nil {
+			writeLoginError(w, req, loginErr)
+			return
+		}
+	}
+
+	err = h.totpStore.DeleteTOTP(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteTOTP", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	if credential.IsConfirmed() {
+		recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditMFADisabled, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//! replaceRecoveryCodes --> generates a new batch and stores only their hashes
+func (h *MFAHandler) replaceRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, tokens.Hash(code))
+	}
+
+	err = h.totpStore.ReplaceRecoveryCodes(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+//! verifySecondFactor --> accepts either a current TOTP code or an unused recovery code
+//? shared by the login exchange and the enrollment endpoints
+func verifySecondFactor(ctx context.Context, totpStore store.TOTPStore, credential *store.TOTPCredential, code string, recoveryCode string, now time.Time) (bool, error) {
+	if recoveryCode != "" {
+		return totpStore.UseRecoveryCode(ctx, credential.UserID, tokens.Hash(totp.NormalizeRecoveryCode(recoveryCode)))
+	}
+
+	step, ok, err := totp.DefaultConfig.Validate(credential.Secret, code, now)
+	if err != nil || !ok {
+		return false, err
+	}
+	//* same code can't be used twice inside its validity window
+	return totpStore.UseTOTPStep(ctx, credential.UserID, step)
+}
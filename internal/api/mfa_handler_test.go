@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/middleware"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/totp"
+	"fem/internal/utils"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestTOTPLifecycleAudited --> turning 2FA on, renewing recovery codes and turning it off all land in the audit log
+func TestTOTPLifecycleAudited(t *testing.T) {
+	env := newMFATestEnv(t)
+
+	status, body := env.send(t, http.MethodPost, "/users/me/mfa/totp", "")
+	require.Equal(t, http.StatusCreated, status)
+	secret := body["totp"].(map[string]interface{})["secret"].(string)
+
+	status, body = env.send(t, http.MethodPost, "/users/me/mfa/totp/confirm", fmt.Sprintf(`{"code": %q}`, env.code(t, secret)))
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["recovery_codes"], recoveryCodeCount)
+	assert.Equal(t, 1, env.audit.count(store.AuditMFAEnabled))
+
+	env.now = env.now.Add(totp.DefaultConfig.Period) // * the confirming code's step is spent
+	status, body = env.send(t, http.MethodPost, "/users/me/mfa/recovery-codes", fmt.Sprintf(`{"code": %q}`, env.code(t, secret)))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, env.audit.count(store.AuditRecoveryCodesRenewed))
+	recoveryCode := body["recovery_codes"].([]interface{})[0].(string)
+
+	status, _ = env.send(t, http.MethodDelete, "/users/me/mfa/totp", fmt.Sprintf(`{"recovery_code": %q}`, recoveryCode))
+	require.Equal(t, http.StatusNoContent, status)
+	assert.Equal(t, 1, env.audit.count(store.AuditMFADisabled))
+	assert.Empty(t, env.totp.credentials)
+}
+
+// ! TestTOTPCodeGuessingThrottled --> a stolen session can't brute-force the code to turn 2FA off or mint recovery codes
+func TestTOTPCodeGuessingThrottled(t *testing.T) {
+	env := newMFATestEnv(t)
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	require.NoError(t, env.totp.SaveTOTPSecret(context.Background(), env.user.ID, secret))
+	require.NoError(t, env.totp.ConfirmTOTP(context.Background(), env.user.ID))
+	wrong := fmt.Sprintf(`{"code": %q}`, wrongOTP(t, secret, env.now))
+
+	// * default username policy --> three free failures, the fourth starts the backoff; both endpoints share the budget
+	for i, path := range []string{"/users/me/mfa/recovery-codes", "/users/me/mfa/recovery-codes", "/users/me/mfa/totp", "/users/me/mfa/totp"} {
+		method := http.MethodPost
+		if path == "/users/me/mfa/totp" {
+			method = http.MethodDelete
+		}
+		status, body := env.send(t, method, path, wrong)
+		require.Equal(t, http.StatusBadRequest, status, "attempt %d", i)
+		assert.Equal(t, utils.CodeInvalidOTP, body["code"])
+	}
+	assert.Equal(t, 4, env.audit.count(store.AuditMFAFailed))
+
+	// ? even the right code has to wait now
+	status, body := env.send(t, http.MethodDelete, "/users/me/mfa/totp", fmt.Sprintf(`{"code": %q}`, env.code(t, secret)))
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, utils.CodeTooManyAttempts, body["code"])
+	assert.True(t, env.totp.credentials[env.user.ID].ConfirmedAt != nil)
+	assert.Zero(t, env.audit.count(store.AuditMFADisabled))
+}
+
+// * mfaTestEnv --> /users/me/mfa routes behind Authenticate, like routes.go wires them, with a clock the test moves
+type mfaTestEnv struct {
+	server *httptest.Server
+	user   *store.User
+	token  string
+	totp   *memoryTOTP
+	audit  *memoryAudit
+	now    time.Time
+}
+
+func newMFATestEnv(t *testing.T) *mfaTestEnv {
+	t.Helper()
+	db := store.NewMemoryDB()
+	users := store.NewMemoryUserStore(db)
+	tokenStore := store.NewMemoryTokenStore(db)
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	require.NoError(t, users.CreateUser(context.Background(), user))
+	token, err := tokenStore.CreateNewToken(context.Background(), user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	env := &mfaTestEnv{user: user, token: token.Plaintext, totp: newMemoryTOTP(), audit: &memoryAudit{}, now: time.Now()}
+	h := NewMFAHandler(env.totp, env.audit, throttle.NewLoginThrottler(newMemoryLoginAttempts()), "FitTrack", slog.New(slog.DiscardHandler))
+	h.credentials.now = func() time.Time { return env.now }
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Authenticate)
+		r.Post("/users/me/mfa/totp", mw.RequireUser(mw.RequireLoginSession(h.HandleEnrollTOTP)))
+		r.Post("/users/me/mfa/totp/confirm", mw.RequireUser(mw.RequireLoginSession(h.HandleConfirmTOTP)))
+		r.Delete("/users/me/mfa/totp", mw.RequireUser(mw.RequireLoginSession(h.HandleDisableTOTP)))
+		r.Post("/users/me/mfa/recovery-codes", mw.RequireUser(mw.RequireLoginSession(h.HandleRegenerateRecoveryCodes)))
+	})
+
+	env.server = httptest.NewServer(r)
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+// * code --> what the authenticator app shows right now
+func (e *mfaTestEnv) code(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := totp.DefaultConfig.Code(secret, e.now)
+	require.NoError(t, err)
+	return code
+}
+
+func (e *mfaTestEnv) send(t *testing.T, method string, path string, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(method, e.server.URL+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+e.token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	decoded := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
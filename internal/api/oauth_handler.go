@@ -0,0 +1,490 @@
+package api
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fem/internal/middleware"
+	"fem/internal/oauth"
+	"fem/internal/scopes"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//! lifetimes for the authorization code flow
+const (
+	authorizationCodeTTL = 10 * time.Minute //* RFC 6749 recommends at most 10 minutes
+	oauthAccessTokenTTL  = time.Hour        //* no refresh tokens --> the app sends the user through consent again
+	maxRedirectURIs      = 10
+)
+
+//! types declaration
+type OAuthHandler struct {
+	oauthStore  store.OAuthStore   //* clients and authorization codes
+	tokenStore  store.TokenStore   //* access tokens end up next to login tokens
+	credentials *credentialChecker //* consent page logs in with the same throttling and audit trail as /tokens
+	logger      *slog.Logger        //* for error logging
+	now         func() time.Time   //* swappable clock --> tests pass a fixed time
+}
+
+//! registerClientRequest --> body for POST /oauth/clients
+type registerClientRequest struct {
+	Name         string   `json:"name"`          //* shown on the consent page
+	RedirectURIs []string `json:"redirect_uris"` //* exact match at /oauth/authorize
+	Scopes       []string `json:"scopes"`        //* most the client may ever ask for
+	Confidential bool     `json:"confidential"`  //* server-side apps get a secret, public apps rely on PKCE alone
+}
+
+//! authorizeRequest --> validated parameters of /oauth/authorize (query on GET, form on POST)
+type authorizeRequest struct {
+	client        *store.OAuthClient
+	redirectURI   string
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+//! NewOAuthHandler --> constructor for the oauth2 authorization server endpoints
+func NewOAuthHandler(oauthStore store.OAuthStore, tokenStore store.TokenStore, userStore store.UserStore, totpStore store.TOTPStore, auditStore store.AuditStore, throttler *throttle.LoginThrottler, logger *slog.Logger) *OAuthHandler {
+	return &OAuthHandler{
+		oauthStore: oauthStore,
+		tokenStore: tokenStore,
+		credentials: &credentialChecker{
+			userStore:  userStore,
+			totpStore:  totpStore,
+			auditStore: auditStore,
+			throttler:  throttler,
+			logger:     logger,
+			now:        time.Now,
+		},
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+//! HandleRegisterClient --> POST /oauth/clients
+//! The client secret is only part of this response, afterwards only its hash exists
+func (h *OAuthHandler) HandleRegisterClient(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	var body registerClientRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	if body.Name == "" || len(body.Name) > 100 {
+		utils.BadRequest(w, req, "name is required and cannot be greater than 100 characters")
+		return
+	}
+	if len(body.RedirectURIs) == 0 || len(body.RedirectURIs) > maxRedirectURIs {
+		utils.BadRequest(w, req, "between 1 and 10 redirect_uris are required")
+		return
+	}
+	for _, redirectURI := range body.RedirectURIs {
+		if err := oauth.ValidateRedirectURI(redirectURI); err != nil {
+			utils.BadRequest(w, req, err.Error())
+			return
+		}
+	}
+	if len(body.Scopes) == 0 {
+		utils.BadRequest(w, req, "at least one scope is required")
+		return
+	}
+	allowedScopes, err := scopes.Normalize(body.Scopes)
+	if err != nil {
+		utils.BadRequest(w, req, err.Error())
+		return
+	}
+
+	clientID, err := tokens.GenerateSecret(16)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "generating client id", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	client := &store.OAuthClient{
+		ID:           strings.ToLower(clientID),
+		UserID:       user.ID,
+		Name:         body.Name,
+		RedirectURIs: body.RedirectURIs,
+		Scopes:       allowedScopes,
+	}
+
+	response := utils.Envelope{"client": client}
+	if body.Confidential {
+		secret, err := tokens.GenerateSecret(32)
+		if err != nil {
+			h.logger.ErrorContext(req.Context(), "generating client secret", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+		client.SecretHash = tokens.Hash(secret)
+		response["client_secret"] = secret
+	}
+
+	err = h.oauthStore.CreateClient(req.Context(), client)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "CreateClient", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusCreated, response)
+}
+
+//! HandleListClients --> GET /oauth/clients (clients registered by the current user)
+func (h *OAuthHandler) HandleListClients(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	clients, err := h.oauthStore.ListClients(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListClients", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"clients": clients})
+}
+
+//! HandleDeleteClient --> DELETE /oauth/clients/{id}
+//! Codes and access tokens issued to the client go with it (ON DELETE CASCADE)
+func (h *OAuthHandler) HandleDeleteClient(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	err := h.oauthStore.DeleteClient(req.Context(), user.ID, chi.URLParam(req, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "client not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteClient", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//! HandleAuthorize --> GET /oauth/authorize (renders the consent page)
+func (h *OAuthHandler) HandleAuthorize(w http.ResponseWriter, req *http.Request) {
+	authorization, ok := h.readAuthorizeRequest(w, req, req.URL.Query())
+	if !ok {
+		return
+	}
+	h.renderConsent(w, req, http.StatusOK, authorization, "")
+}
+
+//! HandleAuthorizeDecision --> POST /oauth/authorize (consent form submission)
+//! The form asks for the password every time --> a cross-site post can't approve anything on its own
+func (h *OAuthHandler) HandleAuthorizeDecision(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		utils.WriteError(w, req, utils.NewError(http.StatusBadRequest, utils.CodeInvalidBody, "invalid form"))
+		return
+	}
+
+	authorization, ok := h.readAuthorizeRequest(w, req, req.PostForm)
+	if !ok {
+		return
+	}
+
+	if req.PostForm.Get("action") != "approve" {
+		h.redirectWithError(w, req, authorization.redirectURI, authorization.state, oauth.ErrAccessDenied)
+		return
+	}
+
+	ip := utils.ClientIP(req)
+	user, loginErr := h.credentials.checkPassword(req.Context(), req.PostForm.Get("username"), req.PostForm.Get("password"), ip)
+	if loginErr != nil {
+		h.renderLoginError(w, req, authorization, loginErr)
+		return
+	}
+	if user.IsSuspended() {
+		h.renderConsent(w, req, http.StatusForbidden, authorization, "account has been suspended")
+		return
+	}
+
+	credential, loginErr := h.credentials.loadTOTP(req.Context(), user)
+	if loginErr != nil {
+		h.renderLoginError(w, req, authorization, loginErr)
+		return
+	}
+	if credential.IsConfirmed() {
+		otp := req.PostForm.Get("otp")
+		if otp == "" {
+			h.renderConsent(w, req, http.StatusUnauthorized, authorization, "enter the code from your authenticator app")
+			return
+		}
+		loginErr = h.credentials.checkSecondFactor(req.Context(), user, credential, otp, "", ip)
+		if loginErr != nil {
+			h.renderLoginError(w, req, authorization, loginErr)
+			return
+		}
+	}
+	h.credentials.recordSuccess(req.Context(), user)
+
+	code, err := tokens.GenerateSecret(32)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "generating authorization code", "error", err)
+		h.redirectWithError(w, req, authorization.redirectURI, authorization.state, oauth.ErrServerError)
+		return
+	}
+	err = h.oauthStore.CreateAuthorizationCode(req.Context(), &store.OAuthAuthorizationCode{
+		Hash:          tokens.Hash(code),
+		ClientID:      authorization.client.ID,
+		UserID:        user.ID,
+		RedirectURI:   authorization.redirectURI,
+		Scopes:        authorization.scopes,
+		CodeChallenge: authorization.codeChallenge,
+		Expiry:        h.now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "CreateAuthorizationCode", "error", err)
+		h.redirectWithError(w, req, authorization.redirectURI, authorization.state, oauth.ErrServerError)
+		return
+	}
+
+	params := url.Values{"code": {code}}
+	if authorization.state != "" {
+		params.Set("state", authorization.state)
+	}
+	http.Redirect(w, req, oauth.RedirectWithParams(authorization.redirectURI, params), http.StatusFound)
+}
+
+//! HandleToken --> POST /oauth/token (authorization_code grant only)
+//! Answers in the RFC 6749 format, not the usual envelope, so standard oauth libraries work
+func (h *OAuthHandler) HandleToken(w http.ResponseWriter, req *http.Request) {
+	//* responses carry credentials --> never cache them
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	err := req.ParseForm()
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrInvalidRequest, "invalid form body")
+		return
+	}
+	if req.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrUnsupportedGrantType, "only authorization_code is supported")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, req)
+	if !ok {
+		return
+	}
+
+	code, err := h.oauthStore.ConsumeAuthorizationCode(req.Context(), tokens.Hash(req.PostForm.Get("code")))
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ConsumeAuthorizationCode", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+	//? the code is already deleted at this point --> any mismatch burns it for good
+	if code == nil || code.ClientID != client.ID || !code.Expiry.After(h.now()) {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrInvalidGrant, "authorization code is invalid or expired")
+		return
+	}
+	if req.PostForm.Get("redirect_uri") != code.RedirectURI {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrInvalidGrant, "redirect_uri does not match the authorization request")
+		return
+	}
+	if !oauth.VerifyPKCE(req.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrInvalidGrant, "code_verifier does not match the code_challenge")
+		return
+	}
+
+	token, err := h.tokenStore.CreateOAuthToken(req.Context(), code.UserID, client.ID, code.Scopes, oauthAccessTokenTTL)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "CreateOAuthToken", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{
+		"access_token": token.Plaintext,
+		"token_type":   "Bearer",
+		"expires_in":   int(oauthAccessTokenTTL.Seconds()),
+		"scope":        scopes.Join(code.Scopes),
+	})
+}
+
+//! HandleRevoke --> POST /oauth/revoke (RFC 7009)
+//? unknown or already revoked tokens still get 200, so the endpoint can't be used to probe tokens
+func (h *OAuthHandler) HandleRevoke(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrInvalidRequest, "invalid form body")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, req)
+	if !ok {
+		return
+	}
+
+	token := req.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauth.ErrInvalidRequest, "token is required")
+		return
+	}
+
+	err = h.tokenStore.DeleteClientToken(req.Context(), client.ID, token)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteClientToken", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+//! readAuthorizeRequest --> validates the authorize parameters, writing the error response itself
+//! Unknown clients and unregistered redirect uris get an error page, never a redirect (open redirector)
+func (h *OAuthHandler) readAuthorizeRequest(w http.ResponseWriter, req *http.Request, params url.Values) (*authorizeRequest, bool) {
+	client, err := h.oauthStore.GetClient(req.Context(), params.Get("client_id"))
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetClient", "error", err)
+		utils.InternalError(w, req)
+		return nil, false
+	}
+	if client == nil {
+		utils.BadRequest(w, req, "unknown client_id")
+		return nil, false
+	}
+
+	redirectURI := params.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0] //* optional when only one uri is registered
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		utils.BadRequest(w, req, "redirect_uri is not registered for this client")
+		return nil, false
+	}
+
+	//* from here on the redirect uri is trusted --> errors go back to the client app
+	state := params.Get("state")
+	if params.Get("response_type") != "code" {
+		h.redirectWithError(w, req, redirectURI, state, oauth.ErrUnsupportedResponseType)
+		return nil, false
+	}
+	if params.Get("code_challenge_method") != oauth.CodeChallengeMethodS256 || !oauth.ValidCodeChallenge(params.Get("code_challenge")) {
+		h.redirectWithError(w, req, redirectURI, state, oauth.ErrInvalidRequest)
+		return nil, false
+	}
+
+	//? no scope parameter --> everything the client registered for
+	requested := client.Scopes
+	if raw := params.Get("scope"); raw != "" {
+		requested, err = scopes.Normalize(scopes.Split(raw))
+		if err != nil {
+			h.redirectWithError(w, req, redirectURI, state, oauth.ErrInvalidScope)
+			return nil, false
+		}
+	}
+	for _, scope := range requested {
+		if !scopes.Has(client.Scopes, scope) {
+			h.redirectWithError(w, req, redirectURI, state, oauth.ErrInvalidScope)
+			return nil, false
+		}
+	}
+
+	return &authorizeRequest{
+		client:        client,
+		redirectURI:   redirectURI,
+		scopes:        requested,
+		state:         state,
+		codeChallenge: params.Get("code_challenge"),
+	}, true
+}
+
+//! authenticateClient --> HTTP Basic or client_id/client_secret form fields
+//! Public clients only identify themselves, PKCE is what protects their codes
+func (h *OAuthHandler) authenticateClient(w http.ResponseWriter, req *http.Request) (*store.OAuthClient, bool) {
+	clientID, secret, hasBasic := req.BasicAuth()
+	if !hasBasic {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		writeOAuthError(w, http.StatusUnauthorized, oauth.ErrInvalidClient, "client authentication failed")
+		return nil, false
+	}
+
+	client, err := h.oauthStore.GetClient(req.Context(), clientID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetClient", "error", err)
+		writeOAuthError(w, http.StatusInternalServerError, oauth.ErrServerError, "")
+		return nil, false
+	}
+	if client == nil || (client.IsConfidential() && subtle.ConstantTimeCompare(tokens.Hash(secret), client.SecretHash) != 1) {
+		writeOAuthError(w, http.StatusUnauthorized, oauth.ErrInvalidClient, "client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
+
+//! renderConsent --> consent page with headers that keep it out of frames and caches
+func (h *OAuthHandler) renderConsent(w http.ResponseWriter, req *http.Request, status int, authorization *authorizeRequest, message string) {
+	var page bytes.Buffer
+	err := oauth.RenderConsent(&page, oauth.ConsentPage{
+		ClientName:          authorization.client.Name,
+		ClientID:            authorization.client.ID,
+		RedirectURI:         authorization.redirectURI,
+		Scopes:              authorization.scopes,
+		State:               authorization.state,
+		CodeChallenge:       authorization.codeChallenge,
+		CodeChallengeMethod: oauth.CodeChallengeMethodS256,
+		Error:               message,
+	})
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "rendering consent page", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	//* clickjacking --> a framed consent page could trick users into approving
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(page.Bytes())
+}
+
+//! renderLoginError --> failed credentials re-render the form instead of leaving the page
+func (h *OAuthHandler) renderLoginError(w http.ResponseWriter, req *http.Request, authorization *authorizeRequest, loginErr *loginError) {
+	setRetryAfter(w, loginErr)
+	h.renderConsent(w, req, loginErr.status, authorization, loginErr.message)
+}
+
+func (h *OAuthHandler) redirectWithError(w http.ResponseWriter, req *http.Request, redirectURI string, state string, code string) {
+	params := url.Values{"error": {code}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	http.Redirect(w, req, oauth.RedirectWithParams(redirectURI, params), http.StatusFound)
+}
+
+//! writeOAuthError --> RFC 6749 section 5.2 error body
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	if code == oauth.ErrInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	body := utils.Envelope{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	utils.WriteJson(w, status, body)
+}
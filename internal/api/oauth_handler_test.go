@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/middleware"
+	"fem/internal/oauth"
+	"fem/internal/scopes"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+// ! TestOAuthAuthorizationCodeFlow --> partner app gets a scoped token without ever seeing the password
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	env := newOAuthTestEnv(t)
+
+	// * fake partner app --> receives the redirect and exchanges the code like a real client would
+	var appToken string
+	var appError string
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("state") != "kiosk-state" {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+		if query.Get("error") != "" {
+			appError = query.Get("error")
+			return
+		}
+		resp := env.exchangeCode(t, query.Get("code"), testCodeVerifier, env.redirectURI)
+		if resp.StatusCode != http.StatusOK {
+			http.Error(w, "token exchange failed", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body)) // * assert, not require --> we're off the test goroutine
+		appToken, _ = body["access_token"].(string)
+	}))
+	defer partner.Close()
+
+	env.redirectURI = partner.URL + "/callback"
+	clientID := env.registerClient(t, []string{scopes.WorkoutsRead})
+
+	// ! consent page renders for a valid request
+	authorizeParams := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {env.redirectURI},
+		"scope":                 {scopes.WorkoutsRead},
+		"state":                 {"kiosk-state"},
+		"code_challenge":        {oauth.S256Challenge(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	resp, err := env.browser.Get(env.server.URL + "/oauth/authorize?" + authorizeParams.Encode())
+	require.NoError(t, err)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+	assert.Contains(t, string(page), "Kiosk wants to access your FitTrack account")
+
+	// ? wrong password re-renders the form instead of redirecting
+	form := cloneValues(authorizeParams)
+	form.Set("username", "ayush")
+	form.Set("password", "wrong")
+	form.Set("action", "approve")
+	resp, err = env.browser.PostForm(env.server.URL+"/oauth/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Empty(t, appToken)
+
+	// ! approving redirects to the partner app, which redeems the code
+	form.Set("password", "Secret123!")
+	resp, err = env.browser.PostForm(env.server.URL+"/oauth/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEmpty(t, appToken)
+
+	// ! the token reaches routes matching its scopes only
+	assert.Equal(t, http.StatusOK, env.call(t, http.MethodGet, "/workouts/1", appToken))
+	assert.Equal(t, http.StatusForbidden, env.call(t, http.MethodPost, "/workouts", appToken))
+	assert.Equal(t, http.StatusForbidden, env.call(t, http.MethodGet, "/users/me/api-keys", appToken))
+
+	// ! revocation by the client kills the token
+	revoke := url.Values{"client_id": {clientID}, "token": {appToken}}
+	resp, err = http.PostForm(env.server.URL+"/oauth/revoke", revoke)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, env.call(t, http.MethodGet, "/workouts/1", appToken))
+
+	// ? denying consent sends access_denied back to the app
+	form.Set("action", "deny")
+	resp, err = env.browser.PostForm(env.server.URL+"/oauth/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, oauth.ErrAccessDenied, appError)
+}
+
+// ! TestOAuthTokenRejections --> codes are bound to verifier, redirect uri and client, and single use
+func TestOAuthTokenRejections(t *testing.T) {
+	env := newOAuthTestEnv(t)
+	env.redirectURI = "http://127.0.0.1:9999/callback"
+	clientID := env.registerClient(t, []string{scopes.WorkoutsRead})
+
+	test := []struct {
+		name        string
+		verifier    string
+		redirectURI string
+		reuse       bool
+	}{
+		{name: "wrong code_verifier", verifier: strings.Repeat("a", 43), redirectURI: env.redirectURI},
+		{name: "different redirect_uri", verifier: testCodeVerifier, redirectURI: "http://127.0.0.1:9999/other"},
+		{name: "code used twice", verifier: testCodeVerifier, redirectURI: env.redirectURI, reuse: true},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			code := env.approve(t, clientID)
+			if tt.reuse {
+				resp := env.exchangeCode(t, code, testCodeVerifier, env.redirectURI)
+				resp.Body.Close()
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+
+			resp := env.exchangeCode(t, code, tt.verifier, tt.redirectURI)
+			defer resp.Body.Close()
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Equal(t, oauth.ErrInvalidGrant, body["error"])
+		})
+	}
+
+	// ? unregistered redirect uri --> error page, never a redirect to the attacker
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {"https://evil.example.com/cb"},
+		"code_challenge":        {oauth.S256Challenge(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	resp, err := env.browser.Get(env.server.URL + "/oauth/authorize?" + params.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+
+	// ? a scope the client never registered for
+	params.Set("redirect_uri", env.redirectURI)
+	params.Set("scope", scopes.WorkoutsWrite)
+	resp, err = env.browser.Get(env.server.URL + "/oauth/authorize?" + params.Encode())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, oauth.ErrInvalidScope, location.Query().Get("error"))
+}
+
+// * oauthTestEnv --> authorization server on httptest with in-memory stores
+type oauthTestEnv struct {
+	server      *httptest.Server
+	browser     *http.Client // * follows redirects like a user's browser
+	redirectURI string
+	clientID    string // * set by registerClient
+	loginToken  string // * ayush's session, for the routes behind Authenticate
+}
+
+func newOAuthTestEnv(t *testing.T) *oauthTestEnv {
+	t.Helper()
+
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	users, tokenStore := newMemoryStores(t, user)
+	loginToken, err := tokenStore.CreateNewToken(context.Background(), user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	logger := slog.New(slog.DiscardHandler)
+	throttler := throttle.NewLoginThrottler(&noLoginAttempts{})
+
+	handler := NewOAuthHandler(&memoryOAuthStore{clients: map[string]*store.OAuthClient{}, codes: map[string]*store.OAuthAuthorizationCode{}}, tokenStore, users, &noTOTP{}, &discardAudit{}, throttler, logger)
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+	ok := func(w http.ResponseWriter, req *http.Request) {
+		utils.WriteJson(w, http.StatusOK, utils.Envelope{"ok": true})
+	}
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Authenticate)
+		r.Get("/workouts/1", mw.RequireUser(mw.RequireScope(scopes.WorkoutsRead, ok)))
+		r.Post("/workouts", mw.RequireUser(mw.RequireScope(scopes.WorkoutsWrite, ok)))
+		r.Get("/users/me/api-keys", mw.RequireUser(mw.RequireLoginSession(ok)))
+		r.Post("/oauth/clients", mw.RequireUser(mw.RequireLoginSession(handler.HandleRegisterClient)))
+	})
+	r.Get("/oauth/authorize", handler.HandleAuthorize)
+	r.Post("/oauth/authorize", handler.HandleAuthorizeDecision)
+	r.Post("/oauth/token", handler.HandleToken)
+	r.Post("/oauth/revoke", handler.HandleRevoke)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	browser := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host == "127.0.0.1:9999" {
+			return http.ErrUseLastResponse // * nothing listens there, inspect the redirect instead
+		}
+		return nil
+	}}
+	return &oauthTestEnv{server: server, browser: browser, loginToken: loginToken.Plaintext}
+}
+
+// * registerClient --> public client, so PKCE is the only thing protecting the code
+func (e *oauthTestEnv) registerClient(t *testing.T, clientScopes []string) string {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]interface{}{"name": "Kiosk", "redirect_uris": []string{e.redirectURI}, "scopes": clientScopes})
+	req, _ := http.NewRequest(http.MethodPost, e.server.URL+"/oauth/clients", strings.NewReader(string(payload)))
+	req.Header.Set("Authorization", "Bearer "+e.loginToken)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var body struct {
+		Client store.OAuthClient `json:"client"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	e.clientID = body.Client.ID
+	return e.clientID
+}
+
+// * approve --> consent form submission, returns the code from the redirect
+func (e *oauthTestEnv) approve(t *testing.T, clientID string) string {
+	t.Helper()
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {e.redirectURI},
+		"code_challenge":        {oauth.S256Challenge(testCodeVerifier)},
+		"code_challenge_method": {"S256"},
+		"username":              {"ayush"},
+		"password":              {"Secret123!"},
+		"action":                {"approve"},
+	}
+	resp, err := e.browser.PostForm(e.server.URL+"/oauth/authorize", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query().Get("code")
+}
+
+func (e *oauthTestEnv) exchangeCode(t *testing.T, code string, verifier string, redirectURI string) *http.Response {
+	t.Helper()
+	resp, err := http.PostForm(e.server.URL+"/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {e.clientID},
+		"code_verifier": {verifier},
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func (e *oauthTestEnv) call(t *testing.T, method string, path string, token string) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, e.server.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := url.Values{}
+	for key, list := range values {
+		clone[key] = append([]string{}, list...)
+	}
+	return clone
+}
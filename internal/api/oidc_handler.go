@@ -0,0 +1,398 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fem/internal/middleware"
+	"fem/internal/oauth"
+	"fem/internal/oidc"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//! oidcStateTTL --> how long the user may take at the provider's login page
+const oidcStateTTL = 10 * time.Minute
+
+//! oidcStateCookie --> binds the callback to the browser that started the login (login CSRF)
+const oidcStateCookie = "fittrack_oidc_state"
+
+//! oidcSessionCookie --> remembers ?session=cookie across the provider round trip
+const oidcSessionCookie = "fittrack_oidc_session"
+
+//! types declaration
+type OIDCHandler struct {
+	providers     map[string]*oidc.Provider //* keyed by the {provider} url segment
+	identityStore store.IdentityStore       //* linked identities and pending login states
+	userStore     store.UserStore           //* username / email collisions on sign-up
+	auditStore    store.AuditStore          //* sign-ups, links and logins are audited
+	tokens        *TokenHandler             //* issues the normal login token (2FA and suspension included)
+	logger        *slog.Logger               //* for error logging
+	now           func() time.Time          //* swappable clock --> tests pass a fixed time
+}
+
+//! NewOIDCHandler --> constructor for "sign in with ..." endpoints
+func NewOIDCHandler(providers []*oidc.Provider, identityStore store.IdentityStore, userStore store.UserStore, auditStore store.AuditStore, tokenHandler *TokenHandler, logger *slog.Logger) *OIDCHandler {
+	byName := map[string]*oidc.Provider{}
+	for _, provider := range providers {
+		byName[provider.Name] = provider
+	}
+	return &OIDCHandler{
+		providers:     byName,
+		identityStore: identityStore,
+		userStore:     userStore,
+		auditStore:    auditStore,
+		tokens:        tokenHandler,
+		logger:        logger,
+		now:           time.Now,
+	}
+}
+
+//! HandleLogin --> GET /auth/oidc/{provider}/login (browser is redirected to the provider)
+func (h *OIDCHandler) HandleLogin(w http.ResponseWriter, req *http.Request) {
+	provider, ok := h.readProvider(w, req)
+	if !ok {
+		return
+	}
+
+	authURL, ok := h.startFlow(w, req, provider, nil)
+	if !ok {
+		return
+	}
+	if session.Requested(req) {
+		http.SetCookie(w, h.flowCookie(oidcSessionCookie, "cookie", int(oidcStateTTL.Seconds())))
+	}
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+//! HandleStartLink --> POST /users/me/identities/{provider}
+//! Returns the provider url instead of redirecting, the client navigates there itself
+func (h *OIDCHandler) HandleStartLink(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+	provider, ok := h.readProvider(w, req)
+	if !ok {
+		return
+	}
+
+	authURL, ok := h.startFlow(w, req, provider, &user.ID)
+	if !ok {
+		return
+	}
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"authorization_url": authURL})
+}
+
+//! HandleCallback --> GET /auth/oidc/{provider}/callback
+//! Logs in (creating the account on first use) or finishes linking, depending on how the flow started
+func (h *OIDCHandler) HandleCallback(w http.ResponseWriter, req *http.Request) {
+	provider, ok := h.readProvider(w, req)
+	if !ok {
+		return
+	}
+	//* one attempt per state --> the cookies are cleared whatever happens next
+	http.SetCookie(w, h.flowCookie(oidcStateCookie, "", -1))
+	_, err := req.Cookie(oidcSessionCookie)
+	cookieSession := err == nil
+	if cookieSession {
+		http.SetCookie(w, h.flowCookie(oidcSessionCookie, "", -1))
+	}
+
+	query := req.URL.Query()
+	if query.Get("error") != "" {
+		utils.Unauthorized(w, req, "login was cancelled or refused by the identity provider")
+		return
+	}
+
+	stateParam := query.Get("state")
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || stateParam == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateParam)) != 1 {
+		utils.BadRequest(w, req, "login state is missing or does not match, please start again")
+		return
+	}
+
+	state, err := h.identityStore.ConsumeOIDCLoginState(req.Context(), tokens.Hash(stateParam))
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ConsumeOIDCLoginState", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if state == nil || state.Provider != provider.Name || !state.Expiry.After(h.now()) {
+		utils.BadRequest(w, req, "login state has expired, please start again")
+		return
+	}
+
+	claims, err := provider.Exchange(req.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if errors.Is(err, oidc.ErrProviderUnavailable) {
+		h.logger.ErrorContext(req.Context(), "oidc exchange", "provider", provider.Name, "error", err)
+		utils.WriteError(w, req, utils.NewError(http.StatusBadGateway, utils.CodeUpstreamUnavailable, "identity provider is unavailable"))
+		return
+	}
+	if err != nil {
+		h.logger.WarnContext(req.Context(), "oidc login rejected", "provider", provider.Name, "error", err)
+		utils.Unauthorized(w, req, "identity provider login could not be verified")
+		return
+	}
+
+	if state.LinkUserID != nil {
+		h.finishLink(w, req, provider, *state.LinkUserID, claims)
+		return
+	}
+	h.finishLogin(w, req, provider, claims, cookieSession)
+}
+
+//! HandleListIdentities --> GET /users/me/identities
+func (h *OIDCHandler) HandleListIdentities(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	identities, err := h.identityStore.ListIdentities(req.Context(), user.ID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListIdentities", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"identities": identities})
+}
+
+//! HandleUnlinkIdentity --> DELETE /users/me/identities/{id}
+func (h *OIDCHandler) HandleUnlinkIdentity(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	identityID, err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w, req, "invalid identity id")
+		return
+	}
+
+	err = h.identityStore.DeleteIdentity(req.Context(), user.ID, identityID)
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "identity not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteIdentity", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditOIDCUnlinked, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//! startFlow --> stores state/nonce/PKCE verifier, sets the state cookie and builds the provider url
+func (h *OIDCHandler) startFlow(w http.ResponseWriter, req *http.Request, provider *oidc.Provider, linkUserID *int) (string, bool) {
+	stateParam, errState := tokens.GenerateSecret(32)
+	nonce, errNonce := tokens.GenerateSecret(32)
+	verifier, errVerifier := tokens.GenerateSecret(32)
+	if err := errors.Join(errState, errNonce, errVerifier); err != nil {
+		h.logger.ErrorContext(req.Context(), "generating oidc state", "error", err)
+		utils.InternalError(w, req)
+		return "", false
+	}
+
+	authURL, err := provider.AuthCodeURL(req.Context(), stateParam, nonce, oauth.S256Challenge(verifier))
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "oidc discovery", "provider", provider.Name, "error", err)
+		utils.WriteError(w, req, utils.NewError(http.StatusBadGateway, utils.CodeUpstreamUnavailable, "identity provider is unavailable"))
+		return "", false
+	}
+
+	err = h.identityStore.CreateOIDCLoginState(req.Context(), &store.OIDCLoginState{
+		Hash:         tokens.Hash(stateParam),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		Expiry:       h.now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "CreateOIDCLoginState", "error", err)
+		utils.InternalError(w, req)
+		return "", false
+	}
+
+	http.SetCookie(w, h.flowCookie(oidcStateCookie, stateParam, int(oidcStateTTL.Seconds())))
+	return authURL, true
+}
+
+//! flowCookie --> short-lived cookie that carries the flow from HandleLogin to HandleCallback
+//? Secure follows session.cookie_secure like the session cookies, req.TLS is nil behind a tls-terminating proxy
+//? SameSite=Lax whatever the session setting --> still sent on the top-level redirect back from the provider
+func (h *OIDCHandler) flowCookie(name string, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.tokens.sessions.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+//! finishLogin --> known identity logs in, unknown identity signs up
+func (h *OIDCHandler) finishLogin(w http.ResponseWriter, req *http.Request, provider *oidc.Provider, claims *oidc.Claims, cookieSession bool) {
+	user, err := h.identityStore.GetUserForIdentity(req.Context(), provider.Name, claims.Subject)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetUserForIdentity", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if user == nil {
+		var ok bool
+		user, ok = h.signUp(w, req, provider, claims)
+		if !ok {
+			return
+		}
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditLoginSucceeded, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req), Detail: "oidc:" + provider.Name})
+	h.tokens.respondWithLoginToken(w, req, user, cookieSession)
+}
+
+//! signUp --> creates the account for a first time "sign in with ..." user
+//? existing emails are never linked automatically, the provider might not own the address
+func (h *OIDCHandler) signUp(w http.ResponseWriter, req *http.Request, provider *oidc.Provider, claims *oidc.Claims) (*store.User, bool) {
+	if claims.Email == "" || !claims.EmailVerified {
+		utils.WriteError(w, req, utils.NewError(http.StatusUnprocessableEntity, utils.CodeInvalidValue, "the identity provider did not share a verified email address"))
+		return nil, false
+	}
+
+	existing, err := h.userStore.GetUserByEmail(req.Context(), claims.Email)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetUserByEmail", "error", err)
+		utils.InternalError(w, req)
+		return nil, false
+	}
+	if existing != nil {
+		utils.Conflict(w, req, "an account with this email already exists, log in and link " + provider.Name + " from your account")
+		return nil, false
+	}
+
+	username, err := h.availableUsername(req.Context(), claims)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "choosing username", "error", err)
+		utils.InternalError(w, req)
+		return nil, false
+	}
+
+	user := &store.User{Username: username, Email: claims.Email}
+	//* random password nobody knows --> the account is only reachable through the provider
+	randomPassword, err := tokens.GenerateSecret(32)
+	if err == nil {
+		err = user.PasswordHash.Set(randomPassword)
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "setting random password", "error", err)
+		utils.InternalError(w, req)
+		return nil, false
+	}
+
+	identity := &store.UserIdentity{Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+	err = h.identityStore.CreateUserWithIdentity(req.Context(), user, identity)
+	if err != nil {
+		if writeConstraintError(w, req, err) {
+			return nil, false
+		}
+		h.logger.ErrorContext(req.Context(), "CreateUserWithIdentity", "error", err)
+		utils.InternalError(w, req)
+		return nil, false
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditOIDCSignup, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req), Detail: provider.Name})
+	return user, true
+}
+
+//! finishLink --> attaches the provider account to the user who started the link
+func (h *OIDCHandler) finishLink(w http.ResponseWriter, req *http.Request, provider *oidc.Provider, userID int, claims *oidc.Claims) {
+	existing, err := h.identityStore.GetIdentity(req.Context(), provider.Name, claims.Subject)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetIdentity", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if existing != nil {
+		utils.Conflict(w, req, "this " + provider.Name + " account is already linked to a user")
+		return
+	}
+
+	linked, err := h.identityStore.ListIdentities(req.Context(), userID)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "ListIdentities", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	for _, identity := range linked {
+		if identity.Provider == provider.Name {
+			utils.Conflict(w, req, "you already linked a " + provider.Name + " account, unlink it first")
+			return
+		}
+	}
+
+	identity := &store.UserIdentity{UserID: userID, Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+	err = h.identityStore.LinkIdentity(req.Context(), identity)
+	if err != nil {
+		if writeConstraintError(w, req, err) {
+			return
+		}
+		h.logger.ErrorContext(req.Context(), "LinkIdentity", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditOIDCLinked, UserID: &userID, IPAddress: utils.ClientIP(req), Detail: provider.Name})
+	utils.WriteJson(w, http.StatusCreated, utils.Envelope{"identity": identity})
+}
+
+func (h *OIDCHandler) readProvider(w http.ResponseWriter, req *http.Request) (*oidc.Provider, bool) {
+	provider := h.providers[chi.URLParam(req, "provider")]
+	if provider == nil {
+		utils.NotFound(w, req, "unknown identity provider")
+		return nil, false
+	}
+	return provider, true
+}
+
+//* usernames are limited to 50 characters and kept to a url-friendly alphabet
+var usernameDisallowed = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+//! availableUsername --> preferred_username or the email's local part, with a suffix when taken
+func (h *OIDCHandler) availableUsername(ctx context.Context, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameDisallowed.ReplaceAllString(strings.ToLower(base), ""), ".-_")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if base == "" {
+		base = "athlete"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		existing, err := h.userStore.GetUserByUsername(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+		suffix, err := tokens.GenerateSecret(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + strings.ToLower(suffix)
+	}
+	return "", errors.New("no free username after 5 attempts")
+}
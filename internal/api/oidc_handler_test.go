@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/middleware"
+	"fem/internal/oidc"
+	"fem/internal/oidc/oidctest"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestOIDCLogin --> first login signs up, later logins find the same user
+func TestOIDCLogin(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.idp.SetIdentity(oidctest.Identity{Subject: "idp-42", Email: "Runner@Example.com", EmailVerified: true, PreferredUsername: "Runner"})
+
+	status, body := env.login(t, env.newBrowser(t))
+	require.Equal(t, http.StatusCreated, status)
+	assert.NotEmpty(t, body["auth_token"])
+
+	created, err := env.users.GetUserByEmail(context.Background(), "runner@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, created)
+	assert.Equal(t, "runner", created.Username)
+
+	status, _ = env.login(t, env.newBrowser(t))
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 2, userCount(t, env.users)) // * the existing user plus the one sign-up, no duplicate
+}
+
+// ! TestOIDCLoginRefusals --> account takeover and login CSRF guards
+func TestOIDCLoginRefusals(t *testing.T) {
+	test := []struct {
+		name       string
+		identity   oidctest.Identity
+		noCookie   bool
+		wantStatus int
+	}{
+		{name: "email of an existing account", identity: oidctest.Identity{Subject: "idp-1", Email: "ayush@example.com", EmailVerified: true}, wantStatus: http.StatusConflict},
+		{name: "unverified email", identity: oidctest.Identity{Subject: "idp-2", Email: "new@example.com"}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "callback in another browser", identity: oidctest.Identity{Subject: "idp-3", Email: "new@example.com", EmailVerified: true}, noCookie: true, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newOIDCTestEnv(t)
+			env.idp.SetIdentity(tt.identity)
+
+			browser := env.newBrowser(t)
+			if tt.noCookie {
+				browser.Jar = nil // * state cookie is dropped, like a link opened elsewhere
+			}
+			status, _ := env.login(t, browser)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Empty(t, env.identities.identities)
+		})
+	}
+}
+
+// ! TestOIDCLink --> a logged in user links a provider account and can then log in with it
+func TestOIDCLink(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.idp.SetIdentity(oidctest.Identity{Subject: "idp-7", Email: "work@example.com", EmailVerified: true})
+	browser := env.newBrowser(t)
+
+	req, _ := http.NewRequest(http.MethodPost, env.server.URL+"/users/me/identities/test", nil)
+	req.Header.Set("Authorization", "Bearer "+env.loginToken)
+	resp, err := browser.Do(req)
+	require.NoError(t, err)
+	var start map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&start))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = browser.Get(start["authorization_url"])
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	identities, _ := env.identities.ListIdentities(context.Background(), 1)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "test", identities[0].Provider)
+
+	// ? the linked identity now logs into the existing account instead of signing up
+	status, _ := env.login(t, env.newBrowser(t))
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 1, userCount(t, env.users))
+}
+
+// ! TestOIDCFlowCookiesFollowConfig --> Secure comes from session.cookie_secure, not req.TLS (tls ends at the proxy)
+func TestOIDCFlowCookiesFollowConfig(t *testing.T) {
+	idp := oidctest.NewServer("fittrack", "s3cret")
+	t.Cleanup(idp.Close)
+
+	for _, secure := range []bool{true, false} {
+		users, tokenStore := newMemoryStores(t)
+		logger := slog.New(slog.DiscardHandler)
+		sessions := session.Cookies{Secure: secure, SameSite: http.SameSiteStrictMode}
+		tokenHandler := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), sessions, 24*time.Hour, metrics.New(), logger)
+		provider := oidc.NewProvider(oidc.Config{Name: "test", Issuer: idp.Issuer(), ClientID: idp.ClientID, ClientSecret: idp.ClientSecret})
+		handler := NewOIDCHandler([]*oidc.Provider{provider}, newMemoryIdentities(users), users, &discardAudit{}, tokenHandler, logger)
+		r := chi.NewRouter()
+		r.Get("/auth/oidc/{provider}/login", handler.HandleLogin)
+
+		// ? plain http like behind a tls-terminating proxy
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://api.example.com/auth/oidc/test/login?session=cookie", nil))
+		require.Equal(t, http.StatusFound, rr.Code)
+
+		cookies := rr.Result().Cookies()
+		require.Len(t, cookies, 2) // * state + session mode
+		for _, cookie := range cookies {
+			assert.Equal(t, secure, cookie.Secure, cookie.Name)
+			assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite, cookie.Name) // ? strict would drop them on the way back from the provider
+		}
+	}
+}
+
+// * oidcTestEnv --> our server and the stand-in provider, wired like app.go does, ayush (id 1) already registered
+type oidcTestEnv struct {
+	server     *httptest.Server
+	idp        *oidctest.Server
+	users      *store.MemoryUserStore
+	identities *memoryIdentities
+	loginToken string // * ayush's session
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	t.Helper()
+	idp := oidctest.NewServer("fittrack", "s3cret")
+	t.Cleanup(idp.Close)
+
+	existing := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	users, tokenStore := newMemoryStores(t, existing)
+	loginToken, err := tokenStore.CreateNewToken(context.Background(), existing.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	identities := newMemoryIdentities(users)
+	logger := slog.New(slog.DiscardHandler)
+
+	provider := oidc.NewProvider(oidc.Config{Name: "test", Issuer: idp.Issuer(), ClientID: idp.ClientID, ClientSecret: idp.ClientSecret})
+	tokenHandler := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, metrics.New(), logger)
+	handler := NewOIDCHandler([]*oidc.Provider{provider}, identities, users, &discardAudit{}, tokenHandler, logger)
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Authenticate)
+		r.Post("/users/me/identities/{provider}", mw.RequireUser(mw.RequireLoginSession(handler.HandleStartLink)))
+	})
+	r.Get("/auth/oidc/{provider}/login", handler.HandleLogin)
+	r.Get("/auth/oidc/{provider}/callback", handler.HandleCallback)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	provider.RedirectURL = server.URL + "/auth/oidc/test/callback"
+
+	return &oidcTestEnv{server: server, idp: idp, users: users, identities: identities, loginToken: loginToken.Plaintext}
+}
+
+// * newBrowser --> cookie jar + redirects, the state cookie must survive the round trip
+func (e *oidcTestEnv) newBrowser(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	return &http.Client{Jar: jar}
+}
+
+// * login --> /login, provider's /authorize, our /callback; returns the callback's answer
+func (e *oidcTestEnv) login(t *testing.T, browser *http.Client) (int, map[string]interface{}) {
+	t.Helper()
+	resp, err := browser.Get(e.server.URL + "/auth/oidc/test/login")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
@@ -1,19 +1,27 @@
 package api
 
 import (
-	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/session"
 	"fem/internal/store"
+	"fem/internal/throttle"
 	"fem/internal/tokens"
 	"fem/internal/utils"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type TokenHandler struct {
 	tokenStore store.TokenStore //* for creating/storing tokens
-	userStore store.UserStore //* for validating user credentials
-	logger *log.Logger //* for error logging
+	userStore store.UserStore //* for resolving mfa-pending tokens
+	totpStore store.TOTPStore //* for two-factor logins
+	credentials *credentialChecker //* password/2FA checks with throttling and audit trail
+	sessions session.Cookies //* cookie attributes for ?session=cookie logins
+	authTokenTTL time.Duration //* lifetime of login tokens (auth.token_ttl)
+	metrics *metrics.Metrics //* login success/failure counters
+	logger *slog.Logger //* for error logging
 }
 
 //! createTokenRequest --> login credentials from client
@@ -22,16 +30,38 @@ type createTokenRequest struct {
 	Password string `json:"password"` //* plaintext password to verify
 }
 
+//! exchangeMFATokenRequest --> second step of a 2FA login
+type exchangeMFATokenRequest struct {
+	MFAToken     string `json:"mfa_token"` //* token returned by the password step
+	Code         string `json:"code"` //* 6 digit code from the authenticator app
+	RecoveryCode string `json:"recovery_code"` //* alternatively one unused recovery code
+}
+
+//! mfaPendingTTL --> how long the user has to type the code after the password step
+const mfaPendingTTL = 5 * time.Minute
+
 //! NewTokenHandler --> constructor for token handler
-func NewTokenHandler(tokenStore store.TokenStore,userStore store.UserStore,logger *log.Logger) *TokenHandler {
+func NewTokenHandler(tokenStore store.TokenStore,userStore store.UserStore,totpStore store.TOTPStore,auditStore store.AuditStore,throttler *throttle.LoginThrottler,sessions session.Cookies,authTokenTTL time.Duration,metrics *metrics.Metrics,logger *slog.Logger) *TokenHandler {
 	return &TokenHandler{
 		tokenStore: tokenStore,
 		userStore: userStore,
+		totpStore: totpStore,
+		credentials: &credentialChecker{
+			userStore: userStore,
+			totpStore: totpStore,
+			auditStore: auditStore,
+			throttler: throttler,
+			logger: logger,
+			now: time.Now,
+		},
+		sessions: sessions,
+		authTokenTTL: authTokenTTL,
+		metrics: metrics,
 		logger: logger,
 	}
 }
 
-// func NewTokenHandler(tokenstore store.TokenStore,userStore store.UserStore,logger *log.Logger) *TokenHandler{
+// func NewTokenHandler(tokenstore store.TokenStore,userStore store.UserStore,logger *slog.Logger) *TokenHandler{
 // 	// return instance of TokenHandler type struct
 // 	return &TokenHandler{
 // 		tokenStore: tokenstore,
@@ -46,42 +76,170 @@ func NewTokenHandler(tokenStore store.TokenStore,userStore store.UserStore,logge
 func (h *TokenHandler) HandleCreateToken(w http.ResponseWriter,req *http.Request)  {
 	var tokenRequestingUser createTokenRequest //* holds username and password from client
 	//* decode JSON body into struct
-	err := json.NewDecoder(req.Body).Decode(&tokenRequestingUser)
+	err := utils.ReadJSON(w, req, &tokenRequestingUser)
 	if err!= nil {
-		h.logger.Printf("ERROR : createTokenRequest %v", err)
-		utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error":"invalid payload request"})
+		h.logger.WarnContext(req.Context(), "createTokenRequest", "error", err)
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	//! throttle check, user lookup and password hash comparison --> shared with the oauth consent page
+	user, loginErr := h.credentials.checkPassword(req.Context(), tokenRequestingUser.Username, tokenRequestingUser.Password, utils.ClientIP(req))
+	h.recordLoginAttempt(metrics.StepPassword, loginErr)
+	if loginErr != nil {
+		writeLoginError(w, req, loginErr)
+		return
 	}
-	//* lookup user by username in database
-	user, err := h.userStore.GetUserByUsername(tokenRequestingUser.Username)
-	if err != nil || user == nil {
-		h.logger.Printf("ERROR: GetUserByUsername: %v", err)
-		utils.WriteJson(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+
+	//* credentials valid! either finish the login or ask for the second factor
+	h.respondWithLoginToken(w, req, user, session.Requested(req))
+}
+
+//! recordLoginAttempt --> login counters for /metrics, internal errors say nothing about the credentials so they're skipped
+func (h *TokenHandler) recordLoginAttempt(step string, loginErr *loginError) {
+	switch {
+	case loginErr == nil:
+		h.metrics.LoginAttempt(step, metrics.ResultSuccess)
+	case loginErr.code == utils.CodeTooManyAttempts:
+		h.metrics.LoginAttempt(step, metrics.ResultThrottled)
+	case loginErr.code != utils.CodeInternal:
+		h.metrics.LoginAttempt(step, metrics.ResultFailure)
+	}
+}
+
+//! respondWithLoginToken --> last step of every login flow
+//! Users with confirmed 2FA get a short-lived mfa-pending token instead of a real one
+//? cookieSession --> the browser asked for cookie mode, see respondWithAuthToken
+func (h *TokenHandler) respondWithLoginToken(w http.ResponseWriter, req *http.Request, user *store.User, cookieSession bool) {
+	//? checked after the credentials so the response doesn't reveal suspension to strangers
+	if user.IsSuspended() {
+		utils.WriteError(w, req, utils.NewError(http.StatusForbidden, utils.CodeAccountSuspended, "account has been suspended"))
 		return
 	}
 
-	//! comparing plaintext password with hashed password using bcrypt
-	passwordsDoMatch, err := user.PasswordHash.Matches(tokenRequestingUser.Password)
+	credential, err := h.totpStore.GetTOTP(req.Context(), user.ID)
 	if err != nil {
-		h.logger.Printf("ERORR: PasswordHash.Mathes %v", err)
-		utils.WriteJson(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		h.logger.ErrorContext(req.Context(), "GetTOTP", "error", err)
+		utils.InternalError(w, req)
 		return
 	}
 
-	if !passwordsDoMatch {
-		//? wrong password
-		utils.WriteJson(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid credentials"})
+	if credential.IsConfirmed() {
+		mfaToken, err := h.tokenStore.CreateNewToken(req.Context(), user.ID, mfaPendingTTL, tokens.ScopeMFAPending)
+		if err != nil {
+			h.logger.ErrorContext(req.Context(), "Creating Token", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+		//? 202 --> login accepted but not finished, client must call POST /tokens/mfa
+		utils.WriteJson(w, http.StatusAccepted, utils.Envelope{"mfa_required": true, "mfa_token": mfaToken})
 		return
 	}
 
-	//* credentials valid! generate new authentication token (expire in 24 hours)
-	token, err := h.tokenStore.CreateNewToken(user.ID, 24*time.Hour, tokens.ScopeAuth)
+	h.credentials.recordSuccess(req.Context(), user)
+	h.respondWithAuthToken(w, req, user, cookieSession)
+}
+
+//! respondWithAuthToken --> issues the real authentication token (expires in 24 hours)
+//! In cookie mode the token only travels in the HttpOnly cookie, the body carries the csrf token
+func (h *TokenHandler) respondWithAuthToken(w http.ResponseWriter, req *http.Request, user *store.User, cookieSession bool) {
+	token, err := h.tokenStore.CreateNewToken(req.Context(), user.ID, h.authTokenTTL, tokens.ScopeAuth)
 	if err != nil {
-		h.logger.Printf("ERORR: Creating Token %v", err)
-		utils.WriteJson(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		h.logger.ErrorContext(req.Context(), "Creating Token", "error", err)
+		utils.InternalError(w, req)
 		return
 
 	}
 
+	if cookieSession {
+		csrfToken := h.sessions.Set(w, token)
+		utils.WriteJson(w, http.StatusCreated, utils.Envelope{"session": utils.Envelope{"expiry": token.Expiry}, "csrf_token": csrfToken})
+		return
+	}
+
 	//* return token to client (they'll use this in Authorization header for protected routes)
 	utils.WriteJson(w, http.StatusCreated, utils.Envelope{"auth_token": token})
-}
\ No newline at end of file
+}
+
+//! HandleExchangeMFAToken --> POST /tokens/mfa (second step of a 2FA login)
+//! Trades a valid mfa-pending token plus a TOTP or recovery code for an authentication token
+func (h *TokenHandler) HandleExchangeMFAToken(w http.ResponseWriter, req *http.Request) {
+	var body exchangeMFATokenRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	if body.MFAToken == "" || (body.Code == "" && body.RecoveryCode == "") {
+		utils.BadRequest(w, req, "mfa_token and code or recovery_code are required")
+		return
+	}
+
+	//* mfa-pending tokens live in the same table, just under their own scope
+	user, err := h.userStore.GetUserToken(req.Context(), tokens.ScopeMFAPending, body.MFAToken)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "GetUserToken", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if user == nil {
+		utils.WriteError(w, req, utils.NewError(http.StatusUnauthorized, utils.CodeInvalidToken, "mfa token has been expired or invalid"))
+		return
+	}
+
+	credential, loginErr := h.credentials.loadTOTP(req.Context(), user)
+	if loginErr != nil {
+		writeLoginError(w, req, loginErr)
+		return
+	}
+	if !credential.IsConfirmed() {
+		//? 2FA got disabled in between --> pending token is worthless now
+		utils.WriteError(w, req, utils.NewError(http.StatusUnauthorized, utils.CodeInvalidToken, "mfa token has been expired or invalid"))
+		return
+	}
+
+	//* wrong codes count against the same username / ip budget as wrong passwords
+	loginErr = h.credentials.checkSecondFactor(req.Context(), user, credential, body.Code, body.RecoveryCode, utils.ClientIP(req))
+	h.recordLoginAttempt(metrics.StepMFA, loginErr)
+	if loginErr != nil {
+		writeLoginError(w, req, loginErr)
+		return
+	}
+	h.credentials.recordSuccess(req.Context(), user)
+
+	//* pending tokens are single use --> drop them before issuing the real one
+	err = h.tokenStore.DeleteAllTokensForUser(req.Context(), user.ID, tokens.ScopeMFAPending)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteAllTokensForUser", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	h.respondWithAuthToken(w, req, user, session.Requested(req))
+}
+
+//! HandleDeleteToken --> DELETE /tokens/authentication (logout)
+//! Deletes the login token the request was made with and clears the session cookies
+func (h *TokenHandler) HandleDeleteToken(w http.ResponseWriter, req *http.Request) {
+	err := h.tokenStore.DeleteToken(req.Context(), tokens.ScopeAuth, requestToken(req))
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteToken", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	h.sessions.Clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//! requestToken --> the login token the request was authenticated with
+//? Authenticate already accepted the header or the cookie, only one of them is set
+func requestToken(req *http.Request) string {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		if cookie, err := req.Cookie(session.CookieName); err == nil {
+			token = cookie.Value
+		}
+	}
+	return token
+}
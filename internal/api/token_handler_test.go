@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/middleware"
+	"fem/internal/passhash"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/totp"
+	"fem/internal/utils"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestLoginRehashesPassword --> a bcrypt user logs in normally and comes out with an argon2id hash
+func TestLoginRehashesPassword(t *testing.T) {
+	t.Cleanup(func() { store.SetPasswordHasher(passhash.Default()) })
+
+	// * hash like before the switch, then move to argon2id
+	store.SetPasswordHasher(passhash.NewManager(passhash.NewBcrypt(4)))
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	memoryUsers, tokenStore := newMemoryStores(t, user)
+	store.SetPasswordHasher(passhash.NewManager(passhash.NewArgon2id(passhash.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1}), passhash.NewBcrypt(4)))
+
+	users := &countingRehashes{MemoryUserStore: memoryUsers}
+	h := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, metrics.New(), slog.New(slog.DiscardHandler))
+
+	rr := postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, 0, users.rehashes) // ? never rehash without the right password
+
+	rr = postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "Secret123!"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, 1, users.rehashes)
+
+	rr = postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "Secret123!"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, 1, users.rehashes) // * already up to date
+	assert.False(t, storedUser(t, users, user.ID).PasswordHash.NeedsRehash())
+}
+
+// * countingRehashes --> the memory user store, counting how often a login upgraded a hash
+type countingRehashes struct {
+	*store.MemoryUserStore
+	rehashes int
+}
+
+func (c *countingRehashes) RehashPassword(ctx context.Context, user *store.User, plaintext string) error {
+	c.rehashes++
+	return c.MemoryUserStore.RehashPassword(ctx, user, plaintext)
+}
+
+// ! TestLoginMetrics --> every password step ends up in fem_login_attempts_total
+func TestLoginMetrics(t *testing.T) {
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	users, tokenStore := newMemoryStores(t, user)
+	m := metrics.New()
+	h := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, m, slog.New(slog.DiscardHandler))
+
+	postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "wrong"}`)
+	postJSON(h.HandleCreateToken, `{"username": "nobody", "password": "wrong"}`)
+	rr := postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "Secret123!"}`)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.LoginAttempts.WithLabelValues(metrics.StepPassword, metrics.ResultFailure)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.LoginAttempts.WithLabelValues(metrics.StepPassword, metrics.ResultSuccess)))
+}
+
+// ! TestPasswordDoesNotResetOTPBudget --> logging in with the password again doesn't buy more guesses at the code
+func TestPasswordDoesNotResetOTPBudget(t *testing.T) {
+	db := store.NewMemoryDB()
+	users := store.NewMemoryUserStore(db)
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	require.NoError(t, users.CreateUser(context.Background(), user))
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	throttler := throttle.NewLoginThrottler(newMemoryLoginAttempts())
+	throttler.UsernamePolicy = throttle.Policy{FreeAttempts: 10, LockoutThreshold: 3, LockoutDuration: 15 * time.Minute, Window: time.Hour}
+	throttler.IPPolicy = throttle.Policy{FreeAttempts: 100, Window: time.Hour} // ? only the username budget is under test
+	h := NewTokenHandler(store.NewMemoryTokenStore(db), users, &confirmedTOTP{secret: secret}, &discardAudit{}, throttler, session.DefaultCookies, 24*time.Hour, metrics.New(), slog.New(slog.DiscardHandler))
+	now := time.Now()
+	h.credentials.now = func() time.Time { return now }
+	wrongCode := wrongOTP(t, secret, now)
+
+	for i := 0; i < 3; i++ {
+		rr := postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "Secret123!"}`)
+		require.Equal(t, http.StatusAccepted, rr.Code, "attempt %d", i)
+		var pending struct {
+			MFAToken struct {
+				Token string `json:"token"`
+			} `json:"mfa_token"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pending))
+
+		rr = postJSON(h.HandleExchangeMFAToken, fmt.Sprintf(`{"mfa_token": %q, "code": %q}`, pending.MFAToken.Token, wrongCode))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "attempt %d", i)
+	}
+
+	// * three wrong codes lock the account, the right password doesn't lift that
+	rr := postJSON(h.HandleCreateToken, `{"username": "ayush", "password": "Secret123!"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+}
+
+// * wrongOTP --> a well-formed code that doesn't match any step inside the skew window
+func wrongOTP(t *testing.T, secret string, now time.Time) string {
+	for i := 0; ; i++ {
+		code := fmt.Sprintf("%06d", i)
+		_, ok, err := totp.DefaultConfig.Validate(secret, code, now)
+		require.NoError(t, err)
+		if !ok {
+			return code
+		}
+	}
+}
+
+// ! TestLoginRejectsMalformedBody --> a bad body never reaches the password check
+func TestLoginRejectsMalformedBody(t *testing.T) {
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	users, tokenStore := newMemoryStores(t, user)
+	h := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, metrics.New(), slog.New(slog.DiscardHandler))
+
+	for body, status := range map[string]int{
+		`{"username": "ayush", "password": "Secret123!", "remember": true}`:     http.StatusBadRequest, // * unknown field
+		`{"username": "ayush", "password": "Secret123!"} {"username": "admin"}`: http.StatusBadRequest, // * second value
+		`{"username": "ayush", "password": 123}`:                                http.StatusBadRequest,
+		`{"username": "` + strings.Repeat("a", utils.MaxBodyBytes) + `"}`:       http.StatusRequestEntityTooLarge,
+	} {
+		rr := postJSON(h.HandleCreateToken, body)
+		assert.Equal(t, status, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "token")
+	}
+}
+
+// ! TestCookieSession --> login with ?session=cookie, csrf on unsafe methods, logout clears everything
+func TestCookieSession(t *testing.T) {
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	users, tokenStore := newMemoryStores(t, user)
+	h := NewTokenHandler(tokenStore, users, &noTOTP{}, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), session.DefaultCookies, 24*time.Hour, metrics.New(), slog.New(slog.DiscardHandler))
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+
+	whoami := func(w http.ResponseWriter, req *http.Request) {
+		utils.WriteJson(w, http.StatusOK, utils.Envelope{"username": middleware.GetUser(req).Username})
+	}
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Authenticate)
+		r.Get("/me", mw.RequireUser(whoami))
+		r.Post("/me", mw.RequireUser(whoami))
+		r.Delete("/tokens/authentication", mw.RequireUser(mw.RequireLoginSession(h.HandleDeleteToken)))
+	})
+	r.Post("/tokens/authentication", h.HandleCreateToken)
+
+	// * Secure cookies --> the jar only sends them over https
+	server := httptest.NewTLSServer(r)
+	defer server.Close()
+	browser := server.Client()
+	browser.Jar, _ = cookiejar.New(nil)
+
+	send := func(method string, path string, body string, csrf string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if csrf != "" {
+			req.Header.Set(session.CSRFHeader, csrf)
+		}
+		resp, err := browser.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := send(http.MethodPost, "/tokens/authentication?session=cookie", `{"username": "ayush", "password": "Secret123!"}`, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var login map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+	assert.NotContains(t, login, "auth_token") // ? the token only lives in the HttpOnly cookie
+	csrfToken, _ := login["csrf_token"].(string)
+	require.NotEmpty(t, csrfToken)
+
+	for _, cookie := range resp.Cookies() {
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		assert.Equal(t, cookie.Name == session.CookieName, cookie.HttpOnly)
+	}
+
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/me", "", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/me", "", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/me", "", "forged").StatusCode)
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/me", "", csrfToken).StatusCode)
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodDelete, "/tokens/authentication", "", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/tokens/authentication", "", csrfToken).StatusCode)
+	left, err := tokenStore.HasTokenExpiringAfter(context.Background(), user.ID, tokens.ScopeAuth, time.Now())
+	require.NoError(t, err)
+	assert.False(t, left)
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/me", "", "").StatusCode) // * cookie cleared --> anonymous
+}
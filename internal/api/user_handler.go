@@ -1,15 +1,28 @@
 package api
 
 import (
-	"encoding/json"
+	"database/sql"
 	"errors"
+	"fem/internal/mailer"
+	"fem/internal/middleware"
+	"fem/internal/passpolicy"
+	"fem/internal/session"
 	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
 	"fem/internal/utils"
-	"log"
+	"fem/internal/validator"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"regexp"
+	"net/url"
+	"strings"
+	"time"
 )
 
+//! emailChangeTTL --> how long the confirmation link sent to a new address stays valid
+const emailChangeTTL = 24 * time.Hour
+
 //! types declaration
 //! registerUserRequest --> incoming JSON payload for user registration
 type registerUserRequest struct {
@@ -19,49 +32,67 @@ type registerUserRequest struct {
 	Bio      string `json:"bio"` //* optional user bio
 }
 
+//! updateProfileRequest --> PATCH /users/me, nil fields stay as they are
+type updateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"` //* only changes once the new address is confirmed
+	Bio      *string `json:"bio"`
+}
+
+//! changePasswordRequest --> PUT /users/me/password
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+//! confirmEmailRequest --> the token from the emailed confirmation link
+type confirmEmailRequest struct {
+	Token string `json:"token"`
+}
+
 type UserHandler struct {
 	userStore store.UserStore //* database operations for users
-	logger *log.Logger //* for error logging
+	tokenStore store.TokenStore //* revoking other sessions after a password change
+	auditStore store.AuditStore //* profile changes are security relevant
+	credentials *credentialChecker //* current password check, throttled like a login
+	mailer mailer.Mailer //* confirmation links for new email addresses
+	passwordPolicy *passpolicy.Policy //* rules every new password has to pass
+	sessions session.Cookies //* cleared when the account is deleted
+	emailConfirmURL string //* page the confirmation link opens, it POSTs the token to /users/email/confirm
+	logger *slog.Logger //* for error logging
 }
 
 //! NewUserHandler --> constructor that creates user handler instance
-func NewUserHandler(userStore store.UserStore, logger *log.Logger) *UserHandler {
+func NewUserHandler(userStore store.UserStore, tokenStore store.TokenStore, auditStore store.AuditStore, throttler *throttle.LoginThrottler, mailer mailer.Mailer, passwordPolicy *passpolicy.Policy, sessions session.Cookies, emailConfirmURL string, logger *slog.Logger) *UserHandler {
 	//* return instance of struct --> methods can now access userStore and logger
 	return &UserHandler{
 		userStore: userStore,
+		tokenStore: tokenStore,
+		auditStore: auditStore,
+		credentials: &credentialChecker{
+			userStore: userStore,
+			auditStore: auditStore,
+			throttler: throttler,
+			logger: logger,
+			now: time.Now,
+		},
+		mailer: mailer,
+		passwordPolicy: passwordPolicy,
+		sessions: sessions,
+		emailConfirmURL: emailConfirmURL,
 		logger: logger,
 	}
 }
 
 //! validateUserRegisterRequest --> server-side validation before saving to database
-//? prevents invalid data from entering the system
-func (h *UserHandler) validateUserRegisterRequest (regUser *registerUserRequest) error {
-	//* checking if username is provided
-	if regUser.Username == "" {
-	return	errors.New("Username is required")
+//? collects every problem at once, password policy reasons included
+func (h *UserHandler) validateUserRegisterRequest(v *validator.Validator, regUser *registerUserRequest) {
+	store.ValidateUsername(v, regUser.Username)
+	store.ValidateEmail(v, regUser.Email)
+	if v.Check(regUser.Password != "", "password", "password is required") {
+		//! password policy --> length, guessability, username/email reuse and the breached list
+		h.checkPassword(v, "password", regUser.Password, regUser.Username, regUser.Email)
 	}
-
-	if len(regUser.Username) > 50 {
-	return	errors.New("Username cannot be greater than 50 characters")
-	}
-
-	if regUser.Email == "" {
-	return	errors.New("Email is required")
-	}
-	
-	if regUser.Password == "" {
-		return errors.New("password is required")
-	}
-
-	//! regex validation for email format
-	emailRegexPattern := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if !emailRegexPattern.MatchString(regUser.Email)  {
-		//* test if email matches standard email pattern
-		return errors.New("Invalid email format")
-	} 
-
-	return nil
-
 }
 
 //! HandleRegisterUser --> POST /users endpoint for creating new user accounts
@@ -69,17 +100,17 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, req *http.Reques
 	var r registerUserRequest //* holds incoming JSON data
 
 	//* decode JSON body into struct
-	err:= json.NewDecoder(req.Body).Decode(&r)
+	err:= utils.ReadJSON(w,req,&r)
 	if err!= nil {
-		h.logger.Printf("Error : decoding Register request : %V ",err)
-		utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error":"invalid request payload"})
+		h.logger.WarnContext(req.Context(),"decoding Register request","error",err)
+		writeReadJSONError(w,req,err)
 		return
 	}
 
-	err = h.validateUserRegisterRequest(&r)
-	if err!= nil {
-		// h.logger.Printf("Error : decoding Register request : %V ",err)
-		utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error":err.Error()})
+	v := validator.New()
+	h.validateUserRegisterRequest(v, &r)
+	if !v.Valid() {
+		writeValidationErrors(w, req, v)
 		return
 	}
 
@@ -92,23 +123,264 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, req *http.Reques
 		user.Bio = r.Bio
 	}
 
-	//! hash the password using bcrypt (cost factor 12) - NEVER store plaintext passwords
+	//! hash the password with Argon2id (see internal/passhash) - NEVER store plaintext passwords
 	err = user.PasswordHash.Set(r.Password)
 	if err != nil {
-		h.logger.Printf("ERROR : hashing password %v ",err)
-		utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error":"internal server error"})
+		h.logger.ErrorContext(req.Context(),"hashing password","error",err)
+		utils.InternalError(w,req)
 		return
 	}
 
 	//* save user to database
-	err = h.userStore.CreateUser(user)
+	err = h.userStore.CreateUser(req.Context(), user)
 	if err != nil {
-		h.logger.Printf("ERROR : registering user %v ",err)
-		utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error":"internal server error"})
+		//? username/email taken --> 409 naming the field instead of a 500
+		if writeConstraintError(w, req, err) {
+			return
+		}
+		h.logger.ErrorContext(req.Context(),"registering user","error",err)
+		utils.InternalError(w,req)
 		return
 	}
 
 	//* 201 Created response with user data (password hash is excluded via json:"-" tag)
 		utils.WriteJson(w,http.StatusCreated,utils.Envelope{"user":user })
 
-}
\ No newline at end of file
+}
+
+//! checkPassword --> adds every broken policy rule to the field, so the form can show them all at once
+//? shared by every place a user picks a new password
+func (h *UserHandler) checkPassword(v *validator.Validator, field string, password string, userInputs ...string) {
+	err := h.passwordPolicy.Check(password, userInputs...)
+	var violation *passpolicy.Violation
+	if errors.As(err, &violation) {
+		for _, reason := range violation.Reasons {
+			v.AddError(field, reason)
+		}
+	}
+	//? an unreadable breached list doesn't lock everyone out, the other rules still applied
+	if errors.Is(err, passpolicy.ErrBreachedLookup) {
+		h.logger.Error("checking breached passwords", "error", err)
+	}
+}
+
+//! HandleGetMe --> GET /users/me
+func (h *UserHandler) HandleGetMe(w http.ResponseWriter, req *http.Request) {
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"user": middleware.GetUser(req)})
+}
+
+//! HandleUpdateMe --> PATCH /users/me
+//! Username and bio change right away, a new email only after the link sent to it is opened
+func (h *UserHandler) HandleUpdateMe(w http.ResponseWriter, req *http.Request) {
+	var body updateProfileRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+
+	current := middleware.GetUser(req)
+	user := *current //* copy --> the context keeps the user as authenticated
+	changed := false
+	v := validator.New()
+
+	if body.Username != nil && *body.Username != user.Username {
+		user.Username = strings.TrimSpace(*body.Username) //* taken names fail the unique constraint in UpdateUser --> 409
+		store.ValidateUsername(v, user.Username)
+		changed = true
+	}
+
+	if body.Bio != nil && *body.Bio != user.Bio {
+		user.Bio = *body.Bio
+		changed = true
+	}
+
+	//? same address in another case --> nothing to confirm
+	pendingEmail := ""
+	if body.Email != nil && !strings.EqualFold(strings.TrimSpace(*body.Email), user.Email) {
+		pendingEmail = strings.TrimSpace(*body.Email)
+		store.ValidateEmail(v, pendingEmail)
+	}
+	if !v.Valid() {
+		writeValidationErrors(w, req, v)
+		return
+	}
+
+	if pendingEmail != "" {
+		existing, err := h.userStore.GetUserByEmail(req.Context(), pendingEmail)
+		if err != nil {
+			h.logger.ErrorContext(req.Context(), "GetUserByEmail", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+		//? checked up front, the address only hits the unique constraint once it is confirmed
+		if existing != nil {
+			utils.WriteError(w, req, utils.NewError(http.StatusConflict, utils.CodeAlreadyExists, "email is already in use").WithFields(map[string][]string{"email": {"email is already in use"}}))
+			return
+		}
+	}
+
+	if changed {
+		err = h.userStore.UpdateUser(req.Context(), &user)
+		if err != nil {
+			if writeConstraintError(w, req, err) {
+				return
+			}
+			h.logger.ErrorContext(req.Context(), "UpdateUser", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+	}
+
+	response := utils.Envelope{"user": &user}
+	if pendingEmail != "" {
+		err = h.sendEmailConfirmation(req, &user, pendingEmail)
+		if err != nil {
+			h.logger.ErrorContext(req.Context(), "sending email confirmation", "error", err)
+			utils.InternalError(w, req)
+			return
+		}
+		response["pending_email"] = pendingEmail
+	}
+
+	utils.WriteJson(w, http.StatusOK, response)
+}
+
+//! sendEmailConfirmation --> stores the pending address and mails the confirmation link to it
+func (h *UserHandler) sendEmailConfirmation(req *http.Request, user *store.User, newEmail string) error {
+	token, err := h.userStore.CreateEmailChange(req.Context(), user.ID, newEmail, emailChangeTTL)
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(h.emailConfirmURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token.Plaintext)
+	link.RawQuery = query.Encode()
+
+	msg := &mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new FitTrack email address",
+		Body: fmt.Sprintf("Hi %s,\n\nopen this link to use this address for your FitTrack account:\n\n%s\n\nThe link works once and expires in %d hours. If you didn't ask for it, you can ignore this email.\n",
+			user.Username, link.String(), int(emailChangeTTL.Hours())),
+	}
+	err = h.mailer.Send(req.Context(), msg)
+	if err != nil {
+		return err
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditEmailChangeRequested, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req), Detail: newEmail})
+	return nil
+}
+
+//! HandleConfirmEmail --> POST /users/email/confirm
+//! Public on purpose: the emailed token is the proof, the link may be opened on another device
+func (h *UserHandler) HandleConfirmEmail(w http.ResponseWriter, req *http.Request) {
+	var body confirmEmailRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	if body.Token == "" {
+		utils.BadRequest(w, req, "token is required")
+		return
+	}
+
+	user, err := h.userStore.ConfirmEmailChange(req.Context(), body.Token)
+	if err != nil {
+		if writeConstraintError(w, req, err) {
+			return
+		}
+		h.logger.ErrorContext(req.Context(), "ConfirmEmailChange", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	if user == nil {
+		utils.WriteError(w, req, utils.NewError(http.StatusBadRequest, utils.CodeInvalidToken, "confirmation link has been expired or invalid"))
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditEmailChanged, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req), Detail: user.Email})
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+//! HandleChangePassword --> PUT /users/me/password
+//! Needs the current password; every other session of the user is logged out afterwards
+func (h *UserHandler) HandleChangePassword(w http.ResponseWriter, req *http.Request) {
+	var body changePasswordRequest
+	err := utils.ReadJSON(w, req, &body)
+	if err != nil {
+		writeReadJSONError(w, req, err)
+		return
+	}
+	if body.CurrentPassword == "" || body.NewPassword == "" {
+		utils.BadRequest(w, req, "current_password and new_password are required")
+		return
+	}
+
+	user := middleware.GetUser(req)
+	loginErr := h.credentials.checkCurrentPassword(req.Context(), user, body.CurrentPassword, utils.ClientIP(req))
+	if loginErr != nil {
+		writeLoginError(w, req, loginErr)
+		return
+	}
+
+	v := validator.New()
+	h.checkPassword(v, "new_password", body.NewPassword, user.Username, user.Email)
+	if !v.Valid() {
+		writeValidationErrors(w, req, v)
+		return
+	}
+
+	err = user.PasswordHash.Set(body.NewPassword)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "hashing password", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+	err = h.userStore.UpdatePassword(req.Context(), user)
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "UpdatePassword", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	//* whoever knew the old password loses their sessions, this one stays logged in
+	err = h.tokenStore.DeleteOtherTokensForUser(req.Context(), user.ID, tokens.ScopeAuth, requestToken(req))
+	if err == nil {
+		err = h.tokenStore.DeleteAllTokensForUser(req.Context(), user.ID, tokens.ScopeMFAPending)
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "revoking sessions after password change", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditPasswordChanged, UserID: &user.ID, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//! HandleDeleteMe --> DELETE /users/me
+//! Deletes the account; workouts, tokens, keys ... go with it through ON DELETE CASCADE
+func (h *UserHandler) HandleDeleteMe(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUser(req)
+
+	err := h.userStore.DeleteUser(req.Context(), int64(user.ID))
+	if errors.Is(err, sql.ErrNoRows) {
+		utils.NotFound(w, req, "user not found")
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(req.Context(), "DeleteUser", "error", err)
+		utils.InternalError(w, req)
+		return
+	}
+
+	//* user row is gone --> keep only the username in the audit entry
+	recordAuditEvent(req.Context(), h.auditStore, h.logger, &store.AuditEvent{Event: store.AuditAccountDeleted, Username: user.Username, IPAddress: utils.ClientIP(req)})
+	h.sessions.Clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/middleware"
+	"fem/internal/passpolicy"
+	"fem/internal/session"
+	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tokens"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestRegisterPasswordPolicy --> weak passwords are refused with every reason listed
+func TestRegisterPasswordPolicy(t *testing.T) {
+	test := []struct {
+		name        string
+		password    string
+		wantStatus  int
+		wantReasons int
+	}{
+		{name: "strong", password: "correct horse battery staple", wantStatus: http.StatusCreated},
+		{name: "short and contains username", password: "ayush1", wantStatus: http.StatusUnprocessableEntity, wantReasons: 2},
+		{name: "guessable", password: "1234567890", wantStatus: http.StatusUnprocessableEntity, wantReasons: 1},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			users, tokenStore := newMemoryStores(t)
+			h := NewUserHandler(users, tokenStore, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), make(outbox, 1), &passpolicy.DefaultPolicy, session.DefaultCookies, "https://app.example.com/confirm-email", slog.New(slog.DiscardHandler))
+
+			rr := postJSON(h.HandleRegisterUser, `{"username": "ayush", "email": "ayush@example.com", "password": "`+tt.password+`"}`)
+			require.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus != http.StatusUnprocessableEntity {
+				assert.Equal(t, 1, userCount(t, users))
+				return
+			}
+
+			var body struct {
+				Fields map[string][]string `json:"fields"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+			assert.Len(t, body.Fields["password"], tt.wantReasons)
+			assert.Zero(t, userCount(t, users))
+		})
+	}
+}
+
+// ! TestRegisterValidation --> every broken field is reported in one answer
+func TestRegisterValidation(t *testing.T) {
+	users, tokenStore := newMemoryStores(t)
+	h := NewUserHandler(users, tokenStore, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), make(outbox, 1), &passpolicy.DefaultPolicy, session.DefaultCookies, "https://app.example.com/confirm-email", slog.New(slog.DiscardHandler))
+
+	rr := postJSON(h.HandleRegisterUser, `{"username": "", "email": "not-an-email", "password": ""}`)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, utils.ProblemContentType, rr.Header().Get("Content-Type"))
+
+	var body struct {
+		Code   string              `json:"code"`
+		Fields map[string][]string `json:"fields"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.Equal(t, utils.CodeValidationFailed, body.Code)
+	assert.Equal(t, map[string][]string{
+		"username": {"username is required"},
+		"email":    {"email must be a valid email address"},
+		"password": {"password is required"},
+	}, body.Fields)
+	assert.Zero(t, userCount(t, users))
+}
+
+// ! TestRegisterConflict --> a taken username/email is a 409 naming the field, not a 500
+func TestRegisterConflict(t *testing.T) {
+	users, tokenStore := newMemoryStores(t, &store.User{Username: "ayush", Email: "ayush@example.com"})
+	h := NewUserHandler(users, tokenStore, &discardAudit{}, throttle.NewLoginThrottler(&noLoginAttempts{}), make(outbox, 1), &passpolicy.DefaultPolicy, session.DefaultCookies, "https://app.example.com/confirm-email", slog.New(slog.DiscardHandler))
+
+	rr := postJSON(h.HandleRegisterUser, `{"username": "ayush", "email": "someone@example.com", "password": "correct horse battery staple"}`)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	var body struct {
+		Code   string              `json:"code"`
+		Fields map[string][]string `json:"fields"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.Equal(t, utils.CodeAlreadyExists, body.Code)
+	assert.Equal(t, []string{"username is already taken"}, body.Fields["username"])
+	assert.Equal(t, 1, userCount(t, users))
+}
+
+// ! TestUpdateProfile --> username/bio change right away, a new email only after confirming it
+func TestUpdateProfile(t *testing.T) {
+	env := newProfileTestEnv(t)
+	token := env.login(t, 1)
+
+	status, _ := env.send(t, http.MethodPatch, "/users/me", token, `{"username": "other"}`)
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, body := env.send(t, http.MethodPatch, "/users/me", token, `{"username": "ayush_k", "bio": "marathons", "email": "new@example.com"}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "new@example.com", body["pending_email"])
+	user := storedUser(t, env.users, 1)
+	assert.Equal(t, "ayush_k", user.Username)
+	assert.Equal(t, "marathons", user.Bio)
+	assert.Equal(t, "ayush@example.com", user.Email) // ? not before the link is opened
+
+	msg := <-env.mails
+	assert.Equal(t, "new@example.com", msg.To)
+	link, err := url.Parse(regexp.MustCompile(`https://\S+`).FindString(msg.Body))
+	require.NoError(t, err)
+	confirm := `{"token": "` + link.Query().Get("token") + `"}`
+
+	status, _ = env.send(t, http.MethodPost, "/users/email/confirm", "", confirm)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "new@example.com", storedUser(t, env.users, 1).Email)
+
+	status, _ = env.send(t, http.MethodPost, "/users/email/confirm", "", confirm)
+	assert.Equal(t, http.StatusBadRequest, status) // * single use
+}
+
+// ! TestChangePassword --> needs the current password, passes the policy, logs out every other session
+func TestChangePassword(t *testing.T) {
+	test := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "wrong current password", body: `{"current_password": "wrong", "new_password": "correct horse battery staple"}`, wantStatus: http.StatusForbidden},
+		{name: "weak new password", body: `{"current_password": "Secret123!", "new_password": "ayush12345"}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "changed", body: `{"current_password": "Secret123!", "new_password": "correct horse battery staple"}`, wantStatus: http.StatusNoContent},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newProfileTestEnv(t)
+			current := env.login(t, 1)
+			other := env.login(t, 1)
+
+			status, _ := env.send(t, http.MethodPut, "/users/me/password", current, tt.body)
+			require.Equal(t, tt.wantStatus, status)
+
+			changed := tt.wantStatus == http.StatusNoContent
+			match, err := storedUser(t, env.users, 1).PasswordHash.Matches("correct horse battery staple")
+			require.NoError(t, err)
+			assert.Equal(t, changed, match)
+			assert.NotNil(t, env.sessionUser(t, current))
+			assert.Equal(t, !changed, env.sessionUser(t, other) != nil)
+		})
+	}
+}
+
+// ! TestChangePasswordThrottled --> guessing the current password with a session token runs into the login backoff
+func TestChangePasswordThrottled(t *testing.T) {
+	env := newProfileTestEnv(t)
+	token := env.login(t, 1)
+	wrong := `{"current_password": "wrong", "new_password": "correct horse battery staple"}`
+
+	// * default username policy --> three free failures, the fourth starts the backoff
+	for i := 0; i < 4; i++ {
+		status, _ := env.send(t, http.MethodPut, "/users/me/password", token, wrong)
+		require.Equal(t, http.StatusForbidden, status, "attempt %d", i)
+	}
+
+	// ? even the right password has to wait now
+	status, body := env.send(t, http.MethodPut, "/users/me/password", token, `{"current_password": "Secret123!", "new_password": "correct horse battery staple"}`)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Equal(t, utils.CodeTooManyAttempts, body["code"])
+}
+
+// ! TestDeleteMe --> the account and its sessions are gone
+func TestDeleteMe(t *testing.T) {
+	env := newProfileTestEnv(t)
+	token := env.login(t, 1)
+
+	status, _ := env.send(t, http.MethodDelete, "/users/me", token, "")
+	require.Equal(t, http.StatusNoContent, status)
+	assert.Nil(t, storedUser(t, env.users, 1))
+
+	status, _ = env.send(t, http.MethodGet, "/users/me", token, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+// * profileTestEnv --> ayush (id 1) and other (id 2), /users/me routes behind Authenticate like routes.go wires them
+type profileTestEnv struct {
+	server *httptest.Server
+	users  *store.MemoryUserStore
+	tokens *store.MemoryTokenStore
+	mails  outbox
+}
+
+func newProfileTestEnv(t *testing.T) *profileTestEnv {
+	t.Helper()
+	user := &store.User{Username: "ayush", Email: "ayush@example.com"}
+	require.NoError(t, user.PasswordHash.Set("Secret123!"))
+	users, tokenStore := newMemoryStores(t, user, &store.User{Username: "other", Email: "other@example.com"})
+	mails := make(outbox, 10)
+	h := NewUserHandler(users, tokenStore, &discardAudit{}, throttle.NewLoginThrottler(newMemoryLoginAttempts()), mails, &passpolicy.DefaultPolicy, session.DefaultCookies, "https://app.example.com/confirm-email", slog.New(slog.DiscardHandler))
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(mw.Authenticate)
+		r.Get("/users/me", mw.RequireUser(mw.RequireLoginSession(h.HandleGetMe)))
+		r.Patch("/users/me", mw.RequireUser(mw.RequireLoginSession(h.HandleUpdateMe)))
+		r.Put("/users/me/password", mw.RequireUser(mw.RequireLoginSession(h.HandleChangePassword)))
+		r.Delete("/users/me", mw.RequireUser(mw.RequireLoginSession(h.HandleDeleteMe)))
+	})
+	r.Post("/users/email/confirm", h.HandleConfirmEmail)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return &profileTestEnv{server: server, users: users, tokens: tokenStore, mails: mails}
+}
+
+func (e *profileTestEnv) login(t *testing.T, userID int) string {
+	t.Helper()
+	token, err := e.tokens.CreateNewToken(context.Background(), userID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	return token.Plaintext
+}
+
+// * sessionUser --> who a login token belongs to, nil once it has been revoked
+func (e *profileTestEnv) sessionUser(t *testing.T, token string) *store.User {
+	t.Helper()
+	user, err := e.users.GetUserToken(context.Background(), tokens.ScopeAuth, token)
+	require.NoError(t, err)
+	return user
+}
+
+func (e *profileTestEnv) send(t *testing.T, method string, path string, token string, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(method, e.server.URL+path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	decoded := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&decoded)
+	return resp.StatusCode, decoded
+}
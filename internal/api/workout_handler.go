@@ -1,34 +1,59 @@
 package api
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"fem/internal/metrics"
 	"fem/internal/middleware"
+	"fem/internal/rbac"
 	"fem/internal/store"
 	"fem/internal/utils"
-	"log"
+	"fem/internal/validator"
+	"log/slog"
 	"net/http"
-	"strconv"
-
-	"github.com/go-chi/chi/v5"
 )
 
 // types declaration
 type WorkoutHandler struct {
 	workstore store.WorkoutStore //* interface --> allows swapping db implementations without changing handler logic
-	logger *log.Logger //* for logging errors and important events
+	coachStore store.CoachStore //* coach grants --> who besides the owner may read/write
+	metrics *metrics.Metrics //* workouts created / entries logged counters
+	logger *slog.Logger //* for logging errors and important events
 
 }
 
 // ? - constructor function that returns instance of WorkoutHandler with initialized fields
-func NewWorkoutHandler(workoutStore store.WorkoutStore,logger *log.Logger) *WorkoutHandler {
+func NewWorkoutHandler(workoutStore store.WorkoutStore,coachStore store.CoachStore,metrics *metrics.Metrics,logger *slog.Logger) *WorkoutHandler {
 return &WorkoutHandler{
 	workstore: workoutStore,
+	coachStore: coachStore,
+	metrics: metrics,
 	logger: logger,
 }
 }
 
+//! canAccessWorkout --> single authorization rule for every workout route
+//! Owner always; a coach needs an accepted grant, and read_write for changes
+func (wh *WorkoutHandler) canAccessWorkout(ctx context.Context,user *store.User,ownerID int,write bool) (bool,error) {
+	if ownerID == user.ID {
+		return true,nil
+	}
+	//? demoted coaches lose access even if the athlete never revoked the grant
+	if !rbac.Can(user.Role,rbac.PermCoachAthletes) {
+		return false,nil
+	}
+
+	access,err := wh.coachStore.GetCoachAccess(ctx, user.ID,ownerID)
+	if err != nil {
+		return false,err
+	}
+	if write {
+		return access == store.CoachAccessReadWrite,nil
+	}
+	return access == store.CoachAccessRead || access == store.CoachAccessReadWrite,nil
+}
+
 //! methods --> have base method WorkoutHandler ( points to type which persists changes across app) --> other called via base this one	
 //! GET /workouts/{id} --> fetches single workout by its ID
 func (wh *WorkoutHandler) HandleWorkoutByID(w http.ResponseWriter, req *http.Request) {
@@ -37,16 +62,32 @@ func (wh *WorkoutHandler) HandleWorkoutByID(w http.ResponseWriter, req *http.Req
 //* reading workout ID from URL path parameter
 workoutID,err := utils.ReadIDParam(req)
 if err != nil {
-	wh.logger.Printf("Error : readIdParam : %v ",err)
-	utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "Invalid workout id"})
+	wh.logger.WarnContext(req.Context(),"readIdParam","error",err)
+	utils.BadRequest(w,req,"Invalid workout id")
 	return
 }
 
-workout,err := wh.workstore.GetWorkoutByID(workoutID)
+workout,err := wh.workstore.GetWorkoutByID(req.Context(),workoutID)
 if err != nil {
 	// ? - db error fetching workout
-	wh.logger.Printf("Error : getWorkoutByID : %v ",err)
-	utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error" : "Internal Server Error"})
+	wh.logger.ErrorContext(req.Context(),"getWorkoutByID","error",err)
+	utils.InternalError(w,req)
+	return
+}
+if workout == nil {
+	utils.NotFound(w,req,"workout does not exists")
+	return
+}
+
+//! Authorization check: owner or a coach the owner granted access to
+allowed,err := wh.canAccessWorkout(req.Context(),middleware.GetUser(req),workout.UserID,false)
+if err != nil {
+	wh.logger.ErrorContext(req.Context(),"canAccessWorkout","error",err)
+	utils.InternalError(w,req)
+	return
+}
+if !allowed {
+	utils.Forbidden(w,req,"you are not authorized to view this workout")
 	return
 }
 // * sending json response with helper function
@@ -59,34 +100,100 @@ utils.WriteJson(w,http.StatusOK,utils.Envelope{"workout":workout})
 func (wh *WorkoutHandler) HandleCreateWorkout (w http.ResponseWriter, req *http.Request) {
 var workout  store.Workout //* follows type def of this struct
 //* decode incoming JSON body into workout struct
-err := json.NewDecoder(req.Body).Decode(&workout)
+err := utils.ReadJSON(w,req,&workout)
 
 if err !=nil {
-wh.logger.Printf("Error : decodingCreateWorkout : %v ",err)
-utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "Invalid request sent"})
+wh.logger.WarnContext(req.Context(),"decodingCreateWorkout","error",err)
+writeReadJSONError(w,req,err)
 	return
 }
 
 //! Current live user with get user which is fetched from context using getUser method
 currentUser := middleware.GetUser(req)
 if currentUser == nil || currentUser == store.AnonymousUser {
-	utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "you must be logged in"})
+	utils.Unauthorized(w,req,"you must be logged in")
 	return
 }
 
 //* assigning authenticated user's ID to workout --> links workout ownership
 workout.UserID = currentUser.ID
+workout.CreatedBy = currentUser.ID //* never trust created_by from the request body
+
+//! every field error at once, before anything reaches the database
+v := validator.New()
+store.ValidateWorkout(v,&workout)
+if !v.Valid() {
+	writeValidationErrors(w,req,v)
+	return
+}
 
-createWorkout,err := wh.workstore.CreateWorkout(&workout)
+createWorkout,err := wh.workstore.CreateWorkout(req.Context(),&workout)
 if err !=nil {
-	wh.logger.Printf("Error : createWorkout : %v ",err)
-	utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error" : "failed to create workout"})
+	if writeConstraintError(w,req,err) {
+		return
+	}
+	wh.logger.ErrorContext(req.Context(),"createWorkout","error",err)
+	utils.InternalError(w,req)
 	return
 }
+wh.metrics.WorkoutCreated(len(createWorkout.Entries)) //* business counters on /metrics
 
 utils.WriteJson(w,http.StatusCreated,utils.Envelope{"workout" : createWorkout})
 }
 
+//! POST /coach/athletes/{id}/workouts --> coach programs a workout owned by the athlete
+func (wh *WorkoutHandler) HandleCreateAthleteWorkout(w http.ResponseWriter, req *http.Request) {
+	athleteID,err := utils.ReadIDParam(req)
+	if err != nil {
+		utils.BadRequest(w,req,"Invalid athlete id")
+		return
+	}
+
+	var workout store.Workout
+	err = utils.ReadJSON(w,req,&workout)
+	if err != nil {
+		wh.logger.WarnContext(req.Context(),"decodingCreateWorkout","error",err)
+		writeReadJSONError(w,req,err)
+		return
+	}
+
+	coach := middleware.GetUser(req)
+	allowed,err := wh.canAccessWorkout(req.Context(),coach,int(athleteID),true)
+	if err != nil {
+		wh.logger.ErrorContext(req.Context(),"canAccessWorkout","error",err)
+		utils.InternalError(w,req)
+		return
+	}
+	if !allowed {
+		utils.Forbidden(w,req,"you need read_write access to create workouts for this athlete")
+		return
+	}
+
+	//* athlete owns it, coach is recorded as the creator
+	workout.UserID = int(athleteID)
+	workout.CreatedBy = coach.ID
+
+	v := validator.New()
+	store.ValidateWorkout(v,&workout)
+	if !v.Valid() {
+		writeValidationErrors(w,req,v)
+		return
+	}
+
+	createWorkout,err := wh.workstore.CreateWorkout(req.Context(),&workout)
+	if err != nil {
+		if writeConstraintError(w,req,err) {
+			return
+		}
+		wh.logger.ErrorContext(req.Context(),"createWorkout","error",err)
+		utils.InternalError(w,req)
+		return
+	}
+	wh.metrics.WorkoutCreated(len(createWorkout.Entries))
+
+	utils.WriteJson(w,http.StatusCreated,utils.Envelope{"workout" : createWorkout})
+}
+
 // ! UpdateWorkout Method
 //! PUT /workouts/{id} --> updates existing workout (only if user owns it)
 func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter,req *http.Request) {
@@ -95,18 +202,19 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter,req *htt
 //* reading workout ID from URL path parameter
 workoutID,err := utils.ReadIDParam(req)
 if err!= nil {
-	wh.logger.Printf("Error : readIdParam : %v ",err)
-	utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "Invalid workout update id"})
+	wh.logger.WarnContext(req.Context(),"readIdParam","error",err)
+	utils.BadRequest(w,req,"Invalid workout update id")
+	return
 }
-existingWorkout,err := wh.workstore.GetWorkoutByID(workoutID)
+existingWorkout,err := wh.workstore.GetWorkoutByID(req.Context(),workoutID)
 if err != nil {
 	// ? - db error while fetching workout
-	wh.logger.Printf("Error : getWorkoutByID : %v ",err)
-	utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error" : "Internal server error"})
+	wh.logger.ErrorContext(req.Context(),"getWorkoutByID","error",err)
+	utils.InternalError(w,req)
 	return
 }
 if existingWorkout == nil {
-	http.NotFound(w,req)
+	utils.NotFound(w,req,"workout not found")
 	return
 }
 
@@ -120,12 +228,12 @@ var updateWorkoutRequest struct {
 		CaloriesBurned  *int                 `json:"calories_burned"`
 		Entries         []store.WorkoutEntry `json:"entries"`
 	}
-	err = json.NewDecoder(req.Body).Decode(&updateWorkoutRequest) // this body refrences to instance of the struct which persists changes
+	err = utils.ReadJSON(w,req,&updateWorkoutRequest) // this body refrences to instance of the struct which persists changes
 
 	if err != nil {
 		// ? - failed to parse JSON body
-		wh.logger.Printf("Error : decodingUpdateRequest : %v ",err)
-	    utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "Invalid request payload"})
+		wh.logger.WarnContext(req.Context(),"decodingUpdateRequest","error",err)
+	    writeReadJSONError(w,req,err)
 		return
 	}
 
@@ -150,35 +258,52 @@ var updateWorkoutRequest struct {
 	//  Current live user with get user which is fetched from context using getUser method
 	currentUser := middleware.GetUser(req)
 	if currentUser == nil || currentUser == store.AnonymousUser {
-	utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "you must be logged in to update"})
+	utils.Unauthorized(w,req,"you must be logged in to update")
 	return
 	}
 
 	//* fetch who owns this workout from db
-	workoutOwner,err := wh.workstore.GetWorkoutOwner(workoutID)
+	workoutOwner,err := wh.workstore.GetWorkoutOwner(req.Context(),workoutID)
 	if err != nil {
 		if errors.Is(err,sql.ErrNoRows) {
-			utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "workout does not exists"})
+			utils.NotFound(w,req,"workout does not exists")
 			return	
 		}
-		utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error" : "internal server error"})
+		utils.InternalError(w,req)
 		return
 	}
 
-	//! Authorization check: current user who is live on but isn't this workout owner ... trying to alternate someone's workout
-	if workoutOwner != currentUser.ID {
-		utils.WriteJson(w,http.StatusForbidden,utils.Envelope{"error" : "you are not authorized to update this workout"})
+	//! Authorization check: owner or a coach with read_write access ... anyone else is trying to alternate someone's workout
+	allowed,err := wh.canAccessWorkout(req.Context(),currentUser,workoutOwner,true)
+	if err != nil {
+		wh.logger.ErrorContext(req.Context(),"canAccessWorkout","error",err)
+		utils.InternalError(w,req)
+		return
+	}
+	if !allowed {
+		utils.Forbidden(w,req,"you are not authorized to update this workout")
 		return
 	}
 
 	// ! make sure ID is set for the update
 	existingWorkout.ID = int(workoutID)
 	
-	err = wh.workstore.UpdateWorkout(existingWorkout)
+	//* validated after merging --> the stored workout must be valid as a whole, not just the changed fields
+	v := validator.New()
+	store.ValidateWorkout(v,existingWorkout)
+	if !v.Valid() {
+		writeValidationErrors(w,req,v)
+		return
+	}
+
+	err = wh.workstore.UpdateWorkout(req.Context(),existingWorkout)
 	if err !=nil {
+		if writeConstraintError(w,req,err) {
+			return
+		}
 		// ? - db error while updating
-		wh.logger.Printf("Error : updateWorkout : %v ",err)
-		utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error" : "Internal server error"})
+		wh.logger.ErrorContext(req.Context(),"updateWorkout","error",err)
+		utils.InternalError(w,req)
 		return
 	}
 
@@ -188,56 +313,54 @@ var updateWorkoutRequest struct {
 
 //! DELETE /workouts/{id} --> deletes workout (only if user owns it)
 func (wh *WorkoutHandler) HandleDeleteWorkoutByID(w http.ResponseWriter, req *http.Request)  {
-	//* extracting id from url via chi
-	paramsWorkoutID := chi.URLParam(req,"id") // passing req and "slug" being route params
-
-	// if id not found on url params
-	if paramsWorkoutID == "" {
-		http.NotFound(w,req)
-		return
-	}
-
-	//* convert string ID to int64 for db query
-	workoutID,err := strconv.ParseInt(paramsWorkoutID,10,64)
+	//* reading workout ID from URL path parameter
+	workoutID,err := utils.ReadIDParam(req)
 	if err != nil {
-		http.NotFound(w,req)
+		wh.logger.WarnContext(req.Context(),"readIdParam","error",err)
+		utils.BadRequest(w,req,"Invalid workout delete id")
 		return
-	}  
+	}
 
 	//! Current live user with get user which is fetched from context using getUser method
 	currentUser := middleware.GetUser(req)
 	if currentUser == nil || currentUser == store.AnonymousUser {
-	utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "you must be logged in to update"})
+	utils.Unauthorized(w,req,"you must be logged in to delete")
 	return
 	}
 
 	//* verify workout exists and get its owner
-	workoutOwner,err := wh.workstore.GetWorkoutOwner(workoutID)
+	workoutOwner,err := wh.workstore.GetWorkoutOwner(req.Context(),workoutID)
 	if err != nil {
 		if errors.Is(err,sql.ErrNoRows) {
-			utils.WriteJson(w,http.StatusBadRequest,utils.Envelope{"error" : "workout does not exists"})
+			utils.NotFound(w,req,"workout does not exists")
 			return	
 		}
-		utils.WriteJson(w,http.StatusInternalServerError,utils.Envelope{"error" : "internal server error"})
+		utils.InternalError(w,req)
 		return
 	}
 
-	//! Authorization check: if current user is not owner of that workout the client is trying to modify it
-	if workoutOwner != currentUser.ID {
-		utils.WriteJson(w,http.StatusForbidden,utils.Envelope{"error" : "you are not authorized to delete this workout"})
+	//! Authorization check: if current user is not owner (or a read_write coach) of that workout the client is trying to modify it
+	allowed,err := wh.canAccessWorkout(req.Context(),currentUser,workoutOwner,true)
+	if err != nil {
+		wh.logger.ErrorContext(req.Context(),"canAccessWorkout","error",err)
+		utils.InternalError(w,req)
+		return
+	}
+	if !allowed {
+		utils.Forbidden(w,req,"you are not authorized to delete this workout")
 		return
 	}
 
 
 
 //* perform delete operation in database
-err = wh.workstore.DeleteWorkout(workoutID)
-if err == sql.ErrNoRows {
-http.Error(w,"Workout not found",http.StatusNotFound)
+err = wh.workstore.DeleteWorkout(req.Context(),workoutID)
+if errors.Is(err,sql.ErrNoRows) {
+utils.NotFound(w,req,"workout not found")
 return
 }  
 if err != nil {
-http.Error(w,"error deleting the workout", http.StatusInternalServerError)
+utils.InternalError(w,req)
 return
 }  
 
@@ -249,6 +372,6 @@ w.WriteHeader(http.StatusNoContent)
 // 1. WorkoutStore interface --> defines what methods our store needs (contract/blueprint)
 // 2. PostgresWorkoutStore --> actual implementation with real SQL queries
 // 3. WorkoutHandler holds workstore (the interface) --> can swap db types easily
-// 4. Handler methods (like HandleCreateWorkout) --> call wh.workstore.CreateWorkout() 
+// 4. Handler methods (like HandleCreateWorkout) --> call wh.workstore.CreateWorkout(req.Context(),) 
 // 5. Routes hook these handlers to URLs --> /workouts maps to HandleCreateWorkout
 // ? - interface lets us swap PostgreSQL for MySQL/MongoDB without touching handlers! 
\ No newline at end of file
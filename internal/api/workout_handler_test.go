@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fem/internal/metrics"
+	"fem/internal/middleware"
+	"fem/internal/store"
+	"fem/internal/tokens"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// * workoutServer --> workout routes behind the real authentication middleware, all stores in memory
+func workoutServer(t *testing.T) (*httptest.Server, store.UserStore, store.TokenStore) {
+	db := store.NewMemoryDB()
+	users := store.NewMemoryUserStore(db)
+	tokenStore := store.NewMemoryTokenStore(db)
+	h := NewWorkoutHandler(store.NewMemoryWorkoutStore(db), newMemoryCoaching(users), metrics.New(), slog.New(slog.DiscardHandler))
+	mw := middleware.UserMiddleware{UserStore: users, TokenStore: tokenStore}
+
+	r := chi.NewRouter()
+	r.Use(mw.Authenticate)
+	r.Get("/workouts/{id}", mw.RequireUser(h.HandleWorkoutByID))
+	r.Post("/workouts", mw.RequireUser(h.HandleCreateWorkout))
+	r.Put("/workouts/{id}", mw.RequireUser(h.HandleUpdateWorkoutByID))
+	r.Delete("/workouts/{id}", mw.RequireUser(h.HandleDeleteWorkoutByID))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, users, tokenStore
+}
+
+// * loginAs --> registers username and returns a bearer token for it
+func loginAs(t *testing.T, users store.UserStore, tokenStore store.TokenStore, username string) string {
+	user := &store.User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, user.PasswordHash.Set("correct horse battery"))
+	require.NoError(t, users.CreateUser(context.Background(), user))
+	token, err := tokenStore.CreateNewToken(context.Background(), user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	return token.Plaintext
+}
+
+// * send --> request with an optional bearer token and JSON body
+func send(t *testing.T, method string, url string, token string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// ! TestWorkoutLifecycle --> create, read, update and delete over HTTP, only the owner gets access
+func TestWorkoutLifecycle(t *testing.T) {
+	server, users, tokenStore := workoutServer(t)
+	owner := loginAs(t, users, tokenStore, "ayush")
+	stranger := loginAs(t, users, tokenStore, "mallory")
+
+	res := send(t, http.MethodPost, server.URL+"/workouts", owner, `{"title": "push day", "duration_minutes": 60, "entries": [
+		{"exercise_name": "dips", "sets": 3, "reps": 12, "order_index": 2},
+		{"exercise_name": "bench press", "sets": 4, "reps": 8, "weight": 80, "order_index": 1}]}`)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var created struct {
+		Workout store.Workout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&created))
+	url := server.URL + "/workouts/" + strconv.Itoa(created.Workout.ID)
+
+	// ? entries come back in order_index order
+	res = send(t, http.MethodGet, url, owner, "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var fetched struct {
+		Workout store.Workout `json:"workout"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&fetched))
+	require.Len(t, fetched.Workout.Entries, 2)
+	assert.Equal(t, "bench press", fetched.Workout.Entries[0].ExerciseName)
+
+	// ? somebody else's workout
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodGet, url, stranger, "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(t, http.MethodDelete, url, stranger, "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, send(t, http.MethodGet, url, "", "").StatusCode)
+
+	res = send(t, http.MethodPut, url, owner, `{"title": "push day (deload)", "entries": [{"exercise_name": "push ups", "sets": 2, "reps": 20, "order_index": 1}]}`)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = send(t, http.MethodGet, url, owner, "")
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&fetched))
+	assert.Equal(t, "push day (deload)", fetched.Workout.Title)
+	require.Len(t, fetched.Workout.Entries, 1)
+	assert.Equal(t, "push ups", fetched.Workout.Entries[0].ExerciseName)
+
+	assert.Equal(t, http.StatusNoContent, send(t, http.MethodDelete, url, owner, "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, send(t, http.MethodGet, url, owner, "").StatusCode)
+}
+
+// ! TestWorkoutTokens --> expired login tokens are refused, unknown workouts are 404, bad ids 400
+func TestWorkoutTokens(t *testing.T) {
+	server, users, tokenStore := workoutServer(t)
+	owner := loginAs(t, users, tokenStore, "ayush")
+
+	// ? expired login token --> 401 before any handler runs
+	expired, err := tokenStore.CreateNewToken(context.Background(), 1, -time.Minute, tokens.ScopeAuth)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, send(t, http.MethodPost, server.URL+"/workouts", expired.Plaintext, `{"title": "x"}`).StatusCode)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		assert.Equal(t, http.StatusNotFound, send(t, method, server.URL+"/workouts/999", owner, `{"title": "x"}`).StatusCode, method)
+	}
+	assert.Equal(t, http.StatusBadRequest, send(t, http.MethodDelete, server.URL+"/workouts/abc", owner, "").StatusCode)
+
+	// ? a malformed id stops at the 400, nothing else gets written after it
+	res := send(t, http.MethodPut, server.URL+"/workouts/abc", owner, `{"title": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var problem map[string]interface{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&problem))
+	assert.False(t, json.NewDecoder(res.Body).More())
+}
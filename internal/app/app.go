@@ -1,36 +1,95 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"fem/internal/api"
+	"fem/internal/config"
+	"fem/internal/health"
+	"fem/internal/logging"
+	"fem/internal/metrics"
+	"fem/internal/mailer"
 	"fem/internal/middleware"
+	"fem/internal/oidc"
+	"fem/internal/passhash"
+	"fem/internal/passpolicy"
+	"fem/internal/session"
 	"fem/internal/store"
+	"fem/internal/throttle"
+	"fem/internal/tracing"
 	"fem/migrations"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 //! types declarement
 //! Application struct --> holds all dependencies needed across the app
 type Application struct {
-	Logger *log.Logger //* centralized logger for error tracking
+	Config *config.Config //* validated settings, main reads the server section
+	Logger *slog.Logger //* JSON logger, request attributes come from the context (see internal/logging)
 	WorkoutHandler *api.WorkoutHandler //* handles workout CRUD operations
-	UserHandler *api.UserHandler //* handles user registration
+	UserHandler *api.UserHandler //* handles user registration and profiles
 	TokenHandler *api.TokenHandler //* handles authentication token creation
+	MFAHandler *api.MFAHandler //* handles two-factor enrollment
+	APIKeyHandler *api.APIKeyHandler //* handles personal api key management
+	AdminHandler *api.AdminHandler //* handles staff user management
+	CoachHandler *api.CoachHandler //* handles coach/athlete invitations
+	OAuthHandler *api.OAuthHandler //* handles oauth2 clients, consent and tokens
+	OIDCHandler *api.OIDCHandler //* handles "sign in with ..." through external providers
+	MagicLinkHandler *api.MagicLinkHandler //* handles passwordless email logins
 	Middleware middleware.UserMiddleware //* authentication middleware for protected routes
+	Metrics *metrics.Metrics //* prometheus collectors served on /metrics
+	Health *health.Checker //* /healthz + /readyz, main flips readiness on startup and shutdown
+	TracerProvider *sdktrace.TracerProvider //* request + query spans, Shutdown flushes what is still buffered
 	DB *sql.DB //* database connection pool
+	Pool *pgxpool.Pool //* native pgx pool behind the workout store, nil unless database.driver = pgxpool
 }
 
 //! NewApplication --> constructor that initializes entire app with all dependencies
-func NewApplication() (*Application,error) {
+//! cfg comes from config.Load and is already validated
+func NewApplication(cfg *config.Config) (*Application,error) {
 
-	//* establishing database connection
-	pgDb,err := store.Open()
+	//* structured JSON logs on stdout, log.level = debug|info|warn|error
+	logLevel,err := logging.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil,err
+	}
+	logger := logging.New(os.Stdout,logLevel)
+	slog.SetDefault(logger) //* stray log.Printf calls (libraries) end up as JSON too
+
+	//* distributed tracing --> tracing.exporter = none|stdout|otlp, spans for requests and store queries
+	tracerProvider,err := tracing.New(context.Background(),cfg.Tracing.Exporter,cfg.Tracing.ServiceName,os.Stdout)
+	if err != nil {
+		return nil,err
+	}
+
+	//* establishing database connection --> pool limits from the database section
+	poolConfig := store.PoolConfig{
+		MaxOpenConns: cfg.Database.MaxOpenConns,
+		MaxIdleConns: cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.Database.ConnMaxIdleTime,
+	}
+	pgDb,err := store.Open(cfg.Database.DSN(),poolConfig)
+	if err != nil {
+		return nil,err
+	}
+	//* postgres may still be starting (docker compose) --> retry with backoff for database.connect_timeout
+	connectCtx,cancel := context.WithTimeout(context.Background(),cfg.Database.ConnectTimeout)
+	defer cancel()
+	err = store.WaitForDB(connectCtx,pgDb.PingContext,logger)
 	if err != nil {
+		pgDb.Close()
 		return nil,err
 	}
+	logger.Info("connected to the database","host",cfg.Database.Host,"port",cfg.Database.Port,"driver",cfg.Database.Driver,"max_open_conns",cfg.Database.MaxOpenConns)
 
 	//* running database migrations --> ensures tables are up to date
 	err = store.Migratefs(pgDb,migrations.FS,".")
@@ -38,37 +97,206 @@ func NewApplication() (*Application,error) {
 		panic(err)
 	}
 
+	//* password hashing parameters --> existing hashes are upgraded on the next login
+	store.SetPasswordHasher(newPasswordHasher(cfg.Auth))
 
-	//* creating logger instance with date and time stamps
-	logger := log.New(os.Stdout,"",log.Ldate | log.Ltime) 
+	//* upper bound per store call --> a hung query frees its connection instead of piling up requests
+	store.SetQueryTimeout(cfg.Database.QueryTimeout)
 
 	//! Initializing all store instances --> database layer that talks to postgres
-	workoutStore := store.NewPostgresWorkoutStore(pgDb) //* workout operations
+	var workoutStore store.WorkoutStore = store.NewPostgresWorkoutStore(pgDb) //* workout operations
+	//? database.driver = pgxpool --> workouts go through a native pgx pool with batched entry inserts
+	var pgxPool *pgxpool.Pool
+	if cfg.Database.Driver == config.DriverPgxpool {
+		pgxPool,err = store.OpenPool(context.Background(),cfg.Database.DSN(),poolConfig)
+		if err != nil {
+			pgDb.Close()
+			return nil,err
+		}
+		err = store.WaitForDB(connectCtx,pgxPool.Ping,logger)
+		if err != nil {
+			pgxPool.Close()
+			pgDb.Close()
+			return nil,err
+		}
+		workoutStore = store.NewPgxWorkoutStore(pgxPool)
+	}
 	userStore := store.NewPostUserStore(pgDb) //* user operations
 	tokenStore := store.NewPostgresTokenStore(pgDb) //* token operations
+	totpStore := store.NewPostgresTOTPStore(pgDb) //* two-factor secrets and recovery codes
+	auditStore := store.NewPostgresAuditStore(pgDb) //* security audit log
+	loginAttemptStore := store.NewPostgresLoginAttemptStore(pgDb) //* failed login counters
+	apiKeyStore := store.NewPostgresAPIKeyStore(pgDb) //* personal api keys
+	coachStore := store.NewPostgresCoachStore(pgDb) //* coach/athlete grants
+	oauthStore := store.NewPostgresOAuthStore(pgDb) //* oauth2 clients and authorization codes
+	identityStore := store.NewPostgresIdentityStore(pgDb) //* linked external identities
+
+	//* readiness --> postgres reachable within the timeout + the goose version the schema is at
+	healthChecker := health.New(2*time.Second,logger)
+	healthChecker.Add("database",func(ctx context.Context) (map[string]any,error) {
+		return nil,pgDb.PingContext(ctx)
+	})
+	if pgxPool != nil {
+		healthChecker.Add("pgxpool",func(ctx context.Context) (map[string]any,error) {
+			stat := pgxPool.Stat()
+			return map[string]any{"total_conns": stat.TotalConns(),"idle_conns": stat.IdleConns()},pgxPool.Ping(ctx)
+		})
+	}
+	healthChecker.Add("migrations",func(ctx context.Context) (map[string]any,error) {
+		version,err := store.MigrationVersion(ctx,pgDb)
+		return map[string]any{"version": version},err
+	})
+
+	//* prometheus collectors --> http middleware, handlers and the db pool all report here
+	appMetrics := metrics.New()
+	appMetrics.RegisterDB(pgDb,"postgres")
+
+	//* brute-force protection shared by every login endpoint
+	loginThrottler := throttle.NewLoginThrottler(loginAttemptStore)
 
 	//! Initializing all handler instances --> HTTP request handlers
-	workoutHandler := api.NewWorkoutHandler(workoutStore,logger) //* workout endpoints
-	userHandler := api.NewUserHandler(userStore,logger) //* user registration endpoint
-	tokenHandler := api.NewTokenHandler(tokenStore,userStore,logger) //* authentication endpoint
-	mwHandler := middleware.UserMiddleware{UserStore: userStore} //* middleware for auth checks
+	workoutHandler := api.NewWorkoutHandler(workoutStore,coachStore,appMetrics,logger) //* workout endpoints
+	//* password rules, optionally with an offline breached-password list
+	passwordPolicy,err := loadPasswordPolicy(cfg.Password,logger)
+	if err != nil {
+		return nil,err
+	}
+	//* cookie attributes for browser logins (?session=cookie)
+	sessionCookies := newSessionCookies(cfg.Session)
+	//* outgoing email --> log by default so local setups need no mail server
+	mailSender,err := loadMailer(cfg.Mail,logger)
+	if err != nil {
+		return nil,err
+	}
+	userHandler := api.NewUserHandler(userStore,tokenStore,auditStore,loginThrottler,mailSender,passwordPolicy,sessionCookies,cfg.Links.EmailConfirmURL,logger) //* registration + profile endpoints
+	tokenHandler := api.NewTokenHandler(tokenStore,userStore,totpStore,auditStore,loginThrottler,sessionCookies,cfg.Auth.TokenTTL,appMetrics,logger) //* authentication endpoint
+	mfaHandler := api.NewMFAHandler(totpStore,auditStore,loginThrottler,"FitTrack",logger) //* two-factor enrollment endpoints
+	apiKeyHandler := api.NewAPIKeyHandler(apiKeyStore,logger) //* api key management endpoints
+	adminHandler := api.NewAdminHandler(userStore,tokenStore,auditStore,logger) //* admin user management endpoints
+	coachHandler := api.NewCoachHandler(coachStore,userStore,logger) //* coach/athlete endpoints
+	oauthHandler := api.NewOAuthHandler(oauthStore,tokenStore,userStore,totpStore,auditStore,loginThrottler,logger) //* oauth2 authorization server endpoints
+	//* external identity providers --> none configured means the endpoints answer 404
+	oidcProviders := newOIDCProviders(cfg.OIDC)
+	oidcHandler := api.NewOIDCHandler(oidcProviders,identityStore,userStore,auditStore,tokenHandler,logger) //* openid connect login endpoints
+	magicLinkHandler := api.NewMagicLinkHandler(tokenStore,userStore,auditStore,mailSender,tokenHandler,cfg.Links.MagicLinkURL,logger) //* passwordless login endpoints
+	mwHandler := middleware.UserMiddleware{UserStore: userStore, APIKeyStore: apiKeyStore, TokenStore: tokenStore} //* middleware for auth checks
+
+	//* optional bootstrap --> promotes an existing account so staff never need database access
+	if username := cfg.Auth.BootstrapAdmin; username != "" {
+		err = promoteBootstrapAdmin(context.Background(),userStore,username,logger)
+		if err != nil {
+			return nil,err
+		}
+	}
 
 	//* creating Application instance with all dependencies wired up
 	app := &Application{
+		Config: cfg,
 		Logger : logger,
 		WorkoutHandler: workoutHandler,
 		UserHandler: userHandler,
 		TokenHandler: tokenHandler,
+		MFAHandler: mfaHandler,
+		APIKeyHandler: apiKeyHandler,
+		AdminHandler: adminHandler,
+		CoachHandler: coachHandler,
+		OAuthHandler: oauthHandler,
+		OIDCHandler: oidcHandler,
+		MagicLinkHandler: magicLinkHandler,
 		Middleware : mwHandler,
+		Metrics: appMetrics,
+		Health: healthChecker,
+		TracerProvider: tracerProvider,
 		DB: pgDb,
+		Pool: pgxPool,
 	}
 	
 	return app,nil //* return initialized app ready to handle requests
 
 }
 
-//! HealthCheck --> simple endpoint to verify server is running
-//! GET /health --> returns status message
-func (a *Application) HealthCheck(w http.ResponseWriter,req *http.Request) {
-	fmt.Fprintf(w," 🦖FitTrack API is healthy 🪐 and running with Docker + Air! 🔥\n") //* simple text response
-}
\ No newline at end of file
+//! promoteBootstrapAdmin --> makes the named user an admin (no-op if already admin or not registered yet)
+func promoteBootstrapAdmin(ctx context.Context,userStore store.UserStore,username string,logger *slog.Logger) error {
+	user,err := userStore.GetUserByUsername(ctx,username)
+	if err != nil {
+		return fmt.Errorf("bootstrap admin : %w",err)
+	}
+	if user == nil {
+		logger.Info("bootstrap admin is not registered yet, skipping","username",username)
+		return nil
+	}
+	if user.Role == store.RoleAdmin {
+		return nil
+	}
+	logger.Info("promoting bootstrap admin","username",username)
+	return userStore.SetUserRole(ctx,int64(user.ID),store.RoleAdmin)
+}
+
+//! newOIDCProviders --> one provider per configured entry, config.Validate made sure they are complete
+func newOIDCProviders(configured []config.OIDCProvider) []*oidc.Provider {
+	providers := []*oidc.Provider{}
+	for _, provider := range configured {
+		providers = append(providers,oidc.NewProvider(oidc.Config{
+			Name: provider.Name,
+			Issuer: provider.Issuer,
+			ClientID: provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL: provider.RedirectURL,
+			Scopes: []string{"email","profile"},
+		}))
+	}
+	return providers
+}
+
+//! loadMailer --> log (default), file (mail.dir) or smtp (mail.smtp_* and mail.from)
+func loadMailer(cfg config.Mail,logger *slog.Logger) (mailer.Mailer,error) {
+	switch cfg.Driver {
+	case "file":
+		return mailer.NewFileMailer(cfg.Dir)
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPHost,strconv.Itoa(cfg.SMTPPort),cfg.SMTPUsername,cfg.SMTPPassword,cfg.From),nil
+	default:
+		return mailer.NewLogMailer(logger),nil
+	}
+}
+
+//! newPasswordHasher --> Argon2id with the configured cost, bcrypt for hashes that still use it
+func newPasswordHasher(cfg config.Auth) *passhash.Manager {
+	params := passhash.Argon2idParams{
+		Memory: cfg.Argon2Memory,
+		Iterations: cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+	}
+	return passhash.NewManager(passhash.NewArgon2id(params),passhash.NewBcrypt(cfg.BcryptCost))
+}
+
+//! loadPasswordPolicy --> length and entropy rules, plus the breached password list when one is configured
+func loadPasswordPolicy(cfg config.Password,logger *slog.Logger) (*passpolicy.Policy,error) {
+	policy := passpolicy.DefaultPolicy
+	policy.MinLength = cfg.MinLength
+	policy.MinEntropyBits = cfg.MinEntropyBits
+	if cfg.BreachedFile == "" {
+		return &policy,nil
+	}
+
+	//? a configured but unreadable list fails startup, silently skipping the check would be worse
+	breached,err := passpolicy.OpenBreachedFile(cfg.BreachedFile,cfg.BreachedMinCount)
+	if err != nil {
+		return nil,fmt.Errorf("opening breached passwords : %w",err)
+	}
+	policy.Breached = breached
+	logger.Info("opened breached password list","bytes",policy.Breached.Size(),"path",cfg.BreachedFile)
+	return &policy,nil
+}
+
+//! newSessionCookies --> Secure unless turned off for plain http development, SameSite lax or strict
+func newSessionCookies(cfg config.Session) session.Cookies {
+	cookies := session.DefaultCookies
+	cookies.Domain = cfg.CookieDomain
+	cookies.Secure = cfg.CookieSecure
+	cookies.SameSite = http.SameSiteLaxMode
+	if cfg.CookieSameSite == "strict" {
+		cookies.SameSite = http.SameSiteStrictMode
+	}
+	return cookies
+}
@@ -0,0 +1,282 @@
+//! Package config --> every setting the server reads, in one struct that app.NewApplication gets injected
+//! Sources, later ones win: defaults < config file (YAML or TOML) < environment variables < flags
+package config
+
+import (
+	"errors"
+	"fem/internal/logging"
+	"fem/internal/passhash"
+	"fem/internal/passpolicy"
+	"fem/internal/session"
+	"fem/internal/tracing"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//! Config --> field tags: yaml/toml key in the file, env variable, optional flag name
+//! secret:"true" fields can also be read from a file named by <ENV>_FILE (docker / kubernetes secrets)
+type Config struct {
+	Server   Server         `yaml:"server" toml:"server"`
+	Database Database       `yaml:"database" toml:"database"`
+	Log      Log            `yaml:"log" toml:"log"`
+	Tracing  Tracing        `yaml:"tracing" toml:"tracing"`
+	Metrics  Metrics        `yaml:"metrics" toml:"metrics"`
+	Auth     Auth           `yaml:"auth" toml:"auth"`
+	Password Password       `yaml:"password" toml:"password"`
+	Session  Session        `yaml:"session" toml:"session"`
+	Mail     Mail           `yaml:"mail" toml:"mail"`
+	Links    Links          `yaml:"links" toml:"links"`
+	OIDC     []OIDCProvider `yaml:"oidc_providers" toml:"oidc_providers"` //* env: OIDC_PROVIDERS=google plus OIDC_GOOGLE_*
+}
+
+//! Server --> HTTP listener and shutdown behaviour
+type Server struct {
+	Port            int           `yaml:"port" toml:"port" env:"PORT" flag:"port" usage:"HTTP port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long in-flight requests may run after SIGINT/SIGTERM"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"SHUTDOWN_DELAY" flag:"shutdown-delay" usage:"keep serving this long after /readyz turned 503, so load balancers notice first"`
+}
+
+//! Database --> postgres connection, pool limits and per-query timeout
+type Database struct {
+	Host            string        `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port            int           `yaml:"port" toml:"port" env:"DB_PORT"`
+	User            string        `yaml:"user" toml:"user" env:"DB_USER"`
+	Password        string        `yaml:"password" toml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name            string        `yaml:"name" toml:"name" env:"DB_NAME"`
+	SSLMode         string        `yaml:"sslmode" toml:"sslmode" env:"DB_SSLMODE"`
+	QueryTimeout    time.Duration `yaml:"query_timeout" toml:"query_timeout" env:"DB_QUERY_TIMEOUT"`
+	Driver          string        `yaml:"driver" toml:"driver" env:"DB_DRIVER"` //* stdlib (database/sql) or pgxpool for the workout store
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"DB_CONNECT_TIMEOUT"` //* how long startup keeps retrying the first ping
+}
+
+//! database drivers --> which pool the workout store runs on, every other store stays on database/sql
+const (
+	DriverStdlib  = "stdlib"
+	DriverPgxpool = "pgxpool"
+)
+
+//! DSN --> connection string for the pgx driver
+func (d Database) DSN() string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     fmt.Sprintf("%s:%d", d.Host, d.Port),
+		Path:     d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	return dsn.String()
+}
+
+//! Log --> slog level
+type Log struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+}
+
+//! Tracing --> where OpenTelemetry spans go
+type Tracing struct {
+	Exporter    string `yaml:"exporter" toml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+	ServiceName string `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+//! Metrics --> who may scrape /metrics
+//? route labels and login failure counters are nobody else's business --> own listener, bearer token or both
+type Metrics struct {
+	Addr  string `yaml:"addr" toml:"addr" env:"METRICS_ADDR"`                  //* e.g. 127.0.0.1:9090, /metrics then leaves the public port
+	Token string `yaml:"token" toml:"token" env:"METRICS_TOKEN" secret:"true"` //* scrapers send Authorization: Bearer <token>
+}
+
+//! Auth --> login token lifetime, password hashing cost and the bootstrap admin
+type Auth struct {
+	TokenTTL          time.Duration `yaml:"token_ttl" toml:"token_ttl" env:"AUTH_TOKEN_TTL"`
+	BcryptCost        int           `yaml:"bcrypt_cost" toml:"bcrypt_cost" env:"PASSWORD_BCRYPT_COST"` //* only for hashes rehashed to bcrypt, Argon2id is the default
+	Argon2Memory      uint32        `yaml:"argon2_memory_kib" toml:"argon2_memory_kib" env:"PASSWORD_ARGON2_MEMORY_KIB"`
+	Argon2Iterations  uint32        `yaml:"argon2_iterations" toml:"argon2_iterations" env:"PASSWORD_ARGON2_ITERATIONS"`
+	Argon2Parallelism uint8         `yaml:"argon2_parallelism" toml:"argon2_parallelism" env:"PASSWORD_ARGON2_PARALLELISM"`
+	BootstrapAdmin    string        `yaml:"bootstrap_admin" toml:"bootstrap_admin" env:"BOOTSTRAP_ADMIN_USERNAME"`
+}
+
+//! Password --> rules for new passwords
+type Password struct {
+	MinLength        int     `yaml:"min_length" toml:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	MinEntropyBits   float64 `yaml:"min_entropy_bits" toml:"min_entropy_bits" env:"PASSWORD_MIN_ENTROPY_BITS"`
+	BreachedFile     string  `yaml:"breached_file" toml:"breached_file" env:"BREACHED_PASSWORDS_FILE"`
+	BreachedMinCount int     `yaml:"breached_min_count" toml:"breached_min_count" env:"BREACHED_PASSWORDS_MIN_COUNT"`
+}
+
+//! Session --> attributes of the browser session cookies
+type Session struct {
+	CookieSecure   bool   `yaml:"cookie_secure" toml:"cookie_secure" env:"SESSION_COOKIE_SECURE"`
+	CookieSameSite string `yaml:"cookie_samesite" toml:"cookie_samesite" env:"SESSION_COOKIE_SAMESITE"`
+	CookieDomain   string `yaml:"cookie_domain" toml:"cookie_domain" env:"SESSION_COOKIE_DOMAIN"`
+}
+
+//! Mail --> outgoing email, log (default), file or smtp
+type Mail struct {
+	Driver       string `yaml:"driver" toml:"driver" env:"MAILER"`
+	Dir          string `yaml:"dir" toml:"dir" env:"MAIL_DIR"`
+	From         string `yaml:"from" toml:"from" env:"MAIL_FROM"`
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+//! Links --> frontend pages the emailed tokens point to
+type Links struct {
+	EmailConfirmURL string `yaml:"email_confirm_url" toml:"email_confirm_url" env:"EMAIL_CONFIRM_URL"`
+	MagicLinkURL    string `yaml:"magic_link_url" toml:"magic_link_url" env:"MAGIC_LINK_URL"`
+}
+
+//! OIDCProvider --> one "sign in with ..." provider
+type OIDCProvider struct {
+	Name         string `yaml:"name" toml:"name"`
+	Issuer       string `yaml:"issuer" toml:"issuer"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url" toml:"redirect_url"`
+}
+
+//! Default --> what the server runs with when nothing is configured (local docker-compose setup)
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:            8080,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     time.Minute,
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Database: Database{
+			Host:         "localhost",
+			Port:         5445, //* 5445 for local Windows, 5432 in Docker
+			User:         "postgres",
+			Password:     "postgres",
+			Name:         "postgres",
+			SSLMode:         "disable",
+			QueryTimeout:    5 * time.Second,
+			Driver:          DriverStdlib,
+			MaxOpenConns:    25, //* well below postgres' default max_connections of 100
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnectTimeout:  30 * time.Second,
+		},
+		Log:     Log{Level: "info"},
+		Tracing: Tracing{Exporter: tracing.ExporterNone, ServiceName: "fem"},
+		Auth: Auth{
+			TokenTTL:          24 * time.Hour,
+			BcryptCost:        passhash.DefaultBcryptCost,
+			Argon2Memory:      passhash.DefaultArgon2idParams.Memory,
+			Argon2Iterations:  passhash.DefaultArgon2idParams.Iterations,
+			Argon2Parallelism: passhash.DefaultArgon2idParams.Parallelism,
+		},
+		Password: Password{
+			MinLength:        passpolicy.DefaultPolicy.MinLength,
+			MinEntropyBits:   passpolicy.DefaultPolicy.MinEntropyBits,
+			BreachedMinCount: 1,
+		},
+		Session: Session{
+			CookieSecure:   session.DefaultCookies.Secure,
+			CookieSameSite: "lax",
+		},
+		Mail: Mail{Driver: "log", Dir: "tmp/mail", SMTPPort: 587},
+		Links: Links{
+			EmailConfirmURL: "http://localhost:3000/confirm-email",
+			MagicLinkURL:    "http://localhost:3000/magic-link",
+		},
+	}
+}
+
+//! Validate --> every problem at once, so a bad deployment fails at startup with the full list
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay cannot be negative")
+
+	check(c.Database.Host != "", "database.host is required")
+	check(c.Database.Port > 0 && c.Database.Port <= 65535, "database.port must be between 1 and 65535, got %d", c.Database.Port)
+	check(c.Database.User != "", "database.user is required")
+	check(c.Database.Name != "", "database.name is required")
+	check(oneOf(c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"), "database.sslmode %q is not a postgres sslmode", c.Database.SSLMode)
+	check(c.Database.QueryTimeout > 0, "database.query_timeout must be positive")
+	check(oneOf(c.Database.Driver, DriverStdlib, DriverPgxpool), "database.driver must be %s or %s, got %q", DriverStdlib, DriverPgxpool, c.Database.Driver)
+	check(c.Database.MaxOpenConns > 0, "database.max_open_conns must be positive, got %d", c.Database.MaxOpenConns)
+	check(c.Database.MaxIdleConns >= 0 && c.Database.MaxIdleConns <= c.Database.MaxOpenConns, "database.max_idle_conns must be between 0 and max_open_conns, got %d", c.Database.MaxIdleConns)
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime cannot be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time cannot be negative")
+	check(c.Database.ConnectTimeout > 0, "database.connect_timeout must be positive")
+
+	_, err := logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	check(oneOf(c.Tracing.Exporter, tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP), "tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+
+	if c.Metrics.Addr != "" {
+		_, port, err := net.SplitHostPort(c.Metrics.Addr)
+		check(err == nil && port != "", "metrics.addr must be host:port or :port, got %q", c.Metrics.Addr)
+		check(port != strconv.Itoa(c.Server.Port), "metrics.addr must not use the server port %d", c.Server.Port)
+	}
+
+	check(c.Auth.TokenTTL >= time.Minute, "auth.token_ttl must be at least 1m")
+	check(c.Auth.BcryptCost >= bcrypt.MinCost && c.Auth.BcryptCost <= bcrypt.MaxCost, "auth.bcrypt_cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.Auth.BcryptCost)
+	//? hashes above the cap could never be verified again --> refuse at startup instead
+	check(c.Auth.Argon2Memory > 0 && c.Auth.Argon2Memory <= passhash.MaxArgon2idMemory, "auth.argon2_memory_kib must be between 1 and %d", passhash.MaxArgon2idMemory)
+	check(c.Auth.Argon2Iterations > 0, "auth.argon2_iterations must be positive")
+	check(c.Auth.Argon2Parallelism > 0, "auth.argon2_parallelism must be positive")
+
+	check(c.Password.MinLength >= 1 && c.Password.MinLength <= passpolicy.DefaultPolicy.MaxLength, "password.min_length must be between 1 and %d", passpolicy.DefaultPolicy.MaxLength)
+	check(c.Password.MinEntropyBits >= 0, "password.min_entropy_bits cannot be negative")
+	check(c.Password.BreachedMinCount >= 1, "password.breached_min_count must be at least 1")
+
+	//? None would send the cookie on every cross-site request, csrf tokens would be the only defence left
+	check(oneOf(c.Session.CookieSameSite, "lax", "strict"), "session.cookie_samesite must be lax or strict, got %q", c.Session.CookieSameSite)
+
+	switch c.Mail.Driver {
+	case "log", "file":
+	case "smtp":
+		check(c.Mail.SMTPHost != "" && c.Mail.From != "", "mail.driver smtp needs mail.smtp_host and mail.from")
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort <= 65535, "mail.smtp_port must be between 1 and 65535, got %d", c.Mail.SMTPPort)
+	default:
+		check(false, "mail.driver must be log, file or smtp, got %q", c.Mail.Driver)
+	}
+
+	check(c.Links.EmailConfirmURL != "", "links.email_confirm_url is required")
+	check(c.Links.MagicLinkURL != "", "links.magic_link_url is required")
+
+	//? a half configured provider is a deployment mistake --> fail loudly at startup
+	for _, provider := range c.OIDC {
+		check(provider.Name != "" && provider.Issuer != "" && provider.ClientID != "" && provider.RedirectURL != "", "oidc provider %q needs name, issuer, client_id and redirect_url", provider.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//! ConfigFileEnv --> config file when -config is not given
+const ConfigFileEnv = "CONFIG_FILE"
+
+//! LookupEnv --> os.LookupEnv in main, a map in tests
+type LookupEnv func(key string) (string, bool)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+//! Load --> defaults, then the config file, then environment variables, then flags, then Validate
+//! args are the command line arguments without the program name
+func Load(args []string, lookupEnv LookupEnv) (*Config, error) {
+	cfg := Default()
+
+	//* flags are parsed first but applied last --> they win over everything
+	flags := flag.NewFlagSet("fem", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML or TOML config file (default $"+ConfigFileEnv+")")
+	setFlags := map[string]string{}
+	registerFlags(flags, reflect.ValueOf(cfg).Elem(), setFlags)
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv(ConfigFileEnv)
+	}
+	if path != "" {
+		err = loadFile(cfg, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = applyEnv(reflect.ValueOf(cfg).Elem(), lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = applyOIDCEnv(cfg, lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyFlags(reflect.ValueOf(cfg).Elem(), setFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.normalize()
+	err = cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+//! normalize --> enum-like settings are case-insensitive ("LAX", "Info", "OTLP")
+func (c *Config) normalize() {
+	for _, value := range []*string{&c.Log.Level, &c.Tracing.Exporter, &c.Session.CookieSameSite, &c.Mail.Driver, &c.Database.SSLMode, &c.Database.Driver} {
+		*value = strings.ToLower(strings.TrimSpace(*value))
+	}
+}
+
+//! loadFile --> .yaml/.yml or .toml, unknown keys are errors (a typo would silently keep the default otherwise)
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file : %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s : %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("config file %s : %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config file %s : unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config file %s : expected a .yaml, .yml or .toml extension", path)
+	}
+	return nil
+}
+
+//! applyEnv --> every field with an env tag, secret fields also from <ENV>_FILE
+func applyEnv(v reflect.Value, lookupEnv LookupEnv) error {
+	return eachField(v, func(field reflect.Value, tag reflect.StructTag) error {
+		key := tag.Get("env")
+		if key == "" {
+			return nil
+		}
+		raw, ok := lookupEnv(key)
+		if tag.Get("secret") == "true" {
+			fromFile, fileOK, err := readSecretFile(key, lookupEnv)
+			if err != nil {
+				return err
+			}
+			if fileOK {
+				if ok {
+					return fmt.Errorf("set either %s or %s_FILE, not both", key, key)
+				}
+				raw, ok = fromFile, true
+			}
+		}
+		//? empty counts as unset, like the getenv fallbacks this replaced (docker-compose "KEY=" lines)
+		if !ok || raw == "" {
+			return nil
+		}
+		err := setField(field, raw)
+		if err != nil {
+			return fmt.Errorf("%s : %w", key, err)
+		}
+		return nil
+	})
+}
+
+//! readSecretFile --> content of the file named by <key>_FILE, without the trailing newline editors add
+func readSecretFile(key string, lookupEnv LookupEnv) (string, bool, error) {
+	path, ok := lookupEnv(key + "_FILE")
+	if !ok || path == "" {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE : %w", key, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+//! applyOIDCEnv --> OIDC_PROVIDERS=google,keycloak plus OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET(_FILE), _REDIRECT_URL
+//? the environment replaces providers from the file with the same name, others stay
+func applyOIDCEnv(cfg *Config, lookupEnv LookupEnv) error {
+	names, _ := lookupEnv("OIDC_PROVIDERS")
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		get := func(key string) string {
+			value, _ := lookupEnv(prefix + key)
+			return value
+		}
+		provider := OIDCProvider{
+			Name:         name,
+			Issuer:       get("ISSUER"),
+			ClientID:     get("CLIENT_ID"),
+			ClientSecret: get("CLIENT_SECRET"),
+			RedirectURL:  get("REDIRECT_URL"),
+		}
+		secret, ok, err := readSecretFile(prefix+"CLIENT_SECRET", lookupEnv)
+		if err != nil {
+			return err
+		}
+		if ok {
+			provider.ClientSecret = secret
+		}
+
+		replaced := false
+		for i := range cfg.OIDC {
+			if cfg.OIDC[i].Name == name {
+				cfg.OIDC[i] = provider
+				replaced = true
+			}
+		}
+		if !replaced {
+			cfg.OIDC = append(cfg.OIDC, provider)
+		}
+	}
+	return nil
+}
+
+//! registerFlags --> one string flag per flag tag, values are collected and applied after env
+func registerFlags(flags *flag.FlagSet, v reflect.Value, setFlags map[string]string) {
+	eachField(v, func(field reflect.Value, tag reflect.StructTag) error {
+		name := tag.Get("flag")
+		if name == "" {
+			return nil
+		}
+		usage := tag.Get("usage")
+		if key := tag.Get("env"); key != "" {
+			usage += " ($" + key + ")"
+		}
+		flags.Func(name, usage, func(raw string) error {
+			//? parsed right away too, so "-port abc" fails like any other flag error
+			err := setField(reflect.New(field.Type()).Elem(), raw)
+			if err != nil {
+				return err
+			}
+			setFlags[name] = raw
+			return nil
+		})
+		return nil
+	})
+}
+
+func applyFlags(v reflect.Value, setFlags map[string]string) error {
+	return eachField(v, func(field reflect.Value, tag reflect.StructTag) error {
+		raw, ok := setFlags[tag.Get("flag")]
+		if !ok {
+			return nil
+		}
+		return setField(field, raw)
+	})
+}
+
+//! eachField --> walks nested structs, slices (oidc providers) are left alone
+func eachField(v reflect.Value, fn func(field reflect.Value, tag reflect.StructTag) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		structField := v.Type().Field(i)
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			err := eachField(field, fn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := fn(field, structField.Tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//! setField --> parses raw into the field's type (string, bool, ints, uints, float, time.Duration)
+func setField(field reflect.Value, raw string) error {
+	//? strings are kept as they are, a password may well start with a space
+	if field.Kind() != reflect.String {
+		raw = strings.TrimSpace(raw)
+	}
+	if field.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("expected a duration like 5s or 24h, got %q", raw)
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", raw)
+		}
+		field.SetBool(value)
+	case reflect.Int, reflect.Int64:
+		value, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a whole number, got %q", raw)
+		}
+		field.SetInt(value)
+	case reflect.Uint8, reflect.Uint32:
+		value, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number between 0 and %d, got %q", uint64(1)<<field.Type().Bits()-1, raw)
+		}
+		field.SetUint(value)
+	case reflect.Float64:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", raw)
+		}
+		field.SetFloat(value)
+	default:
+		return fmt.Errorf("unsupported config field type %s", field.Type())
+	}
+	return nil
+}
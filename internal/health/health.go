@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//! status values in the JSON bodies
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusStarting = "starting" //* process is up, dependencies not wired yet --> no traffic
+	StatusStopping = "stopping" //* shutdown began, in-flight requests drain --> no new traffic
+)
+
+//! lifecycle of the process as readiness sees it
+const (
+	stateStarting int32 = iota
+	stateReady
+	stateStopping
+)
+
+//! CheckFunc --> one dependency check, extra fields (e.g. the migration version) go into the report
+//? ctx carries the checker timeout, a check that ignores it can still hold up /readyz
+type CheckFunc func(ctx context.Context) (map[string]any, error)
+
+type namedCheck struct {
+	name  string
+	check CheckFunc
+}
+
+//! Checker --> backs /healthz (liveness) and /readyz (readiness)
+//! Liveness never touches dependencies: a slow database must not get every pod restarted
+type Checker struct {
+	timeout time.Duration
+	checks  []namedCheck
+	state   atomic.Int32
+	started time.Time
+	logger  *slog.Logger //* failing checks are logged with their error, /readyz only says "failing"
+}
+
+//! New --> checker in the starting state, call SetReady once the server accepts requests
+func New(timeout time.Duration, logger *slog.Logger) *Checker {
+	return &Checker{timeout: timeout, started: time.Now(), logger: logger}
+}
+
+//! Add --> registers a readiness check, not safe once requests are served
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+//! SetReady --> startup finished, /readyz answers from the checks from now on
+func (c *Checker) SetReady() {
+	c.state.CompareAndSwap(stateStarting, stateReady)
+}
+
+//! SetStopping --> shutdown began, /readyz fails for good so load balancers stop sending traffic
+func (c *Checker) SetStopping() {
+	c.state.Store(stateStopping)
+}
+
+//! HandleLive --> GET /healthz, 200 as long as the process can answer at all
+func (c *Checker) HandleLive(w http.ResponseWriter, req *http.Request) {
+	utils.WriteJson(w, http.StatusOK, utils.Envelope{
+		"status": StatusOK,
+		"uptime": time.Since(c.started).Round(time.Second).String(),
+	})
+}
+
+//! HandleReady --> GET /readyz, runs every check with the timeout
+//! 200 only when started, not stopping and every check passed, 503 otherwise
+func (c *Checker) HandleReady(w http.ResponseWriter, req *http.Request) {
+	results, healthy := c.Run(req.Context())
+
+	status := StatusOK
+	switch c.state.Load() {
+	case stateStarting:
+		status = StatusStarting
+	case stateStopping:
+		status = StatusStopping
+	default:
+		if !healthy {
+			status = StatusFailing
+		}
+	}
+
+	code := http.StatusOK
+	if status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	//? never cached --> a stale 200 from a proxy would hide an outage
+	w.Header().Set("Cache-Control", "no-store")
+	utils.WriteJson(w, code, utils.Envelope{"status": status, "checks": results})
+}
+
+//! Run --> every check concurrently, each one reported with status, latency and its own fields
+//? /readyz is unauthenticated --> errors (hosts, ports, driver messages) only go to the log
+func (c *Checker) Run(ctx context.Context) (map[string]map[string]any, bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make(map[string]map[string]any, len(c.checks))
+	healthy := true
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			fields, err := nc.check(ctx)
+			latency := time.Since(start)
+
+			result := map[string]any{}
+			for key, value := range fields {
+				result[key] = value
+			}
+			result["status"] = StatusOK
+			result["latency_ms"] = float64(latency.Microseconds()) / 1000
+			if err != nil {
+				result["status"] = StatusFailing
+				c.logger.ErrorContext(ctx, "readiness check failed", "check", nc.name, "error", err, "latency_ms", result["latency_ms"])
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[nc.name] = result
+			if err != nil {
+				healthy = false
+			}
+		}()
+	}
+	wg.Wait()
+	return results, healthy
+}
@@ -0,0 +1,84 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type readyBody struct {
+	Status string                    `json:"status"`
+	Checks map[string]map[string]any `json:"checks"`
+}
+
+func getReady(t *testing.T, c *Checker) (int, readyBody) {
+	rr := httptest.NewRecorder()
+	c.HandleReady(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+	var body readyBody
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	return rr.Code, body
+}
+
+// ! TestReadyLifecycle --> 503 while starting, 200 once ready, 503 for good after SetStopping
+func TestReadyLifecycle(t *testing.T) {
+	c := New(time.Second, slog.New(slog.DiscardHandler))
+	c.Add("migrations", func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{"version": 19}, nil
+	})
+
+	code, body := getReady(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStarting, body.Status)
+
+	c.SetReady()
+	code, body = getReady(t, c)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, body.Status)
+	assert.Equal(t, StatusOK, body.Checks["migrations"]["status"])
+	assert.Equal(t, float64(19), body.Checks["migrations"]["version"])
+	assert.Contains(t, body.Checks["migrations"], "latency_ms")
+
+	c.SetStopping()
+	c.SetReady() // ? a late SetReady must not undo the shutdown
+	code, body = getReady(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStopping, body.Status)
+}
+
+// ! TestReadyFailingCheck --> a check that errors or outlives the timeout fails readiness, liveness stays green
+// ! the error is logged, the unauthenticated body only says "failing"
+func TestReadyFailingCheck(t *testing.T) {
+	var logs bytes.Buffer
+	c := New(20*time.Millisecond, slog.New(slog.NewJSONHandler(&logs, nil)))
+	c.Add("database", func(ctx context.Context) (map[string]any, error) {
+		<-ctx.Done() // * a ping against a database that never answers
+		return nil, ctx.Err()
+	})
+	c.Add("cache", func(ctx context.Context) (map[string]any, error) {
+		return nil, errors.New("connection refused")
+	})
+	c.SetReady()
+
+	code, body := getReady(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFailing, body.Status)
+	assert.Equal(t, StatusFailing, body.Checks["database"]["status"])
+	assert.Equal(t, StatusFailing, body.Checks["cache"]["status"])
+	assert.NotContains(t, body.Checks["cache"], "error")
+	assert.Contains(t, logs.String(), "context deadline exceeded")
+	assert.Contains(t, logs.String(), "connection refused")
+
+	rr := httptest.NewRecorder()
+	c.HandleLive(rr, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
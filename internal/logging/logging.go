@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+//! context keys --> unexported so only this package can set them
+type contextKey int
+
+const (
+	attrsKey contextKey = iota
+	requestIDKey
+)
+
+//! New --> JSON logger, every line also carries the request attributes stored in its context
+//! Use the *Context methods (ErrorContext, InfoContext ...) inside handlers so lines get the request id
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(&contextHandler{Handler: slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+//! ParseLevel --> LOG_LEVEL values: debug, info, warn, error (empty --> info)
+func ParseLevel(raw string) (slog.Level, error) {
+	var level slog.Level
+	if raw == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(strings.TrimSpace(raw)))
+	if err != nil {
+		return level, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", raw)
+	}
+	return level, nil
+}
+
+//! WithAttrs --> adds attributes to every line logged with the returned context
+//? copies the slice, a derived context never changes what its parent logs
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(attrsKey).([]slog.Attr)
+	combined := make([]slog.Attr, 0, len(existing)+len(attrs))
+	combined = append(combined, existing...)
+	combined = append(combined, attrs...)
+	return context.WithValue(ctx, attrsKey, combined)
+}
+
+//! WithRequestID --> stores the request id and logs it on every line of the request
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, requestID)
+	return WithAttrs(ctx, slog.String("request_id", requestID))
+}
+
+//! RequestID --> id set by WithRequestID, empty outside of a request
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+//! contextHandler --> slog.Handler that appends the context's request attributes to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+//* WithAttrs/WithGroup must keep the wrapper, otherwise logger.With(...) would drop the request attributes
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,129 @@
+//! Package mailer --> outgoing email behind one small interface
+//! Handlers only build a Message, app.go decides where it really goes (log, files or smtp)
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//! ErrInvalidHeader --> line breaks in To/Subject would let callers inject extra headers
+var ErrInvalidHeader = errors.New("mailer: header contains a line break")
+
+//! Message --> plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+//! Mailer interface --> contract for every delivery sink
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+//! LogMailer --> prints messages to the app log, the default for local development
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+//! NewLogMailer --> constructor for the log sink
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	m.logger.InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+//! FileMailer --> writes every message as an .eml file, handy to open links from a dev mailbox folder
+type FileMailer struct {
+	dir string
+	now func() time.Time
+}
+
+//! NewFileMailer --> constructor for the file sink, creates the directory if needed
+func NewFileMailer(dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, now: time.Now}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	now := m.now()
+	//* timestamp first so the files sort in the order they were sent
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+	//? 0600 --> messages carry login links, other local users shouldn't read them
+	return os.WriteFile(filepath.Join(m.dir, name), msg.bytes("", now), 0o600)
+}
+
+//! SMTPMailer --> real delivery through an smtp relay (STARTTLS when the server offers it)
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+//! NewSMTPMailer --> constructor for the smtp sink, username "" disables authentication
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, msg.bytes(m.from, time.Now()))
+}
+
+func (msg *Message) validate() error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+	return nil
+}
+
+//* bytes --> RFC 5322 message with CRLF line endings
+func (msg *Message) bytes(from string, date time.Time) []byte {
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + from + "\r\n")
+	}
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestFileMailer --> one private .eml file per message
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir)
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), &Message{To: "ayush@example.com", Subject: "Your login link", Body: "Open this:\nhttps://example.com/login"})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	raw, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	content := string(raw)
+	assert.True(t, strings.HasPrefix(content, "To: ayush@example.com\r\nSubject: Your login link\r\n"))
+	assert.Contains(t, content, "\r\n\r\nOpen this:\r\nhttps://example.com/login")
+}
+
+// ! TestHeaderInjection --> every sink refuses line breaks in headers
+func TestHeaderInjection(t *testing.T) {
+	m, err := NewFileMailer(t.TempDir())
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), &Message{To: "a@example.com\r\nBcc: everyone@example.com", Subject: "hi"})
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+	err = m.Send(context.Background(), &Message{To: "a@example.com", Subject: "hi\nBcc: everyone@example.com"})
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+}
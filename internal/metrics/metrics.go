@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//! namespace --> prefix of every metric name ("fem_http_requests_total")
+const namespace = "fem"
+
+//! login attempt labels --> step is the login stage, result how it ended
+const (
+	StepPassword = "password"
+	StepMFA      = "mfa"
+
+	ResultSuccess   = "success"
+	ResultFailure   = "failure"
+	ResultThrottled = "throttled"
+)
+
+//! Metrics --> every collector the app exposes on /metrics
+//! Each instance has its own registry, tests create one per test and read values with testutil
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	HTTPRequests        *prometheus.CounterVec   //* method, route, status
+	HTTPRequestDuration *prometheus.HistogramVec //* method, route, status
+	LoginAttempts       *prometheus.CounterVec   //* step, result
+	WorkoutsCreated     prometheus.Counter
+	EntriesLogged       prometheus.Counter
+}
+
+//! New --> registers the app metrics plus the standard go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, chi route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		HTTPRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, chi route pattern and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		LoginAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_attempts_total",
+			Help:      "Login attempts on /tokens/authentication (step password) and /tokens/mfa (step mfa) by result.",
+		}, []string{"step", "result"}),
+		WorkoutsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "workouts_created_total",
+			Help:      "Workouts created, by users themselves or by their coaches.",
+		}),
+		EntriesLogged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "workout_entries_logged_total",
+			Help:      "Exercise entries saved with newly created workouts.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		m.HTTPRequests,
+		m.HTTPRequestDuration,
+		m.LoginAttempts,
+		m.WorkoutsCreated,
+		m.EntriesLogged,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+//! RegisterDB --> connection pool stats (open, in use, idle, wait count/duration) read from sql.DB.Stats on every scrape
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+//! Handler --> the /metrics endpoint in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+//! ObserveRequest --> called once per request by middleware.Metrics
+//? route is the chi pattern ("/workouts/{id}"), never the raw path --> label values stay bounded
+func (m *Metrics) ObserveRequest(method string, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	code := strconv.Itoa(status)
+	m.HTTPRequests.WithLabelValues(method, route, code).Inc()
+	m.HTTPRequestDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+//! LoginAttempt --> one login step finished with result (ResultSuccess, ResultFailure, ResultThrottled)
+func (m *Metrics) LoginAttempt(step string, result string) {
+	m.LoginAttempts.WithLabelValues(step, result).Inc()
+}
+
+//! WorkoutCreated --> a workout with its entries was saved
+func (m *Metrics) WorkoutCreated(entries int) {
+	m.WorkoutsCreated.Inc()
+	m.EntriesLogged.Add(float64(entries))
+}
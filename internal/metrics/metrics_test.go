@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestObserveRequest --> counters and histograms keyed by route pattern, unmatched routes share one label
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET", "/workouts/{id}", 200, 20*time.Millisecond)
+	m.ObserveRequest("GET", "/workouts/{id}", 200, 30*time.Millisecond)
+	m.ObserveRequest("GET", "", 404, time.Millisecond)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.HTTPRequests.WithLabelValues("GET", "/workouts/{id}", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.HTTPRequests.WithLabelValues("GET", "unmatched", "404")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.HTTPRequestDuration))
+}
+
+// ! TestHandlerExposesEverything --> one scrape contains app, business and runtime metrics
+func TestHandlerExposesEverything(t *testing.T) {
+	m := New()
+	m.LoginAttempt(StepPassword, ResultFailure)
+	m.WorkoutCreated(3)
+	m.ObserveRequest("POST", "/workouts", 201, time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`fem_login_attempts_total{result="failure",step="password"} 1`,
+		`fem_workouts_created_total 1`,
+		`fem_workout_entries_logged_total 3`,
+		`fem_http_requests_total{method="POST",route="/workouts",status="201"} 1`,
+		`fem_http_request_duration_seconds_bucket`,
+		`go_goroutines`,
+	} {
+		assert.True(t, strings.Contains(body, want), "missing %s", want)
+	}
+}
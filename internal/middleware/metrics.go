@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fem/internal/metrics"
+	"fem/internal/utils"
+	"net/http"
+	"time"
+)
+
+//! Metrics --> request count and latency per chi route pattern and status
+func Metrics(m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+			m.ObserveRequest(r.Method, routePattern(r), recorder.Status(), time.Since(start))
+		})
+	}
+}
+
+//! MetricsToken --> /metrics only for scrapers sending "Authorization: Bearer <token>", an empty token lets everyone in
+//? constant time compare --> response timing doesn't reveal how much of a guess was right
+func MetricsToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		want := []byte("Bearer " + token)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				utils.Unauthorized(w, r, "a valid metrics token is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
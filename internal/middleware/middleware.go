@@ -3,6 +3,9 @@ package middleware
 // importing packages
 import (
 	"context"
+	"fem/internal/rbac"
+	"fem/internal/scopes"
+	"fem/internal/session"
 	"fem/internal/store"
 	"fem/internal/tokens"
 	"fem/internal/utils"
@@ -14,6 +17,8 @@ import (
 type contextKey string //* custom type for context keys to avoid collisions
 type UserMiddleware struct {
 	UserStore store.UserStore //* needed to fetch user from token
+	APIKeyStore store.APIKeyStore //* needed to resolve personal API keys
+	TokenStore store.TokenStore //* needed to resolve oauth access tokens and their scopes
 }
 
 
@@ -21,6 +26,9 @@ type UserMiddleware struct {
 //? using custom type prevents accidental key conflicts with other middleware
 const UserContextKey = contextKey("user") 
 
+//! ScopesContextKey --> permission scopes granted to the credential used for this request
+const ScopesContextKey = contextKey("scopes")
+
 
 
 //! SetUser --> injects user into request context for downstream handlers
@@ -28,6 +36,9 @@ const UserContextKey = contextKey("user")
 func SetUser(r *http.Request,user *store.User) *http.Request {
 	//* create new context with user value attached
 	contxt := context.WithValue(r.Context(),UserContextKey,user)
+	if !user.IsAnonymousUser() {
+		contxt = recordRequestUser(contxt,user.ID) //* access log + every later log line get the user id
+	}
 	return r.WithContext(contxt) //* return modified request
 }
 
@@ -46,15 +57,35 @@ func GetUser(r *http.Request) *store.User {
 }
 
 
+//! SetScopes --> records which scopes the presented credential carries
+//? login tokens never call this --> nil scopes mean full access
+func SetScopes(r *http.Request,granted []string) *http.Request {
+	contxt := context.WithValue(r.Context(),ScopesContextKey,granted)
+	return r.WithContext(contxt)
+}
+
+//! GetScopes --> nil for full-access login sessions, otherwise the granted scopes
+func GetScopes(r *http.Request) []string {
+	granted,_ := r.Context().Value(ScopesContextKey).([]string)
+	return granted
+}
+
+
 //! Authenticate --> middleware that validates Bearer token from Authorization header
 //! Sets user in context (either authenticated user or AnonymousUser)
 func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//* tell caches that response varies by Authorization header
+		//* tell caches that response varies by Authorization header (or session cookie)
 		w.Header().Add("Vary","Authorization")
+		w.Header().Add("Vary","Cookie")
 		//* extract Authorization header from request
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
+			//! browser clients in cookie mode --> same tokens, but delivered by the cookie jar
+			if cookie,err := r.Cookie(session.CookieName); err == nil && cookie.Value != "" {
+				um.authenticateCookie(w,r,cookie.Value,next)
+				return
+			}
 			//* no token provided, set as anonymous user
 			r = SetUser(r,store.AnonymousUser)
 			//* call next handler in chain
@@ -66,31 +97,98 @@ func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 		headerParts := strings.Split(authHeader," ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			//? header format wrong (should be: "Bearer <token>")
-			utils.WriteJson(w,http.StatusUnauthorized,utils.Envelope{"error":"invalid authorization header"})
+			utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"invalid authorization header"))
 			return 
 		}
 
 		//* extract token string (second part after "Bearer ")
 		token := headerParts[1]
+
+		//! personal API keys travel in the same header but carry a recognisable prefix
+		if strings.HasPrefix(token,tokens.APIKeyPrefix) {
+			user,apiKey,err := um.APIKeyStore.GetUserForAPIKey(r.Context(),token)
+			if err != nil {
+				utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"invalid token"))
+				return
+			}
+			if user == nil {
+				utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"api key has been expired or invalid"))
+				return
+			}
+			if user.IsSuspended() {
+				utils.WriteError(w,r,utils.NewError(http.StatusForbidden,utils.CodeAccountSuspended,"account has been suspended"))
+				return
+			}
+			//* user + the key's scopes --> RequireScope decides per route
+			r = SetScopes(SetUser(r,user),apiKey.Scopes)
+			next.ServeHTTP(w,r)
+			return
+		}
+
 		//* lookup user by token hash in database
-		user,err := um.UserStore.GetUserToken(tokens.ScopeAuth,token)
+		user,err := um.UserStore.GetUserToken(r.Context(),tokens.ScopeAuth,token)
 		if err != nil {
 			//? database error or token not found
-			utils.WriteJson(w,http.StatusUnauthorized,utils.Envelope{"error":"invalid token"})
+			utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"invalid token"))
 			return
 		}
+		var granted []string //* stays nil for login tokens --> full access
+		if user == nil {
+			//! not a login token --> maybe an access token a third-party app got through /oauth/token
+			var oauthToken *tokens.Token
+			user,oauthToken,err = um.TokenStore.GetTokenGrant(r.Context(),tokens.ScopeOAuthAccess,token)
+			if err != nil {
+				utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"invalid token"))
+				return
+			}
+			if oauthToken != nil {
+				//? non-nil even when empty, an oauth token must never count as a login session
+				granted = append([]string{},oauthToken.GrantedScopes...)
+			}
+		}
 		if user == nil {
 			//? token expired or doesn't exist
-			utils.WriteJson(w,http.StatusUnauthorized,utils.Envelope{"error":"token has been expired or invalid"})
+			utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"token has been expired or invalid"))
+			return
+		}
+		if user.IsSuspended() {
+			utils.WriteError(w,r,utils.NewError(http.StatusForbidden,utils.CodeAccountSuspended,"account has been suspended"))
 			return
 		}
 		//* valid token! attach authenticated user to request context
 		r = SetUser(r,user)
+		if granted != nil {
+			r = SetScopes(r,granted)
+		}
 		next.ServeHTTP(w,r) //* call next handler with authenticated user
 	})
 }
 
 
+//! authenticateCookie --> resolves a session cookie, unsafe methods also need the csrf header
+//? the header is the whole point: a forged cross-site form can send the cookie but can't read or set it
+func (um *UserMiddleware) authenticateCookie(w http.ResponseWriter,r *http.Request,token string,next http.Handler) {
+	user,err := um.UserStore.GetUserToken(r.Context(),tokens.ScopeAuth,token)
+	if err != nil {
+		utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"invalid session"))
+		return
+	}
+	if user == nil {
+		utils.WriteError(w,r,utils.NewError(http.StatusUnauthorized,utils.CodeInvalidToken,"session has been expired or invalid"))
+		return
+	}
+	if !session.SafeMethod(r.Method) && !session.ValidCSRF(r,token) {
+		utils.WriteError(w,r,utils.NewError(http.StatusForbidden,utils.CodeCSRFFailed,"missing or invalid " + session.CSRFHeader + " header"))
+		return
+	}
+	if user.IsSuspended() {
+		utils.WriteError(w,r,utils.NewError(http.StatusForbidden,utils.CodeAccountSuspended,"account has been suspended"))
+		return
+	}
+	//* cookie sessions are full login sessions --> no scopes, like Bearer login tokens
+	next.ServeHTTP(w,SetUser(r,user))
+}
+
 //! RequireUser --> ensures user is authenticated (not anonymous)
 //! Must be used after Authenticate middleware
 func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
@@ -100,10 +198,50 @@ func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 
 		if user.IsAnonymousUser() {
 			//? user didn't provide valid token
-			utils.WriteJson(w, http.StatusUnauthorized, utils.Envelope{"error": "you must be logged in to access this route"})
+			utils.Unauthorized(w, r, "you must be logged in to access this route")
 			return
 		}
 		//* user is authenticated, proceed to handler
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+//! RequireScope --> ensures the credential was granted the given permission scope
+//! Login sessions pass unconditionally; API keys and oauth tokens need the scope explicitly
+func (um *UserMiddleware) RequireScope(scope string,next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		granted := GetScopes(r)
+		if granted != nil && !scopes.Has(granted,scope) {
+			//? RFC 6750 style hint so clients know which scope is missing
+			w.Header().Set("WWW-Authenticate",`Bearer error="insufficient_scope", scope="`+scope+`"`)
+			utils.WriteError(w, r, utils.NewError(http.StatusForbidden, utils.CodeInsufficientScope, "this credential is missing the " + scope + " scope"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//! RequireLoginSession --> account management only works with a real login, never with a scoped credential
+//? otherwise a leaked API key or oauth token could mint more keys or turn off 2FA
+func (um *UserMiddleware) RequireLoginSession(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetScopes(r) != nil {
+			utils.WriteError(w, r, utils.NewError(http.StatusForbidden, utils.CodeLoginSessionRequired, "this route requires a login session"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//! RequirePermission --> ensures the user's role grants a permission (see internal/rbac)
+//! Compose inside RequireUser: RequireUser(RequirePermission(perm, handler))
+func (um *UserMiddleware) RequirePermission(permission string,next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+		if !rbac.Can(user.Role,permission) {
+			utils.Forbidden(w, r, "you are not allowed to access this route")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fem/internal/logging"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//! RequestIDHeader --> read from the client (or a proxy in front of us) and always echoed back
+const RequestIDHeader = "X-Request-ID"
+
+//* requestIDPattern --> ids from outside end up in logs, anything odd is replaced by our own
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+//! requestInfoKey --> access log bookkeeping that handlers deeper in the chain fill in
+const requestInfoKey = contextKey("request-info")
+
+//* requestInfo --> a pointer in the context so SetUser can report the user back to AccessLog
+type requestInfo struct {
+	userID *int
+}
+
+//! RequestID --> keeps a sane X-Request-ID or generates one, stores it for logs and error responses
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+//! AccessLog --> one line per request: method, route pattern, status, latency, bytes and user
+//! Mount after RequestID so the line carries the request id
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			info := &requestInfo{}
+			ctx := context.WithValue(r.Context(), requestInfoKey, info)
+			ctx = logging.WithAttrs(ctx, slog.String("method", r.Method), slog.String("path", r.URL.Path))
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			attrs := []slog.Attr{
+				slog.String("route", routePattern(r)),
+				slog.Int("status", recorder.Status()),
+				slog.Duration("latency", time.Since(start)),
+				slog.Int("bytes", recorder.bytes),
+				slog.String("remote_ip", utils.ClientIP(r)),
+			}
+			if info.userID != nil {
+				attrs = append(attrs, slog.Int("user_id", *info.userID))
+			}
+
+			level := slog.LevelInfo
+			if recorder.Status() >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			//? ctx, not r.Context() --> method, path and request id come from the context handler
+			logger.LogAttrs(ctx, level, "request", attrs...)
+		})
+	}
+}
+
+//! routePattern --> "/workouts/{id}" instead of "/workouts/42", empty when no route matched
+//? read after the handler ran, chi fills in the pattern while routing
+func routePattern(r *http.Request) string {
+	routeContext := chi.RouteContext(r.Context())
+	if routeContext == nil {
+		return ""
+	}
+	return routeContext.RoutePattern()
+}
+
+//! recordRequestUser --> called by SetUser, puts the user on the access log line and later log lines
+func recordRequestUser(ctx context.Context, userID int) context.Context {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		info.userID = &userID
+	}
+	return logging.WithAttrs(ctx, slog.Int("user_id", userID))
+}
+
+func newRequestID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf) //* never fails, see crypto/rand docs
+	return hex.EncodeToString(buf)
+}
+
+//! statusRecorder --> remembers status and body size for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK //* implicit 200 on first write
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+//* Status --> 200 when the handler never wrote anything
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+//* Unwrap --> lets http.ResponseController reach Flush/Hijack on the real writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fem/internal/logging"
+	"fem/internal/store"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestAccessLog --> access line and handler lines share the request id, the user shows up on both
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, slog.LevelInfo)
+
+	r := chi.NewRouter()
+	r.Use(RequestID)
+	r.Use(AccessLog(logger))
+	r.Get("/workouts/{id}", func(w http.ResponseWriter, req *http.Request) {
+		// * what Authenticate does for a valid token
+		req = SetUser(req, &store.User{ID: 7, Username: "ayush"})
+		logger.ErrorContext(req.Context(), "GetWorkoutByID", "error", "boom")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+	})
+
+	req := httptest.NewRequest("GET", "/workouts/42", nil)
+	req.Header.Set(RequestIDHeader, "client-supplied-1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, "client-supplied-1", rr.Header().Get(RequestIDHeader))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var handlerLine, accessLine map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &handlerLine))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &accessLine))
+
+	assert.Equal(t, "GetWorkoutByID", handlerLine["msg"])
+	assert.Equal(t, "client-supplied-1", handlerLine["request_id"])
+	assert.Equal(t, float64(7), handlerLine["user_id"])
+	assert.Equal(t, "/workouts/42", handlerLine["path"])
+
+	assert.Equal(t, "request", accessLine["msg"])
+	assert.Equal(t, "ERROR", accessLine["level"]) // ? 5xx answers are logged as errors
+	assert.Equal(t, "client-supplied-1", accessLine["request_id"])
+	assert.Equal(t, "GET", accessLine["method"])
+	assert.Equal(t, "/workouts/{id}", accessLine["route"])
+	assert.Equal(t, float64(500), accessLine["status"])
+	assert.Equal(t, float64(4), accessLine["bytes"])
+	assert.Equal(t, float64(7), accessLine["user_id"])
+	assert.Contains(t, accessLine, "latency")
+}
+
+// ! TestRequestIDReplacesOddValues --> ids end up in logs, so only sane ones are kept
+func TestRequestIDReplacesOddValues(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = logging.RequestID(req.Context())
+	}))
+
+	for _, incoming := range []string{"", "has spaces", strings.Repeat("a", 129), "line\nbreak"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.NotEqual(t, incoming, seen)
+		assert.Len(t, seen, 24)
+		assert.Equal(t, seen, rr.Header().Get(RequestIDHeader))
+	}
+}
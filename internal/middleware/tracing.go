@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"fem/internal/logging"
+	"fem/internal/tracing"
+	"fem/internal/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//! tracerName --> instrumentation scope of the request spans
+const tracerName = "fem/internal/middleware"
+
+//! Tracing --> one server span per request, child of the caller's span when a traceparent header came in
+//! Store queries started with req.Context() become children of this span
+//? the span starts as "GET" and is renamed to "GET /workouts/{id}" once chi has matched the route
+func Tracing(provider trace.TracerProvider) func(http.Handler) http.Handler {
+	tracer := provider.Tracer(tracerName)
+	propagator := tracing.Propagator()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", r.URL.Path),
+					attribute.String("client.address", utils.ClientIP(r)),
+				),
+			)
+			defer span.End()
+
+			//* trace id on every log line of this request --> jump from a log to its trace
+			if spanContext := span.SpanContext(); spanContext.IsValid() {
+				ctx = logging.WithAttrs(ctx, slog.String("trace_id", spanContext.TraceID().String()))
+			}
+
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			status := recorder.Status()
+			if route := routePattern(r); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(attribute.String("http.route", route))
+			}
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			//? only 5xx are failures of the server, a 404 is a correct answer
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, strconv.Itoa(status))
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"html/template"
+	"io"
+)
+
+//! ConsentPage --> everything the consent form needs to render and post back
+//! The authorize parameters travel as hidden fields so POST /oauth/authorize can re-validate them
+type ConsentPage struct {
+	ClientName          string
+	ClientID            string
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Error               string //* shown above the form after a failed login attempt
+}
+
+//* html/template escapes every value --> client names can't inject markup
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Authorize {{.ClientName}} - FitTrack</title>
+</head>
+<body>
+  <h1>{{.ClientName}} wants to access your FitTrack account</h1>
+  <p>It will be allowed to:</p>
+  <ul>
+    {{range .Scopes}}<li>{{.}}</li>
+    {{end}}
+  </ul>
+  {{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+  <form method="post" action="/oauth/authorize">
+    <input type="hidden" name="response_type" value="code">
+    <input type="hidden" name="client_id" value="{{.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{range $i, $s := .Scopes}}{{if $i}} {{end}}{{$s}}{{end}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+    <input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+    <label>Username <input name="username" autocomplete="username" required></label>
+    <label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+    <label>Authenticator code (if enabled) <input name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+    <button type="submit" name="action" value="approve">Allow</button>
+    <button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+//! RenderConsent --> writes the consent page as html
+func RenderConsent(w io.Writer, page ConsentPage) error {
+	return consentTemplate.Execute(w, page)
+}
@@ -0,0 +1,187 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//! ErrProviderUnavailable --> discovery, jwks or token endpoint could not be reached / parsed
+var ErrProviderUnavailable = errors.New("oidc: identity provider unavailable")
+
+//! Config --> one external identity provider as registered with us
+type Config struct {
+	Name         string //* url-safe name used in /auth/oidc/{provider}/...
+	Issuer       string //* must equal the "iss" of discovery and every id token
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   //* our callback, registered at the provider
+	Scopes       []string //* "openid" is always added
+}
+
+//! Metadata --> the parts of /.well-known/openid-configuration we rely on
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//! Provider --> relying party for one issuer; discovery and keys are fetched lazily and cached
+type Provider struct {
+	Config
+	HTTPClient *http.Client
+	now        func() time.Time //* swappable clock --> tests pass a fixed time
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *keySet
+}
+
+//! NewProvider --> nothing is fetched yet, an unreachable provider must not stop the app from starting
+func NewProvider(config Config) *Provider {
+	return &Provider{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+	}
+}
+
+//! Discover --> fetches (once) and validates the provider metadata
+func (p *Provider) Discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	metadata := &Metadata{}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", metadata)
+	if err != nil {
+		return nil, err
+	}
+	//? a mismatching issuer means we were redirected or misconfigured --> don't trust anything it says
+	if metadata.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: discovery issuer %q does not match %q", ErrProviderUnavailable, metadata.Issuer, p.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is incomplete", ErrProviderUnavailable)
+	}
+
+	p.metadata = metadata
+	p.keys = newKeySet(metadata.JWKSURI, p.getJSON, p.now)
+	return metadata, nil
+}
+
+//! AuthCodeURL --> where the browser is sent to log in at the provider (code flow + PKCE S256)
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.scopes(), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+//! Exchange --> redeems the code at the token endpoint and returns the verified id token claims
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: token response: %v", ErrProviderUnavailable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		//* rejected code (expired, reused, wrong verifier) --> the login failed, the provider is fine
+		return nil, fmt.Errorf("oidc: token endpoint rejected the code: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, body.IDToken, nonce)
+}
+
+func (p *Provider) scopes() []string {
+	scopes := []string{"openid"}
+	for _, scope := range p.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+//! getJSON --> GET with a size limit, shared by discovery and jwks
+func (p *Provider) getJSON(ctx context.Context, target string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s returned %d", ErrProviderUnavailable, target, resp.StatusCode)
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(into)
+	if err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrProviderUnavailable, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fem/internal/oauth"
+	"fem/internal/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func newProvider(idp *oidctest.Server) *Provider {
+	return NewProvider(Config{
+		Name:         "test",
+		Issuer:       idp.Issuer(),
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  "http://localhost:8080/auth/oidc/test/callback",
+		Scopes:       []string{"email", "profile"},
+	})
+}
+
+// ! TestCodeFlow --> authorize redirect, code exchange and id token verification against the stand-in provider
+func TestCodeFlow(t *testing.T) {
+	idp := oidctest.NewServer("fittrack", "s3cret")
+	defer idp.Close()
+	idp.SetIdentity(oidctest.Identity{Subject: "248289761001", Email: "ayush@example.com", EmailVerified: true, PreferredUsername: "ayush"})
+
+	provider := newProvider(idp)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", oauth.S256Challenge(testVerifier))
+	require.NoError(t, err)
+
+	// * follow the authorize redirect by hand, it points at our (not running) callback
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", callback.Query().Get("state"))
+
+	claims, err := provider.Exchange(ctx, callback.Query().Get("code"), testVerifier, "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, "248289761001", claims.Subject)
+	assert.Equal(t, "ayush@example.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	// ? codes are single use at the provider
+	_, err = provider.Exchange(ctx, callback.Query().Get("code"), testVerifier, "nonce-1")
+	assert.Error(t, err)
+}
+
+// ! TestVerifyIDToken --> every claim check and the signature check reject bad tokens
+func TestVerifyIDToken(t *testing.T) {
+	idp := oidctest.NewServer("fittrack", "s3cret")
+	defer idp.Close()
+	provider := newProvider(idp)
+	identity := oidctest.Identity{Subject: "sub-1"}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	test := []struct {
+		name   string
+		token  func() string
+		nonce  string
+		wantOK bool
+	}{
+		{name: "valid", wantOK: true, nonce: "n", token: func() string {
+			return idp.SignIDToken(idp.Claims(identity, "n"))
+		}},
+		{name: "wrong nonce", nonce: "other", token: func() string {
+			return idp.SignIDToken(idp.Claims(identity, "n"))
+		}},
+		{name: "wrong audience", nonce: "n", token: func() string {
+			claims := idp.Claims(identity, "n")
+			claims["aud"] = "someone-else"
+			return idp.SignIDToken(claims)
+		}},
+		{name: "audience list without azp", nonce: "n", token: func() string {
+			claims := idp.Claims(identity, "n")
+			claims["aud"] = []string{"fittrack", "someone-else"}
+			return idp.SignIDToken(claims)
+		}},
+		{name: "audience list with azp", nonce: "n", wantOK: true, token: func() string {
+			claims := idp.Claims(identity, "n")
+			claims["aud"] = []string{"fittrack", "someone-else"}
+			claims["azp"] = "fittrack"
+			return idp.SignIDToken(claims)
+		}},
+		{name: "wrong issuer", nonce: "n", token: func() string {
+			claims := idp.Claims(identity, "n")
+			claims["iss"] = "https://evil.example.com"
+			return idp.SignIDToken(claims)
+		}},
+		{name: "expired", nonce: "n", token: func() string {
+			claims := idp.Claims(identity, "n")
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return idp.SignIDToken(claims)
+		}},
+		{name: "signed by unknown key", nonce: "n", token: func() string {
+			return oidctest.SignRS256(otherKey, "key-1", idp.Claims(identity, "n"))
+		}},
+		{name: "alg none", nonce: "n", token: func() string {
+			return "eyJhbGciOiJub25lIn0.eyJzdWIiOiJzdWItMSJ9."
+		}},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := provider.VerifyIDToken(context.Background(), tt.token(), tt.nonce)
+			if !tt.wantOK {
+				assert.ErrorIs(t, err, ErrInvalidIDToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "sub-1", claims.Subject)
+		})
+	}
+}
+
+// ! TestKeyRotation --> a new kid refetches the jwks, but not more than once a minute
+func TestKeyRotation(t *testing.T) {
+	idp := oidctest.NewServer("fittrack", "s3cret")
+	defer idp.Close()
+	provider := newProvider(idp)
+	now := time.Now()
+	provider.now = func() time.Time { return now } // * fake clock
+	identity := oidctest.Identity{Subject: "sub-1"}
+
+	_, err := provider.VerifyIDToken(context.Background(), idp.SignIDToken(idp.Claims(identity, "n")), "n")
+	require.NoError(t, err)
+
+	idp.RotateKey()
+	_, err = provider.VerifyIDToken(context.Background(), idp.SignIDToken(idp.Claims(identity, "n")), "n")
+	assert.ErrorIs(t, err, ErrInvalidIDToken) // ? keys were fetched a moment ago
+
+	now = now.Add(2 * time.Minute)
+	_, err = provider.VerifyIDToken(context.Background(), idp.SignIDToken(idp.Claims(identity, "n")), "n")
+	assert.NoError(t, err)
+}
+
+// ! TestDiscoveryIssuerMismatch --> metadata for another issuer is refused
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := oidctest.NewServer("fittrack", "s3cret")
+	defer idp.Close()
+
+	provider := newProvider(idp)
+	provider.Issuer = idp.Issuer() + "/"
+	_, err := provider.Discover(context.Background())
+	assert.ErrorIs(t, err, ErrProviderUnavailable)
+}
@@ -0,0 +1,53 @@
+package passhash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//! DefaultBcryptCost --> what every hash used before Argon2id became the default
+const DefaultBcryptCost = 12
+
+//! Bcrypt --> legacy hasher, kept so existing users can still log in (and get rehashed)
+type Bcrypt struct {
+	cost int
+}
+
+//! NewBcrypt --> constructor for the bcrypt hasher
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Hash(plaintext string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(plaintext string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plaintext))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		//? anything else means the stored hash is broken, not that the password is wrong
+		return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+	}
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
+
+//* $2a$, $2b$ and $2y$ are all bcrypt
+func (b *Bcrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
@@ -0,0 +1,69 @@
+//! Package passhash --> password hashing behind one interface, so the algorithm can change without a migration
+//! Hashes are self describing (PHC / modular crypt strings), old ones keep working and get upgraded on login
+package passhash
+
+import (
+	"errors"
+	"fmt"
+)
+
+//! ErrMalformedHash --> the stored hash can't be parsed, the account needs a password reset
+var ErrMalformedHash = errors.New("passhash: malformed hash")
+
+//! ErrUnknownAlgorithm --> no configured hasher recognises the stored hash
+var ErrUnknownAlgorithm = errors.New("passhash: unknown hash algorithm")
+
+//! Hasher interface --> contract for one hashing algorithm
+type Hasher interface {
+	Hash(plaintext string) (string, error)
+	Verify(plaintext string, encoded string) (bool, error) //* false,nil on mismatch --> errors only for broken hashes
+	NeedsRehash(encoded string) bool                       //* hash made with weaker/different parameters than configured
+	Identifies(encoded string) bool                        //* cheap prefix check, no parsing
+}
+
+//! Manager --> hashes with the preferred algorithm, verifies with any known one
+type Manager struct {
+	preferred Hasher
+	legacy    []Hasher
+}
+
+//! NewManager --> preferred is used for new hashes, legacy hashers only for verifying old ones
+func NewManager(preferred Hasher, legacy ...Hasher) *Manager {
+	return &Manager{preferred: preferred, legacy: legacy}
+}
+
+//! Default --> Argon2id with DefaultArgon2idParams, still accepts bcrypt hashes from before the switch
+func Default() *Manager {
+	return NewManager(NewArgon2id(DefaultArgon2idParams), NewBcrypt(DefaultBcryptCost))
+}
+
+func (m *Manager) Hash(plaintext string) (string, error) {
+	return m.preferred.Hash(plaintext)
+}
+
+//! Verify --> match plus whether the hash should be replaced with a fresh one
+//? rehash is only reported for matches, we can't rehash without the right password
+func (m *Manager) Verify(plaintext string, encoded string) (match bool, rehash bool, err error) {
+	hasher, err := m.hasherFor(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	match, err = hasher.Verify(plaintext, encoded)
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, hasher != m.preferred || hasher.NeedsRehash(encoded), nil
+}
+
+func (m *Manager) hasherFor(encoded string) (Hasher, error) {
+	if m.preferred.Identifies(encoded) {
+		return m.preferred, nil
+	}
+	for _, hasher := range m.legacy {
+		if hasher.Identifies(encoded) {
+			return hasher, nil
+		}
+	}
+	return nil, fmt.Errorf("%w (%d byte hash)", ErrUnknownAlgorithm, len(encoded))
+}
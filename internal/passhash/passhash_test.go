@@ -0,0 +1,79 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// * cheap parameters --> the tests check behaviour, not cost
+var testParams = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// ! TestArgon2idRoundTrip --> PHC format, salted, verifies only the right password
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := NewArgon2id(testParams)
+
+	encoded, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$"))
+
+	again, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, encoded, again) // * random salt
+
+	match, err := hasher.Verify("correct horse", encoded)
+	require.NoError(t, err)
+	assert.True(t, match)
+
+	match, err = hasher.Verify("wrong horse", encoded)
+	require.NoError(t, err)
+	assert.False(t, match)
+}
+
+// ! TestManagerVerify --> legacy and outdated hashes ask for a rehash, broken ones return errors
+func TestManagerVerify(t *testing.T) {
+	manager := NewManager(NewArgon2id(testParams), NewBcrypt(4))
+
+	current, err := manager.Hash("pw")
+	require.NoError(t, err)
+	weaker, err := NewArgon2id(Argon2idParams{Memory: 32, Iterations: 1, Parallelism: 1}).Hash("pw")
+	require.NoError(t, err)
+	legacy, err := NewBcrypt(4).Hash("pw")
+	require.NoError(t, err)
+
+	test := []struct {
+		name       string
+		encoded    string
+		password   string
+		wantMatch  bool
+		wantRehash bool
+		wantErr    error
+	}{
+		{name: "current parameters", encoded: current, password: "pw", wantMatch: true},
+		{name: "wrong password", encoded: current, password: "nope"},
+		{name: "older argon2 parameters", encoded: weaker, password: "pw", wantMatch: true, wantRehash: true},
+		{name: "legacy bcrypt", encoded: legacy, password: "pw", wantMatch: true, wantRehash: true},
+		{name: "legacy bcrypt wrong password", encoded: legacy, password: "nope"},
+		{name: "truncated bcrypt", encoded: legacy[:20], password: "pw", wantErr: ErrMalformedHash},
+		{name: "argon2 missing parts", encoded: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", password: "pw", wantErr: ErrMalformedHash},
+		{name: "argon2 absurd memory", encoded: "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5", password: "pw", wantErr: ErrMalformedHash},
+		{name: "unknown algorithm", encoded: "$1$md5crypt$abc", password: "pw", wantErr: ErrUnknownAlgorithm},
+		{name: "empty hash", encoded: "", password: "pw", wantErr: ErrUnknownAlgorithm},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := manager.Verify(tt.password, tt.encoded)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.False(t, match)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMatch, match)
+			assert.Equal(t, tt.wantRehash, rehash)
+		})
+	}
+}
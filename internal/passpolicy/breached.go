@@ -0,0 +1,190 @@
+package passpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//! sortSamples --> lines spread over the file that must come in hash order, see checkSorted
+const sortSamples = 64
+
+//! ErrBreachedLookup --> the list couldn't be read while checking a password
+var ErrBreachedLookup = errors.New("breached passwords lookup failed")
+
+//! BreachedList --> SHA-1 hashes of leaked passwords, looked up on disk with a binary search
+//! Nothing is loaded into memory, a lookup reads a few dozen short lines from the (page cached) file
+//! Lookups never need the network, the dataset is downloaded ahead of time
+type BreachedList struct {
+	r        io.ReaderAt
+	size     int64
+	minCount int
+}
+
+//! OpenBreachedFile --> a Pwned Passwords dump ordered by hash, see NewBreachedList
+//? the file stays open for as long as the process runs
+func OpenBreachedFile(path string, minCount int) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	list, err := NewBreachedList(file, info.Size(), minCount)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return list, nil
+}
+
+//! NewBreachedList --> one "SHA1HASH:COUNT" (or bare "SHA1HASH") per line, sorted by hash like pwned-passwords-sha1-ordered-by-hash-*.txt
+//? hashes seen fewer than minCount times don't count as breached, the long tail is huge and rarely guessed
+//? the frequency ordered download is refused, binary search needs the hash order
+func NewBreachedList(r io.ReaderAt, size int64, minCount int) (*BreachedList, error) {
+	list := &BreachedList{r: r, size: size, minCount: minCount}
+	err := list.checkSorted()
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+//! Contains --> hashes the candidate and binary searches the file for it
+//? a read error is wrapped in ErrBreachedLookup, Policy.Check still applies every other rule
+func (l *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	//* lines starting before lo hash below target, lines starting at hi or later hash at or above it
+	lo, hi := int64(0), l.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, next, line, err := l.lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid //? no line starts in [mid, hi)
+			continue
+		}
+		hash, _, err := parseBreached(line, start)
+		if err != nil {
+			return false, err
+		}
+		if hash < target {
+			lo = next
+		} else {
+			hi = start
+		}
+	}
+
+	start, _, line, err := l.lineAt(lo)
+	if err != nil || start >= l.size {
+		return false, err
+	}
+	hash, count, err := parseBreached(line, start)
+	if err != nil {
+		return false, err
+	}
+	return hash == target && count >= l.minCount, nil
+}
+
+//! Size --> bytes of the dump, logged at startup
+func (l *BreachedList) Size() int64 {
+	return l.size
+}
+
+//! lineAt --> the first hash line starting at or after off, where it starts and where the line after it starts
+//? blank lines and # comments are skipped, start is l.size when there is no such line
+func (l *BreachedList) lineAt(off int64) (int64, int64, string, error) {
+	start := off
+	if off > 0 {
+		start = off - 1 //* a line starts at off only when the byte before it is a newline
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(l.r, start, l.size-start), 256)
+	if off > 0 {
+		skipped, err := reader.ReadString('\n')
+		start += int64(len(skipped))
+		if err == io.EOF {
+			return l.size, l.size, "", nil
+		}
+		if err != nil {
+			return 0, 0, "", fmt.Errorf("%w: %w", ErrBreachedLookup, err)
+		}
+	}
+
+	for start < l.size {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, 0, "", fmt.Errorf("%w: %w", ErrBreachedLookup, err)
+		}
+		next := start + int64(len(text))
+		line := strings.TrimSpace(text)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return start, next, line, nil
+		}
+		start = next
+	}
+	return l.size, l.size, "", nil
+}
+
+//! checkSorted --> a handful of lines spread over the file have to parse and come in ascending hash order
+//? catches the frequency ordered dump (hashes in random order) without reading gigabytes at startup
+func (l *BreachedList) checkSorted() error {
+	previous := ""
+	for i := int64(0); i < sortSamples; i++ {
+		start, _, line, err := l.lineAt(l.size * i / sortSamples)
+		if err != nil {
+			return err
+		}
+		if start >= l.size {
+			break
+		}
+		hash, _, err := parseBreached(line, start)
+		if err != nil {
+			return err
+		}
+		if hash < previous {
+			return fmt.Errorf("breached passwords at byte %d: not sorted by hash, use the ordered by hash download", start)
+		}
+		previous = hash
+	}
+	return nil
+}
+
+//! parseBreached --> upper case hash and its count, a bare hash counts as seen often enough
+func parseBreached(line string, offset int64) (string, int, error) {
+	hash, countText, hasCount := strings.Cut(line, ":")
+	hash = strings.ToUpper(hash)
+	if len(hash) != 2*sha1.Size || !isHex(hash) {
+		return "", 0, fmt.Errorf("%w: line at byte %d is not a sha1 hash", ErrBreachedLookup, offset)
+	}
+	if !hasCount {
+		return hash, math.MaxInt, nil
+	}
+	count, err := strconv.Atoi(countText)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: line at byte %d has a bad count %q", ErrBreachedLookup, offset, countText)
+	}
+	return hash, count, nil
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'A' && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+//! Package passpolicy --> decides whether a new password is good enough, before it ever gets hashed
+//! Length, an entropy estimate, no reuse of the username/email and an offline breached-password list
+package passpolicy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//! Violation --> every rule the password broke, worded for the person typing it
+type Violation struct {
+	Reasons []string
+}
+
+func (v *Violation) Error() string {
+	return strings.Join(v.Reasons, "; ")
+}
+
+//! Policy --> the rules, zero values disable a rule
+type Policy struct {
+	MinLength      int           //* in characters (runes), not bytes
+	MaxLength      int           //* keeps hashing cost bounded
+	MinEntropyBits float64       //* see EstimateEntropy
+	Breached       *BreachedList //* nil --> no breached check
+}
+
+//! DefaultPolicy --> NIST 800-63B flavoured: long enough, not obviously guessable, not leaked
+var DefaultPolicy = Policy{
+	MinLength:      10,
+	MaxLength:      128,
+	MinEntropyBits: 40,
+}
+
+//! Check --> nil when acceptable, otherwise a *Violation listing every problem
+//! userInputs are things an attacker knows about the account (username, email)
+//? an unreadable breached list comes back as ErrBreachedLookup (joined with the *Violation, if any)
+func (p *Policy) Check(password string, userInputs ...string) error {
+	reasons := []string{}
+	var lookupErr error
+	length := utf8.RuneCountInString(password)
+
+	if p.MinLength > 0 && length < p.MinLength {
+		reasons = append(reasons, "password must be at least "+characters(p.MinLength)+" long")
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		reasons = append(reasons, "password must be at most "+characters(p.MaxLength)+" long")
+	}
+	if reason := containsUserInput(password, userInputs); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	//? only worth estimating once the length is fine, "too short" already says it all
+	if p.MinEntropyBits > 0 && length >= p.MinLength && EstimateEntropy(password) < p.MinEntropyBits {
+		reasons = append(reasons, "password is too easy to guess, avoid repeated characters and sequences like abc or 123, or use a longer passphrase")
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		lookupErr = err
+		if breached {
+			reasons = append(reasons, "password has appeared in a data breach, choose a different one")
+		}
+	}
+
+	if len(reasons) == 0 {
+		return lookupErr
+	}
+	return errors.Join(&Violation{Reasons: reasons}, lookupErr)
+}
+
+//! containsUserInput --> "ayush2024!" for user ayush is guessed first by anyone targeting the account
+func containsUserInput(password string, userInputs []string) string {
+	lowered := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		candidates := []string{input}
+		//* emails --> the local part is as guessable as the whole address
+		if at := strings.LastIndex(input, "@"); at > 0 {
+			candidates = append(candidates, input[:at])
+		}
+		for _, candidate := range candidates {
+			//? very short names would match half of all passwords
+			if utf8.RuneCountInString(candidate) >= 3 && strings.Contains(lowered, candidate) {
+				return "password must not contain your username or email address"
+			}
+		}
+	}
+	return ""
+}
+
+//! EstimateEntropy --> rough bits of guessing work: log2(character pool) per "surprising" character
+//! Repeats ("aaaa") and runs ("abcd", "4321") add next to nothing, so they barely count
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	runes := []rune(password)
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	effective := 0.0
+	for i, r := range runes {
+		switch {
+		case i == 0:
+			effective++
+		case r == runes[i-1]:
+			effective += 0.1 //* repeat
+		case r == runes[i-1]+1 || r == runes[i-1]-1:
+			effective += 0.2 //* sequence step
+		default:
+			effective++
+		}
+	}
+	return effective * math.Log2(float64(pool))
+}
+
+//* characters --> "1 character" / "10 characters"
+func characters(n int) string {
+	if n == 1 {
+		return "1 character"
+	}
+	return fmt.Sprintf("%d characters", n)
+}
@@ -0,0 +1,138 @@
+package passpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// ! TestCheck --> every broken rule is reported, good passwords pass
+func TestCheck(t *testing.T) {
+	breached := breachedList(t, 2, sha1Hex("Summer2024!x")+":52", sha1Hex("rarely-used-pw-77")+":1")
+
+	policy := DefaultPolicy
+	policy.Breached = breached
+
+	test := []struct {
+		name        string
+		password    string
+		wantReasons []string // * substrings, one per expected reason
+	}{
+		{name: "passphrase", password: "correct horse battery staple"},
+		{name: "mixed", password: "Tr0ub4dor&3"},
+		{name: "below threshold in breach list", password: "rarely-used-pw-77"},
+		{name: "too short", password: "aB3$x", wantReasons: []string{"at least 10 characters"}},
+		{name: "too long", password: strings.Repeat("xY7!", 40), wantReasons: []string{"at most 128 characters"}},
+		{name: "repeated", password: "aaaaaaaaaaaaaaaa", wantReasons: []string{"too easy to guess"}},
+		{name: "sequence", password: "abcdefghijkl", wantReasons: []string{"too easy to guess"}},
+		{name: "username", password: "Ayush-rocks-2024", wantReasons: []string{"username or email"}},
+		{name: "email local part", password: "fit.runner!!42", wantReasons: []string{"username or email"}},
+		{name: "breached", password: "Summer2024!x", wantReasons: []string{"data breach"}},
+		{name: "several at once", password: "ayush", wantReasons: []string{"at least 10 characters", "username or email"}},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "ayush", "fit.runner@example.com")
+			if len(tt.wantReasons) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var violation *Violation
+			require.ErrorAs(t, err, &violation)
+			require.Len(t, violation.Reasons, len(tt.wantReasons))
+			for i, want := range tt.wantReasons {
+				assert.Contains(t, violation.Reasons[i], want)
+			}
+		})
+	}
+}
+
+// ! TestBreachedList --> bare hashes, counts, comments and lower case hashes, looked up in a sorted dump
+func TestBreachedList(t *testing.T) {
+	passwords := []string{"hunter2", "letmein", "123456", "password1", "qwerty", "dragon"}
+	lines := []string{"# dump"}
+	for i, password := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), i+1), "")
+	}
+	lines = append(lines, strings.ToLower(sha1Hex("trustno1"))) // * bare hash, always counts
+	sort.Slice(lines[1:], func(i, j int) bool { return strings.ToUpper(lines[1+i]) < strings.ToUpper(lines[1+j]) })
+
+	list := breachedList(t, 1, lines...)
+	for _, password := range append(passwords, "trustno1") {
+		assert.True(t, contains(t, list, password), password)
+	}
+	for _, password := range []string{"letmein2", "", "correct horse battery staple"} {
+		assert.False(t, contains(t, list, password), password)
+	}
+
+	// ? below minCount the hash is there but doesn't count
+	list = breachedList(t, 3, lines...)
+	assert.False(t, contains(t, list, "hunter2"))
+	assert.True(t, contains(t, list, "123456"))
+	assert.True(t, contains(t, list, "trustno1"))
+}
+
+// ! TestBreachedListRefused --> broken lines and the frequency ordered dump fail when opening
+func TestBreachedListRefused(t *testing.T) {
+	dump := "not-a-hash:1\n"
+	_, err := NewBreachedList(strings.NewReader(dump), int64(len(dump)), 1)
+	assert.ErrorContains(t, err, "byte 0")
+
+	hashes := []string{}
+	for i := 0; i < 200; i++ {
+		hashes = append(hashes, sha1Hex(fmt.Sprint(i))+":1")
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(hashes)))
+	dump = strings.Join(hashes, "\n")
+	_, err = NewBreachedList(strings.NewReader(dump), int64(len(dump)), 1)
+	assert.ErrorContains(t, err, "not sorted by hash")
+}
+
+// ! TestBreachedLookupError --> a failing read is reported, the other rules still run
+func TestBreachedLookupError(t *testing.T) {
+	policy := DefaultPolicy
+	policy.Breached = breachedList(t, 1, sha1Hex("hunter2")+":5")
+	policy.Breached.r = failingReader{}
+
+	err := policy.Check("short")
+	assert.ErrorIs(t, err, ErrBreachedLookup)
+	var violation *Violation
+	require.ErrorAs(t, err, &violation)
+	assert.Contains(t, violation.Reasons[0], "at least 10 characters")
+}
+
+// * breachedList --> the lines as an in-memory dump
+func breachedList(t *testing.T, minCount int, lines ...string) *BreachedList {
+	t.Helper()
+	dump := strings.Join(lines, "\r\n") + "\r\n"
+	list, err := NewBreachedList(strings.NewReader(dump), int64(len(dump)), minCount)
+	require.NoError(t, err)
+	return list
+}
+
+func contains(t *testing.T, list *BreachedList, password string) bool {
+	t.Helper()
+	breached, err := list.Contains(password)
+	require.NoError(t, err)
+	return breached
+}
+
+type failingReader struct{}
+
+func (failingReader) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("disk gone")
+}
@@ -0,0 +1,27 @@
+package rbac
+
+import "fem/internal/store"
+
+//! permissions --> what a role is allowed to do, routes check these instead of role names
+const (
+	PermManageUsers   = "users:manage"   //* list, suspend, delete users and change roles
+	PermCoachAthletes = "athletes:coach" //* invite athletes and program their workouts
+)
+
+//! rolePermissions --> single place that maps roles to permissions
+//? admins can do everything coaches can, so a gym owner can also coach
+var rolePermissions = map[string][]string{
+	store.RoleUser:  {},
+	store.RoleCoach: {PermCoachAthletes},
+	store.RoleAdmin: {PermManageUsers, PermCoachAthletes},
+}
+
+//! Can --> true if the role grants the permission
+func Can(role string, permission string) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
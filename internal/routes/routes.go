@@ -2,6 +2,11 @@ package routes
 
 import (
 	"fem/internal/app"
+	"fem/internal/middleware"
+	"fem/internal/rbac"
+	"fem/internal/scopes"
+	"fem/internal/utils"
+	"net/http"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -12,22 +17,120 @@ func SetupRoutes(app *app.Application) *chi.Mux {
 
 	//* create new chi router instance
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID) //* X-Request-ID from the client or a generated one, in logs and error responses
+	r.Use(middleware.Tracing(app.TracerProvider)) //* server span per request, continues the caller's trace from traceparent
+	r.Use(middleware.AccessLog(app.Logger)) //* one JSON line per request with route, status, latency and user
+	r.Use(middleware.Metrics(app.Metrics)) //* request count + latency histogram per route pattern and status
+
+	//* unknown routes and methods answer with problem+json like every handler
+	r.NotFound(func (w http.ResponseWriter, req *http.Request) {
+		utils.NotFound(w,req,"no route matches " + req.URL.Path)
+	})
+	r.MethodNotAllowed(func (w http.ResponseWriter, req *http.Request) {
+		utils.WriteError(w,req,utils.NewError(http.StatusMethodNotAllowed,utils.CodeMethodNotAllowed,req.Method + " is not supported on " + req.URL.Path))
+	})
 
 	//! Protected routes group --> requires valid authentication token
 	//! Middleware chain: Authenticate → RequireUser → Handler
 	r.Group(func (r chi.Router) {
 		r.Use(app.Middleware.Authenticate) //* extracts token from Authorization header and validates it
 		//* all routes in this group are protected by authentication
-		r.Get("/workouts/{id}",app.Middleware.RequireUser(app.WorkoutHandler.HandleWorkoutByID)) //* GET single workout
-		r.Post("/workouts",app.Middleware.RequireUser(app.WorkoutHandler.HandleCreateWorkout)) //* CREATE new workout
-		r.Put("/workouts/{id}",app.Middleware.RequireUser(app.WorkoutHandler.HandleUpdateWorkoutByID)) //* UPDATE existing workout
-		r.Delete("/workouts/{id}",app.Middleware.RequireUser(app.WorkoutHandler.HandleDeleteWorkoutByID)) //* DELETE workout
+		//* RequireScope --> API keys must carry the scope, login sessions always pass
+		r.Get("/workouts/{id}",app.Middleware.RequireUser(app.Middleware.RequireScope(scopes.WorkoutsRead,app.WorkoutHandler.HandleWorkoutByID))) //* GET single workout
+		r.Post("/workouts",app.Middleware.RequireUser(app.Middleware.RequireScope(scopes.WorkoutsWrite,app.WorkoutHandler.HandleCreateWorkout))) //* CREATE new workout
+		r.Put("/workouts/{id}",app.Middleware.RequireUser(app.Middleware.RequireScope(scopes.WorkoutsWrite,app.WorkoutHandler.HandleUpdateWorkoutByID))) //* UPDATE existing workout
+		r.Delete("/workouts/{id}",app.Middleware.RequireUser(app.Middleware.RequireScope(scopes.WorkoutsWrite,app.WorkoutHandler.HandleDeleteWorkoutByID))) //* DELETE workout
+		r.Post("/coach/athletes/{id}/workouts",app.Middleware.RequireUser(app.Middleware.RequirePermission(rbac.PermCoachAthletes,app.Middleware.RequireScope(scopes.WorkoutsWrite,app.WorkoutHandler.HandleCreateAthleteWorkout)))) //* coach creates workout for athlete
+
+		//! account management --> only with a login session, never with an API key
+		r.Group(func (r chi.Router) {
+			r.Delete("/tokens/authentication",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.TokenHandler.HandleDeleteToken))) //* logout, clears session cookies too
+
+			//* own profile
+			r.Get("/users/me",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.UserHandler.HandleGetMe))) //* current user
+			r.Patch("/users/me",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.UserHandler.HandleUpdateMe))) //* username, bio, email (confirmed by link)
+			r.Put("/users/me/password",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.UserHandler.HandleChangePassword))) //* change password, logs out other sessions
+			r.Delete("/users/me",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.UserHandler.HandleDeleteMe))) //* delete account and everything it owns
+
+			//* two-factor authentication (TOTP) enrollment
+			r.Post("/users/me/mfa/totp",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.MFAHandler.HandleEnrollTOTP))) //* start enrollment
+			r.Get("/users/me/mfa/totp/qr.png",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.MFAHandler.HandleTOTPQRCode))) //* QR code for pending enrollment
+			r.Post("/users/me/mfa/totp/confirm",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.MFAHandler.HandleConfirmTOTP))) //* confirm + get recovery codes
+			r.Delete("/users/me/mfa/totp",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.MFAHandler.HandleDisableTOTP))) //* disable 2FA
+			r.Post("/users/me/mfa/recovery-codes",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.MFAHandler.HandleRegenerateRecoveryCodes))) //* new recovery codes
+
+			//* personal API keys for scripts and integrations
+			r.Get("/users/me/api-keys",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.APIKeyHandler.HandleListAPIKeys))) //* list keys (no secrets)
+			r.Post("/users/me/api-keys",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.APIKeyHandler.HandleCreateAPIKey))) //* create key, returns plaintext once
+			r.Delete("/users/me/api-keys/{id}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.APIKeyHandler.HandleDeleteAPIKey))) //* revoke key
+
+			//* coach side of coach/athlete relationships
+			r.Post("/coach/invitations",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermCoachAthletes,app.CoachHandler.HandleInviteAthlete)))) //* invite athlete
+			r.Get("/coach/athletes",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermCoachAthletes,app.CoachHandler.HandleListAthletes)))) //* list athletes
+			r.Delete("/coach/athletes/{id}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermCoachAthletes,app.CoachHandler.HandleRemoveAthlete)))) //* stop coaching athlete
+
+			//* athlete side --> any user can be coached
+			r.Get("/users/me/invitations",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.CoachHandler.HandleListInvitations))) //* pending invitations
+			r.Post("/users/me/invitations/{id}/accept",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.CoachHandler.HandleAcceptInvitation))) //* grant access
+			r.Post("/users/me/invitations/{id}/decline",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.CoachHandler.HandleDeclineInvitation))) //* refuse
+			r.Get("/users/me/coaches",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.CoachHandler.HandleListCoaches))) //* who can see my workouts
+			r.Delete("/users/me/coaches/{id}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.CoachHandler.HandleRevokeCoach))) //* revoke coach access
+
+			//* external identities ("sign in with ...") linked to the account
+			r.Get("/users/me/identities",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.OIDCHandler.HandleListIdentities))) //* linked providers
+			r.Post("/users/me/identities/{provider}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.OIDCHandler.HandleStartLink))) //* start linking, returns provider url
+			r.Delete("/users/me/identities/{id}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.OIDCHandler.HandleUnlinkIdentity))) //* unlink provider
+
+			//* oauth2 clients registered by the current user (partner apps, kiosks)
+			r.Get("/oauth/clients",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.OAuthHandler.HandleListClients))) //* list clients (no secrets)
+			r.Post("/oauth/clients",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.OAuthHandler.HandleRegisterClient))) //* register client, returns secret once
+			r.Delete("/oauth/clients/{id}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.OAuthHandler.HandleDeleteClient))) //* delete client + its tokens
+
+			//! admin routes --> RequirePermission checks the role through internal/rbac
+			r.Get("/admin/users",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermManageUsers,app.AdminHandler.HandleListUsers)))) //* list users
+			r.Post("/admin/users/{id}/suspend",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermManageUsers,app.AdminHandler.HandleSuspendUser)))) //* suspend user
+			r.Post("/admin/users/{id}/unsuspend",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermManageUsers,app.AdminHandler.HandleUnsuspendUser)))) //* lift suspension
+			r.Put("/admin/users/{id}/role",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermManageUsers,app.AdminHandler.HandleSetUserRole)))) //* change role
+			r.Delete("/admin/users/{id}",app.Middleware.RequireUser(app.Middleware.RequireLoginSession(app.Middleware.RequirePermission(rbac.PermManageUsers,app.AdminHandler.HandleDeleteUser)))) //* delete user
+		})
 	})
 
 	//! Public routes --> no authentication required
-	r.Get("/health",app.HealthCheck) //* health check endpoint
+	r.Get("/healthz",app.Health.HandleLive) //* liveness --> the process answers, no dependencies checked
+	r.Get("/health",app.Health.HandleLive) //* old name of /healthz, kept for existing scripts
+	r.Get("/readyz",app.Health.HandleReady) //* readiness --> database ping + migration version, 503 while starting or stopping
+	//? fails closed --> the public port only serves /metrics behind METRICS_TOKEN, never open
+	if app.Config.Metrics.Addr == "" && app.Config.Metrics.Token != "" {
+		r.Handle("/metrics",metricsHandler(app)) //* prometheus scrape endpoint, METRICS_TOKEN guards it here
+	}
 	r.Post("/users",app.UserHandler.HandleRegisterUser) //* user registration
+	r.Post("/users/email/confirm",app.UserHandler.HandleConfirmEmail) //* confirm a new email address with the emailed token
 	r.Post("/tokens/authentication",app.TokenHandler.HandleCreateToken) //* login / get auth token
+	r.Post("/tokens/mfa",app.TokenHandler.HandleExchangeMFAToken) //* second login step for 2FA users
+	r.Post("/tokens/magic-link",app.MagicLinkHandler.HandleRequestMagicLink) //* email a one-time login link
+	r.Post("/tokens/magic-link/exchange",app.MagicLinkHandler.HandleExchangeMagicLink) //* trade the emailed token for an auth token
+
+	//! OpenID Connect login --> browser is sent to the provider and comes back to the callback
+	r.Get("/auth/oidc/{provider}/login",app.OIDCHandler.HandleLogin) //* redirect to provider
+	r.Get("/auth/oidc/{provider}/callback",app.OIDCHandler.HandleCallback) //* login / sign-up / finish linking
+
+	//! OAuth2 authorization code flow with PKCE --> the user logs in on the consent page itself
+	r.Get("/oauth/authorize",app.OAuthHandler.HandleAuthorize) //* consent page
+	r.Post("/oauth/authorize",app.OAuthHandler.HandleAuthorizeDecision) //* approve/deny, redirects back with code
+	r.Post("/oauth/token",app.OAuthHandler.HandleToken) //* code + code_verifier --> access token
+	r.Post("/oauth/revoke",app.OAuthHandler.HandleRevoke) //* revoke an access token
 	return r //* return configured router
 
-}
\ No newline at end of file
+}
+
+//! SetupMetricsRoutes --> /metrics alone, main serves it on metrics.addr (internal network, sidecar scraper)
+func SetupMetricsRoutes(app *app.Application) *chi.Mux {
+	r := chi.NewRouter()
+	r.Handle("/metrics",metricsHandler(app))
+	return r
+}
+
+//* metricsHandler --> the prometheus handler behind the optional bearer token
+func metricsHandler(app *app.Application) http.Handler {
+	return middleware.MetricsToken(app.Config.Metrics.Token)(app.Metrics.Handler())
+}
@@ -0,0 +1,64 @@
+package scopes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//! permission scopes --> what a delegated credential (API key) is allowed to do
+const (
+	WorkoutsRead  = "workouts:read"
+	WorkoutsWrite = "workouts:write"
+)
+
+//! All --> every scope a user can grant, in display order
+//? only scopes some route checks with RequireScope, a grantable scope that guards nothing would mislead users
+var All = []string{WorkoutsRead, WorkoutsWrite}
+
+//! Valid --> true for scopes listed in All
+func Valid(scope string) bool {
+	for _, s := range All {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//! Normalize --> validates, dedupes and sorts requested scopes
+func Normalize(requested []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		if !Valid(scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+//! Has --> checks a granted list for one scope
+func Has(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//! Join / Split --> scopes are stored space separated (same format OAuth2 uses on the wire)
+func Join(list []string) string {
+	return strings.Join(list, " ")
+}
+
+func Split(raw string) []string {
+	return strings.Fields(raw)
+}
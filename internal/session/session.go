@@ -0,0 +1,82 @@
+//! Package session --> cookie mode for browser clients, next to the usual Bearer tokens
+//! The cookie holds an ordinary authentication token from the tokens table, so expiry,
+//! suspension and revocation work exactly like for Bearer tokens
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fem/internal/tokens"
+	"net/http"
+	"time"
+)
+
+//! cookie and header names the browser UI relies on
+const (
+	CookieName     = "fittrack_session" //* HttpOnly, the token itself --> javascript never sees it
+	CSRFCookieName = "fittrack_csrf"    //* readable by javascript, echoed back in CSRFHeader
+	CSRFHeader     = "X-CSRF-Token"
+)
+
+//! Cookies --> attributes for the session cookies, shared by every login flow
+type Cookies struct {
+	Secure   bool          //* only false for plain http local development
+	SameSite http.SameSite //* Lax keeps top-level navigations logged in, Strict is stricter still
+	Domain   string        //* "" --> host-only cookie
+}
+
+//! DefaultCookies --> Secure + SameSite=Lax, host-only
+var DefaultCookies = Cookies{Secure: true, SameSite: http.SameSiteLaxMode}
+
+//! Requested --> login endpoints switch to cookie mode with ?session=cookie
+func Requested(req *http.Request) bool {
+	return req.URL.Query().Get("session") == "cookie"
+}
+
+//! Set --> session + csrf cookies for a freshly issued authentication token, returns the csrf token
+func (c Cookies) Set(w http.ResponseWriter, token *tokens.Token) string {
+	csrfToken := CSRFToken(token.Plaintext)
+	maxAge := int(time.Until(token.Expiry).Seconds())
+	http.SetCookie(w, c.cookie(CookieName, token.Plaintext, maxAge, true))
+	http.SetCookie(w, c.cookie(CSRFCookieName, csrfToken, maxAge, false))
+	return csrfToken
+}
+
+//! Clear --> logs the browser out (the token row must be deleted separately)
+func (c Cookies) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, c.cookie(CookieName, "", -1, true))
+	http.SetCookie(w, c.cookie(CSRFCookieName, "", -1, false))
+}
+
+func (c Cookies) cookie(name string, value string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   c.Secure,
+		SameSite: c.SameSite,
+	}
+}
+
+//! CSRFToken --> derived from the session token instead of stored
+//? a cookie planted by a sibling subdomain can't match, the attacker doesn't know the session token
+func CSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(sessionToken))
+	mac.Write([]byte("fittrack csrf"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//! ValidCSRF --> the request carries the csrf token that belongs to its session cookie
+func ValidCSRF(req *http.Request, sessionToken string) bool {
+	sent := req.Header.Get(CSRFHeader)
+	return sent != "" && hmac.Equal([]byte(sent), []byte(CSRFToken(sessionToken)))
+}
+
+//! SafeMethod --> methods that must not change state never need a csrf token
+func SafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fem/internal/scopes"
+	"fem/internal/tokens"
+	"time"
+)
+
+//! APIKey --> named, long-lived credential with a limited set of permission scopes
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` //* first characters of the key, safe to display
+	Scopes     []string   `json:"scopes"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Plaintext  string     `json:"key,omitempty"` //* only set right after creation
+	Hash       []byte     `json:"-"`
+}
+
+type PostgresAPIKeyStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresAPIKeyStore --> constructor that creates api key store instance
+func NewPostgresAPIKeyStore(db *sql.DB) *PostgresAPIKeyStore {
+	return &PostgresAPIKeyStore{db: db}
+}
+
+//! APIKeyStore interface --> contract for personal API keys
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	ListAPIKeys(ctx context.Context, userID int) ([]*APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID int, id int64) error                   //* sql.ErrNoRows if not the user's key
+	GetUserForAPIKey(ctx context.Context, plaintext string) (*User, *APIKey, error) //* nil,nil,nil for unknown or expired keys
+}
+
+func (s *PostgresAPIKeyStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expiry)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  RETURNING id, created_at
+  `
+	err := queryRowContext(ctx, s.db, query, key.UserID, key.Name, key.Prefix, key.Hash, scopes.Join(key.Scopes), key.Expiry).Scan(&key.ID, &key.CreatedAt)
+	return translateError(err)
+}
+
+func (s *PostgresAPIKeyStore) ListAPIKeys(ctx context.Context, userID int) ([]*APIKey, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  SELECT id, user_id, name, prefix, scopes, expiry, last_used_at, created_at
+  FROM api_keys
+  WHERE user_id = $1
+  ORDER BY created_at DESC
+  `
+	rows, err := queryContext(ctx, s.db, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := &APIKey{}
+		var rawScopes string
+		err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &rawScopes, &key.Expiry, &key.LastUsedAt, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		key.Scopes = scopes.Split(rawScopes)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresAPIKeyStore) DeleteAPIKey(ctx context.Context, userID int, id int64) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	//* user_id in the WHERE clause --> users can only revoke their own keys
+	result, err := execContext(ctx, s.db, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//! GetUserForAPIKey --> resolves a presented key and records when it was last used
+//? single statement: the UPDATE only touches valid keys, so expired keys never count as "used"
+func (s *PostgresAPIKeyStore) GetUserForAPIKey(ctx context.Context, plaintext string) (*User, *APIKey, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  WITH key AS (
+    UPDATE api_keys
+    SET last_used_at = CURRENT_TIMESTAMP
+    WHERE hash = $1 AND expiry > $2
+    RETURNING id, user_id, name, prefix, scopes, expiry, last_used_at, created_at
+  )
+  SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.expiry, k.last_used_at, k.created_at,
+         u.id, u.username, u.email, u.password_hash, u.bio, u.role, u.suspended_at, u.created_at, u.updated_at
+  FROM key k
+  INNER JOIN users u ON u.id = k.user_id
+  `
+	key := &APIKey{}
+	user := &User{PasswordHash: password{}}
+	var rawScopes string
+
+	err := queryRowContext(ctx, s.db, query, tokens.Hash(plaintext), time.Now()).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, &rawScopes, &key.Expiry, &key.LastUsedAt, &key.CreatedAt,
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.Role, &user.SuspendedAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key.Scopes = scopes.Split(rawScopes)
+	return user, key, nil
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//! audit event names --> stable strings so the table can be queried/alerted on
+const (
+	AuditLoginSucceeded       = "login.succeeded"
+	AuditLoginFailed          = "login.failed"
+	AuditLoginThrottled       = "login.throttled"
+	AuditLoginLockedOut       = "login.locked_out"
+	AuditMFAFailed            = "mfa.failed"
+	AuditMFAEnabled           = "mfa.enabled"
+	AuditMFADisabled          = "mfa.disabled"
+	AuditRecoveryCodesRenewed = "mfa.recovery_codes_regenerated"
+	AuditMagicLinkSent        = "magic_link.sent"
+	AuditOIDCSignup           = "oidc.user_created"
+	AuditOIDCLinked           = "oidc.identity_linked"
+	AuditOIDCUnlinked         = "oidc.identity_unlinked"
+	AuditUserSuspended        = "admin.user_suspended"
+	AuditUserRestored         = "admin.user_unsuspended"
+	AuditUserDeleted          = "admin.user_deleted"
+	AuditRoleChanged          = "admin.role_changed"
+	AuditPasswordChanged      = "user.password_changed"
+	AuditEmailChangeRequested = "user.email_change_requested"
+	AuditEmailChanged         = "user.email_changed"
+	AuditAccountDeleted       = "user.account_deleted"
+)
+
+//! AuditEvent --> one security relevant event (who, from where, what happened)
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Event     string    `json:"event"`
+	UserID    *int      `json:"user_id"` //* nil when the username didn't match any user
+	Username  string    `json:"username"`
+	IPAddress string    `json:"ip_address"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PostgresAuditStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresAuditStore --> constructor that creates audit store instance
+func NewPostgresAuditStore(db *sql.DB) *PostgresAuditStore {
+	return &PostgresAuditStore{db: db}
+}
+
+//! AuditStore interface --> append-only log of security events
+type AuditStore interface {
+	InsertAuditEvent(ctx context.Context, event *AuditEvent) error
+}
+
+func (s *PostgresAuditStore) InsertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO audit_events (event, user_id, username, ip_address, detail)
+  VALUES ($1, $2, $3, $4, $5)
+  RETURNING id, created_at
+  `
+	return queryRowContext(ctx, s.db, query, event.Event, event.UserID, event.Username, event.IPAddress, event.Detail).Scan(&event.ID, &event.CreatedAt)
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//! access levels an athlete can grant a coach
+const (
+	CoachAccessRead      = "read"
+	CoachAccessReadWrite = "read_write"
+)
+
+//! invitation lifecycle --> coach invites, athlete accepts or declines
+const (
+	CoachingPending  = "pending"
+	CoachingAccepted = "accepted"
+	CoachingDeclined = "declined"
+)
+
+//! CoachingRelationship --> one coach/athlete pair and what the coach may do
+type CoachingRelationship struct {
+	ID              int64      `json:"id"`
+	CoachID         int        `json:"coach_id"`
+	CoachUsername   string     `json:"coach_username"`
+	AthleteID       int        `json:"athlete_id"`
+	AthleteUsername string     `json:"athlete_username"`
+	Access          string     `json:"access"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RespondedAt     *time.Time `json:"responded_at"`
+}
+
+type PostgresCoachStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresCoachStore --> constructor that creates coach store instance
+func NewPostgresCoachStore(db *sql.DB) *PostgresCoachStore {
+	return &PostgresCoachStore{db: db}
+}
+
+//! CoachStore interface --> contract for coach/athlete grants
+type CoachStore interface {
+	CreateInvitation(ctx context.Context, coachID int, athleteID int, access string) (*CoachingRelationship, error)
+	GetRelationship(ctx context.Context, id int64) (*CoachingRelationship, error)               //* nil when unknown
+	ListPendingInvitations(ctx context.Context, athleteID int) ([]*CoachingRelationship, error) //* what the athlete has to answer
+	RespondToInvitation(ctx context.Context, id int64, athleteID int, accept bool) error        //* sql.ErrNoRows if not pending for this athlete
+	ListAthletes(ctx context.Context, coachID int) ([]*CoachingRelationship, error)             //* accepted only
+	ListCoaches(ctx context.Context, athleteID int) ([]*CoachingRelationship, error)            //* accepted only
+	DeleteRelationship(ctx context.Context, coachID int, athleteID int) error                   //* revokes access from either side
+	GetCoachAccess(ctx context.Context, coachID int, athleteID int) (string, error)             //* "" unless an accepted grant exists
+}
+
+//* shared select --> every read returns both usernames so clients don't need extra lookups
+const coachingSelect = `
+  SELECT ca.id, ca.coach_id, c.username, ca.athlete_id, a.username, ca.access, ca.status, ca.created_at, ca.responded_at
+  FROM coach_athletes ca
+  INNER JOIN users c ON c.id = ca.coach_id
+  INNER JOIN users a ON a.id = ca.athlete_id
+  `
+
+//! CreateInvitation --> re-inviting resets the pair to pending, the athlete always has to agree again
+func (s *PostgresCoachStore) CreateInvitation(ctx context.Context, coachID int, athleteID int, access string) (*CoachingRelationship, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO coach_athletes (coach_id, athlete_id, access)
+  VALUES ($1, $2, $3)
+  ON CONFLICT (coach_id, athlete_id) DO UPDATE
+  SET access = EXCLUDED.access, status = 'pending', responded_at = NULL, created_at = CURRENT_TIMESTAMP
+  RETURNING id
+  `
+	var id int64
+	err := queryRowContext(ctx, s.db, query, coachID, athleteID, access).Scan(&id)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return s.GetRelationship(ctx, id)
+}
+
+func (s *PostgresCoachStore) GetRelationship(ctx context.Context, id int64) (*CoachingRelationship, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	relationships, err := s.query(ctx, coachingSelect+` WHERE ca.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(relationships) == 0 {
+		return nil, nil
+	}
+	return relationships[0], nil
+}
+
+func (s *PostgresCoachStore) ListPendingInvitations(ctx context.Context, athleteID int) ([]*CoachingRelationship, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return s.query(ctx, coachingSelect+` WHERE ca.athlete_id = $1 AND ca.status = 'pending' ORDER BY ca.created_at`, athleteID)
+}
+
+func (s *PostgresCoachStore) RespondToInvitation(ctx context.Context, id int64, athleteID int, accept bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	status := CoachingDeclined
+	if accept {
+		status = CoachingAccepted
+	}
+
+	query := `
+  UPDATE coach_athletes
+  SET status = $3, responded_at = CURRENT_TIMESTAMP
+  WHERE id = $1 AND athlete_id = $2 AND status = 'pending'
+  `
+	result, err := execContext(ctx, s.db, query, id, athleteID, status)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *PostgresCoachStore) ListAthletes(ctx context.Context, coachID int) ([]*CoachingRelationship, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return s.query(ctx, coachingSelect+` WHERE ca.coach_id = $1 AND ca.status = 'accepted' ORDER BY a.username`, coachID)
+}
+
+func (s *PostgresCoachStore) ListCoaches(ctx context.Context, athleteID int) ([]*CoachingRelationship, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return s.query(ctx, coachingSelect+` WHERE ca.athlete_id = $1 AND ca.status = 'accepted' ORDER BY c.username`, athleteID)
+}
+
+func (s *PostgresCoachStore) DeleteRelationship(ctx context.Context, coachID int, athleteID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := execContext(ctx, s.db, `DELETE FROM coach_athletes WHERE coach_id = $1 AND athlete_id = $2`, coachID, athleteID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//! GetCoachAccess --> used by workout authorization on every coach request
+func (s *PostgresCoachStore) GetCoachAccess(ctx context.Context, coachID int, athleteID int) (string, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  SELECT access
+  FROM coach_athletes
+  WHERE coach_id = $1 AND athlete_id = $2 AND status = 'accepted'
+  `
+	var access string
+	err := queryRowContext(ctx, s.db, query, coachID, athleteID).Scan(&access)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return access, nil
+}
+
+func (s *PostgresCoachStore) query(ctx context.Context, query string, args ...interface{}) ([]*CoachingRelationship, error) {
+	rows, err := queryContext(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	relationships := []*CoachingRelationship{}
+	for rows.Next() {
+		r := &CoachingRelationship{}
+		err = rows.Scan(&r.ID, &r.CoachID, &r.CoachUsername, &r.AthleteID, &r.AthleteUsername, &r.Access, &r.Status, &r.CreatedAt, &r.RespondedAt)
+		if err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, r)
+	}
+	return relationships, rows.Err()
+}
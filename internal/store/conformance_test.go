@@ -0,0 +1,498 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fem/internal/tokens"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// * stores --> one backend's workout, user and token stores over the same data
+type stores struct {
+	workouts WorkoutStore
+	users    UserStore
+	tokens   TokenStore
+}
+
+// * backends --> every implementation the conformance tests run against
+// ? postgres and pgxpool need the test db like workout_store_test.go and skip without it, memory runs anywhere
+var backends = []struct {
+	name string
+	open func(t *testing.T) stores
+}{
+	{"memory", func(t *testing.T) stores {
+		db := NewMemoryDB()
+		return stores{NewMemoryWorkoutStore(db), NewMemoryUserStore(db), NewMemoryTokenStore(db)}
+	}},
+	{"postgres", func(t *testing.T) stores {
+		db := setupTestDB(t)
+		t.Cleanup(func() { db.Close() })
+		return stores{NewPostgresWorkoutStore(db), NewPostUserStore(db), NewPostgresTokenStore(db)}
+	}},
+	// ? DB_DRIVER=pgxpool swaps only the workout store, users and tokens stay on database/sql
+	{"pgxpool", func(t *testing.T) stores {
+		db := setupTestDB(t)
+		t.Cleanup(func() { db.Close() })
+		pool, err := OpenPool(context.Background(), testDSN, PoolConfig{MaxOpenConns: 4})
+		require.NoError(t, err)
+		t.Cleanup(pool.Close)
+		return stores{NewPgxWorkoutStore(pool), NewPostUserStore(db), NewPostgresTokenStore(db)}
+	}},
+}
+
+// * runConformance --> every case as backend/case subtest with fresh stores
+func runConformance(t *testing.T, cases map[string]func(t *testing.T, s stores)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			for name, run := range cases {
+				t.Run(name, func(t *testing.T) {
+					run(t, backend.open(t))
+				})
+			}
+		})
+	}
+}
+
+var nameCounter atomic.Int64
+
+// * uniqueName --> postgres keeps users between runs (only workouts are truncated), names must not collide
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d_%d", prefix, time.Now().UnixNano(), nameCounter.Add(1))
+}
+
+// * createTestUser --> a registered user with the password "correct horse battery"
+func createTestUser(t *testing.T, users UserStore) *User {
+	name := uniqueName("athlete")
+	user := &User{Username: name, Email: name + "@example.com"}
+	require.NoError(t, user.PasswordHash.Set("correct horse battery"))
+	require.NoError(t, users.CreateUser(context.Background(), user))
+	return user
+}
+
+// * conformanceWorkout --> entries deliberately out of order_index order
+func conformanceWorkout(userID int) *Workout {
+	return &Workout{
+		UserID:          userID,
+		Title:           "leg day",
+		Description:     "squats first",
+		DurationMinutes: 45,
+		CaloriesBurned:  350,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "lunges", Sets: 3, Reps: intPointer(12), Notes: "", OrderIndex: 2},
+			{ExerciseName: "squats", Sets: 5, Reps: intPointer(5), Weight: floatPointer(100.25), Notes: "belt on", OrderIndex: 1},
+			{ExerciseName: "wall sit", Sets: 2, DurationSeconds: intPointer(60), Notes: "", OrderIndex: 3},
+		},
+	}
+}
+
+// ! TestWorkoutStoreConformance --> ownership, entries and not-found behavior match across backends
+func TestWorkoutStoreConformance(t *testing.T) {
+	ctx := context.Background()
+	runConformance(t, map[string]func(t *testing.T, s stores){
+		"create and get": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			workout, err := s.workouts.CreateWorkout(ctx, conformanceWorkout(user.ID))
+			require.NoError(t, err)
+			assert.NotZero(t, workout.ID)
+			assert.Equal(t, user.ID, workout.CreatedBy) // * creator defaults to the owner
+			for _, entry := range workout.Entries {
+				assert.NotZero(t, entry.ID)
+			}
+
+			got, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+			require.NoError(t, err)
+			require.NotNil(t, got)
+			assert.Equal(t, "leg day", got.Title)
+			assert.Equal(t, user.ID, got.UserID)
+			// ? entries come back ordered by order_index
+			require.Len(t, got.Entries, 3)
+			assert.Equal(t, []string{"squats", "lunges", "wall sit"}, []string{got.Entries[0].ExerciseName, got.Entries[1].ExerciseName, got.Entries[2].ExerciseName})
+			assert.Equal(t, workout.Entries[1], got.Entries[0])
+			assert.Nil(t, got.Entries[2].Reps)
+			assert.Equal(t, 60, *got.Entries[2].DurationSeconds)
+		},
+		"shared order_index": func(t *testing.T, s stores) {
+			// ? clients that leave order_index out send 0 for every entry --> each entry still keeps its own id
+			user := createTestUser(t, s.users)
+			workout := conformanceWorkout(user.ID)
+			for i := range workout.Entries {
+				workout.Entries[i].OrderIndex = 0
+			}
+			workout, err := s.workouts.CreateWorkout(ctx, workout)
+			require.NoError(t, err)
+
+			got, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+			require.NoError(t, err)
+			require.Len(t, got.Entries, 3)
+			for _, entry := range got.Entries {
+				assert.Contains(t, workout.Entries, entry)
+			}
+		},
+		"without entries": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			workout, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: user.ID, Title: "rest day"})
+			require.NoError(t, err)
+
+			got, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+			require.NoError(t, err)
+			assert.Nil(t, got.Entries)
+		},
+		"not found": func(t *testing.T, s stores) {
+			got, err := s.workouts.GetWorkoutByID(ctx, 987654321)
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+
+			_, err = s.workouts.GetWorkoutOwner(ctx, 987654321)
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+			assert.ErrorIs(t, s.workouts.DeleteWorkout(ctx, 987654321), sql.ErrNoRows)
+		},
+		"constraints": func(t *testing.T, s stores) {
+			_, err := s.workouts.CreateWorkout(ctx, &Workout{UserID: 987654321, Title: "nobody's"})
+			assert.ErrorIs(t, err, ErrInvalidReference)
+
+			user := createTestUser(t, s.users)
+			workout := conformanceWorkout(user.ID)
+			workout.Entries[0].DurationSeconds = intPointer(30) // ? reps and duration --> valid_workout_entry
+			_, err = s.workouts.CreateWorkout(ctx, workout)
+			var constraintErr *ConstraintError
+			require.ErrorAs(t, err, &constraintErr)
+			assert.Equal(t, "valid_workout_entry", constraintErr.Constraint)
+		},
+		"update replaces entries": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			workout, err := s.workouts.CreateWorkout(ctx, conformanceWorkout(user.ID))
+			require.NoError(t, err)
+
+			workout.Title = "leg day (short)"
+			workout.DurationMinutes = 20
+			workout.Entries = []WorkoutEntry{{ExerciseName: "squats", Sets: 3, Reps: intPointer(8), OrderIndex: 1}}
+			require.NoError(t, s.workouts.UpdateWorkout(ctx, workout))
+
+			got, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+			require.NoError(t, err)
+			assert.Equal(t, "leg day (short)", got.Title)
+			assert.Equal(t, 20, got.DurationMinutes)
+			require.Len(t, got.Entries, 1)
+			assert.Equal(t, 8, *got.Entries[0].Reps)
+		},
+		"owner and delete": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			workout, err := s.workouts.CreateWorkout(ctx, conformanceWorkout(user.ID))
+			require.NoError(t, err)
+
+			owner, err := s.workouts.GetWorkoutOwner(ctx, int64(workout.ID))
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, owner)
+
+			require.NoError(t, s.workouts.DeleteWorkout(ctx, int64(workout.ID)))
+			got, err := s.workouts.GetWorkoutByID(ctx, int64(workout.ID))
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+			assert.ErrorIs(t, s.workouts.DeleteWorkout(ctx, int64(workout.ID)), sql.ErrNoRows)
+		},
+		"deleting the owner cascades": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			workout, err := s.workouts.CreateWorkout(ctx, conformanceWorkout(user.ID))
+			require.NoError(t, err)
+
+			require.NoError(t, s.users.DeleteUser(ctx, int64(user.ID)))
+			_, err = s.workouts.GetWorkoutOwner(ctx, int64(workout.ID))
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+		},
+	})
+}
+
+// ! TestUserStoreConformance --> lookups, uniqueness, roles and email changes match across backends
+func TestUserStoreConformance(t *testing.T) {
+	ctx := context.Background()
+	runConformance(t, map[string]func(t *testing.T, s stores){
+		"create and look up": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			assert.NotZero(t, user.ID)
+			assert.Equal(t, RoleUser, user.Role)
+
+			byID, err := s.users.GetUserByID(ctx, int64(user.ID))
+			require.NoError(t, err)
+			assert.Equal(t, user.Username, byID.Username)
+			match, err := byID.PasswordHash.Matches("correct horse battery")
+			require.NoError(t, err)
+			assert.True(t, match)
+
+			byName, err := s.users.GetUserByUsername(ctx, user.Username)
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, byName.ID)
+
+			// ? email lookups ignore case
+			byEmail, err := s.users.GetUserByEmail(ctx, user.Username+"@EXAMPLE.com")
+			require.NoError(t, err)
+			require.NotNil(t, byEmail)
+			assert.Equal(t, user.ID, byEmail.ID)
+		},
+		"not found": func(t *testing.T, s stores) {
+			for _, lookup := range []func() (*User, error){
+				func() (*User, error) { return s.users.GetUserByID(ctx, 987654321) },
+				func() (*User, error) { return s.users.GetUserByUsername(ctx, uniqueName("ghost")) },
+				func() (*User, error) { return s.users.GetUserByEmail(ctx, uniqueName("ghost")+"@example.com") },
+			} {
+				user, err := lookup()
+				assert.NoError(t, err)
+				assert.Nil(t, user)
+			}
+
+			ghost := &User{ID: 987654321, Username: uniqueName("ghost"), Email: uniqueName("ghost") + "@example.com"}
+			assert.ErrorIs(t, s.users.UpdateUser(ctx, ghost), sql.ErrNoRows)
+			assert.ErrorIs(t, s.users.SetUserRole(ctx, 987654321, RoleCoach), sql.ErrNoRows)
+			assert.ErrorIs(t, s.users.SetUserSuspended(ctx, 987654321, true), sql.ErrNoRows)
+			assert.ErrorIs(t, s.users.DeleteUser(ctx, 987654321), sql.ErrNoRows)
+		},
+		"duplicates": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+
+			err := s.users.CreateUser(ctx, &User{Username: user.Username, Email: uniqueName("other") + "@example.com"})
+			var constraintErr *ConstraintError
+			require.ErrorAs(t, err, &constraintErr)
+			assert.ErrorIs(t, err, ErrDuplicate)
+			assert.Equal(t, "username", constraintErr.Field)
+
+			// ? emails are looked up case-insensitively, so they have to be unique that way too
+			err = s.users.CreateUser(ctx, &User{Username: uniqueName("shouting"), Email: strings.ToUpper(user.Email)})
+			require.ErrorAs(t, err, &constraintErr)
+			assert.ErrorIs(t, err, ErrDuplicate)
+			assert.Equal(t, "email", constraintErr.Field)
+
+			other := createTestUser(t, s.users)
+			other.Email = user.Email
+			err = s.users.UpdateUser(ctx, other)
+			require.ErrorAs(t, err, &constraintErr)
+			assert.Equal(t, "email", constraintErr.Field)
+		},
+		"update, role and suspension": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			user.Bio = "lifts things"
+			require.NoError(t, s.users.UpdateUser(ctx, user))
+			require.NoError(t, s.users.SetUserRole(ctx, int64(user.ID), RoleCoach))
+			assert.ErrorIs(t, s.users.SetUserRole(ctx, int64(user.ID), "owner"), ErrInvalidValue)
+
+			require.NoError(t, s.users.SetUserSuspended(ctx, int64(user.ID), true))
+			got, err := s.users.GetUserByID(ctx, int64(user.ID))
+			require.NoError(t, err)
+			assert.Equal(t, "lifts things", got.Bio)
+			assert.Equal(t, RoleCoach, got.Role)
+			require.True(t, got.IsSuspended())
+			suspendedAt := *got.SuspendedAt
+
+			// ? suspending twice keeps the first time, lifting it clears it
+			require.NoError(t, s.users.SetUserSuspended(ctx, int64(user.ID), true))
+			got, _ = s.users.GetUserByID(ctx, int64(user.ID))
+			assert.True(t, suspendedAt.Equal(*got.SuspendedAt))
+			require.NoError(t, s.users.SetUserSuspended(ctx, int64(user.ID), false))
+			got, _ = s.users.GetUserByID(ctx, int64(user.ID))
+			assert.False(t, got.IsSuspended())
+		},
+		"list users": func(t *testing.T, s stores) {
+			first := createTestUser(t, s.users)
+			second := createTestUser(t, s.users)
+
+			users, total, err := s.users.ListUsers(ctx, 1000000, 0)
+			require.NoError(t, err)
+			assert.Equal(t, len(users), total)
+			ids := []int{}
+			for i, user := range users {
+				if i > 0 {
+					assert.Less(t, users[i-1].ID, user.ID) // * ordered by id
+				}
+				if user.ID == first.ID || user.ID == second.ID {
+					ids = append(ids, user.ID)
+				}
+			}
+			assert.Equal(t, []int{first.ID, second.ID}, ids)
+
+			// ? a page past the end still knows the total
+			page, pageTotal, err := s.users.ListUsers(ctx, 10, total)
+			require.NoError(t, err)
+			assert.Empty(t, page)
+			assert.Equal(t, total, pageTotal)
+		},
+		"passwords": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			require.NoError(t, user.PasswordHash.Set("a new long passphrase"))
+			require.NoError(t, s.users.UpdatePassword(ctx, user))
+
+			stale, err := s.users.GetUserByID(ctx, int64(user.ID))
+			require.NoError(t, err)
+			require.NoError(t, s.users.RehashPassword(ctx, stale, "a new long passphrase"))
+
+			got, err := s.users.GetUserByID(ctx, int64(user.ID))
+			require.NoError(t, err)
+			match, err := got.PasswordHash.Matches("a new long passphrase")
+			require.NoError(t, err)
+			assert.True(t, match)
+		},
+		"email change": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			newEmail := uniqueName("moved") + "@example.com"
+			token, err := s.users.CreateEmailChange(ctx, user.ID, newEmail, time.Hour)
+			require.NoError(t, err)
+
+			changed, err := s.users.ConfirmEmailChange(ctx, token.Plaintext)
+			require.NoError(t, err)
+			require.NotNil(t, changed)
+			assert.Equal(t, newEmail, changed.Email)
+
+			// ? single use
+			again, err := s.users.ConfirmEmailChange(ctx, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, again)
+
+			// ? expired links do nothing
+			expired, err := s.users.CreateEmailChange(ctx, user.ID, uniqueName("late")+"@example.com", -time.Hour)
+			require.NoError(t, err)
+			late, err := s.users.ConfirmEmailChange(ctx, expired.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, late)
+
+			// ? asking again invalidates the previous link
+			first, err := s.users.CreateEmailChange(ctx, user.ID, uniqueName("first")+"@example.com", time.Hour)
+			require.NoError(t, err)
+			_, err = s.users.CreateEmailChange(ctx, user.ID, uniqueName("second")+"@example.com", time.Hour)
+			require.NoError(t, err)
+			replaced, err := s.users.ConfirmEmailChange(ctx, first.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, replaced)
+		},
+		"email change conflict": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			other := createTestUser(t, s.users)
+			// ? taken addresses compare case-insensitively, like GetUserByEmail
+			token, err := s.users.CreateEmailChange(ctx, other.ID, user.Username+"@EXAMPLE.com", time.Hour)
+			require.NoError(t, err)
+
+			_, err = s.users.ConfirmEmailChange(ctx, token.Plaintext)
+			assert.ErrorIs(t, err, ErrDuplicate)
+		},
+	})
+}
+
+// ! TestTokenStoreConformance --> expiry, scopes and single use match across backends
+func TestTokenStoreConformance(t *testing.T) {
+	ctx := context.Background()
+	runConformance(t, map[string]func(t *testing.T, s stores){
+		"login token": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			token, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+			require.NoError(t, err)
+
+			got, err := s.users.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+			require.NoError(t, err)
+			require.NotNil(t, got)
+			assert.Equal(t, user.ID, got.ID)
+
+			// ? another scope or an unknown token --> nobody
+			got, err = s.users.GetUserToken(ctx, tokens.ScopeMagicLink, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+			got, err = s.users.GetUserToken(ctx, tokens.ScopeAuth, "not-a-token")
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+
+			grantUser, grant, err := s.tokens.GetTokenGrant(ctx, tokens.ScopeAuth, token.Plaintext)
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, grantUser.ID)
+			assert.Empty(t, grant.ClientID)
+			assert.Nil(t, grant.GrantedScopes)
+			assert.WithinDuration(t, token.Expiry, grant.Expiry, time.Second)
+
+			require.NoError(t, s.tokens.DeleteToken(ctx, tokens.ScopeAuth, token.Plaintext))
+			require.NoError(t, s.tokens.DeleteToken(ctx, tokens.ScopeAuth, token.Plaintext)) // * already gone is fine
+			got, _ = s.users.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+			assert.Nil(t, got)
+		},
+		"expired": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			token, err := s.tokens.CreateNewToken(ctx, user.ID, -time.Minute, tokens.ScopeAuth)
+			require.NoError(t, err)
+
+			got, err := s.users.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+			grantUser, grant, err := s.tokens.GetTokenGrant(ctx, tokens.ScopeAuth, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, grantUser)
+			assert.Nil(t, grant)
+			consumed, err := s.tokens.ConsumeToken(ctx, tokens.ScopeAuth, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, consumed)
+		},
+		"unknown user": func(t *testing.T, s stores) {
+			_, err := s.tokens.CreateNewToken(ctx, 987654321, time.Hour, tokens.ScopeAuth)
+			assert.True(t, errors.Is(err, ErrInvalidReference), "got %v", err)
+		},
+		"consume once": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			token, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeMagicLink)
+			require.NoError(t, err)
+
+			consumed, err := s.tokens.ConsumeToken(ctx, tokens.ScopeMagicLink, token.Plaintext)
+			require.NoError(t, err)
+			require.NotNil(t, consumed)
+			assert.Equal(t, user.ID, consumed.ID)
+
+			consumed, err = s.tokens.ConsumeToken(ctx, tokens.ScopeMagicLink, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, consumed)
+		},
+		"delete per user": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			current, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+			require.NoError(t, err)
+			other, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+			require.NoError(t, err)
+			reset, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeMagicLink)
+			require.NoError(t, err)
+
+			// ? every other login token goes, the current one and other scopes stay
+			require.NoError(t, s.tokens.DeleteOtherTokensForUser(ctx, user.ID, tokens.ScopeAuth, current.Plaintext))
+			got, _ := s.users.GetUserToken(ctx, tokens.ScopeAuth, current.Plaintext)
+			assert.NotNil(t, got)
+			got, _ = s.users.GetUserToken(ctx, tokens.ScopeAuth, other.Plaintext)
+			assert.Nil(t, got)
+
+			require.NoError(t, s.tokens.DeleteAllTokensForUser(ctx, user.ID, tokens.ScopeAuth))
+			got, _ = s.users.GetUserToken(ctx, tokens.ScopeAuth, current.Plaintext)
+			assert.Nil(t, got)
+			got, _ = s.users.GetUserToken(ctx, tokens.ScopeMagicLink, reset.Plaintext)
+			assert.NotNil(t, got)
+		},
+		"recently issued": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			_, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeMagicLink)
+			require.NoError(t, err)
+
+			recent, err := s.tokens.HasTokenExpiringAfter(ctx, user.ID, tokens.ScopeMagicLink, time.Now().Add(50*time.Minute))
+			require.NoError(t, err)
+			assert.True(t, recent)
+			recent, err = s.tokens.HasTokenExpiringAfter(ctx, user.ID, tokens.ScopeMagicLink, time.Now().Add(2*time.Hour))
+			require.NoError(t, err)
+			assert.False(t, recent)
+		},
+		"deleting the user cascades": func(t *testing.T, s stores) {
+			user := createTestUser(t, s.users)
+			token, err := s.tokens.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+			require.NoError(t, err)
+
+			require.NoError(t, s.users.DeleteUser(ctx, int64(user.ID)))
+			got, err := s.users.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+		},
+	})
+}
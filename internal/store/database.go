@@ -1,47 +1,108 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
-	"os"
+	"log/slog"
+	"time"
 
+	"github.com/jackc/pgx/v4/pgxpool"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
-//! getEnv --> helper function to get environment variable with fallback
-func getEnv(key, fallback string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return fallback
+//! queryTimeout --> upper bound for one store call, a hung query gives up instead of holding a pool connection
+//? the request context is the parent --> a client that disconnects cancels its queries even sooner
+var queryTimeout = 5 * time.Second
+
+//! SetQueryTimeout --> app.go installs DB_QUERY_TIMEOUT before serving requests
+func SetQueryTimeout(timeout time.Duration) {
+	queryTimeout = timeout
 }
 
-//! Open --> establishes connection to PostgreSQL database
-//! Using port 5445 locally (not 5432) to avoid Windows port reservation conflicts
-//! In Docker, it uses environment variables and connects to port 5432
-func Open() (*sql.DB, error) {
-	//* get database configuration from environment variables with fallbacks
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "5445") //* 5445 for local Windows, 5432 in Docker
-	user := getEnv("DB_USER", "postgres")
-	password := getEnv("DB_PASSWORD", "postgres")
-	dbname := getEnv("DB_NAME", "postgres")
+//! withQueryTimeout --> every postgres store method starts with this
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
 
-	//* connection string with all database credentials
-	connStr := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		host, user, password, dbname, port)
+//! PoolConfig --> connection pool limits, the same numbers apply to database/sql and pgxpool
+type PoolConfig struct {
+	MaxOpenConns    int           //* upper bound of connections to postgres
+	MaxIdleConns    int           //* kept open between bursts, database/sql only (pgxpool keeps what it has until MaxConnIdleTime)
+	ConnMaxLifetime time.Duration //* recycled after this long, 0 = forever --> picks up failovers and DNS changes
+	ConnMaxIdleTime time.Duration //* closed after sitting idle this long, 0 = never
+}
 
-	db, err := sql.Open("pgx", connStr)
+//! Open --> connection pool for the given connection string (config.Database.DSN)
+//! sql.Open never dials, the first query or ping does --> WaitForDB right after
+func Open(dsn string, pool PoolConfig) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
 
 	//? if caught any error while opening connection
 	if err != nil {
 		return nil, fmt.Errorf("db : open %w", err)
 	}
-	fmt.Printf("Connected to the Database at %s:%s...\n", host, port)
-	return db, err //* return connection pool
 
+	//* the database/sql defaults are unlimited open connections and 2 idle ones --> bursts open and close connections
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	return db, nil //* return connection pool
+
+}
+
+//! OpenPool --> native pgx pool for PgxWorkoutStore, skips the database/sql layer and can pipeline batches
+//? lazy like sql.Open --> nothing is dialed until WaitForDB pings
+func OpenPool(ctx context.Context, dsn string, pool PoolConfig) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool : parse %w", err)
+	}
+	config.MaxConns = int32(pool.MaxOpenConns)
+	config.MaxConnLifetime = pool.ConnMaxLifetime
+	config.MaxConnIdleTime = pool.ConnMaxIdleTime
+	config.LazyConnect = true
+
+	pgxPool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("pgxpool : open %w", err)
+	}
+	return pgxPool, nil
+}
+
+//! startup ping backoff --> 250ms, 500ms, 1s ... capped at 5s between attempts
+const (
+	pingInitialBackoff = 250 * time.Millisecond
+	pingMaxBackoff     = 5 * time.Second
+)
+
+//! WaitForDB --> pings until postgres answers or ctx is done (the caller sets database.connect_timeout on it)
+//? docker compose starts the api and postgres together, postgres needs a few seconds before it accepts connections
+func WaitForDB(ctx context.Context, ping func(context.Context) error, logger *slog.Logger) error {
+	backoff := pingInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+		//? ctx expired during the ping itself --> report the ping error, it says more than "deadline exceeded"
+		if ctx.Err() != nil {
+			return fmt.Errorf("db : not reachable after %d attempts : %w", attempt, err)
+		}
+
+		logger.Warn("database not reachable yet, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("db : not reachable after %d attempts : %w", attempt, err)
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, pingMaxBackoff)
+	}
 }
 
 //! Migratefs --> runs database migrations from embedded filesystem
@@ -72,4 +133,13 @@ func Migrate(db *sql.DB,dir string)error{
 	}
 	return nil
 
-}
\ No newline at end of file
+}
+
+//! MigrationVersion --> latest goose migration applied to the database, reported by /readyz
+func MigrationVersion(ctx context.Context,db *sql.DB) (int64,error) {
+	version,err := goose.GetDBVersionContext(ctx,db)
+	if err != nil {
+		return 0,fmt.Errorf("goose version : %w",err)
+	}
+	return version,nil
+}
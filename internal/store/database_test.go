@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestWaitForDBRetries --> postgres answering on the third ping is a successful startup
+func TestWaitForDBRetries(t *testing.T) {
+	attempts := 0
+	ping := func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	err := WaitForDB(context.Background(), ping, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+// ! TestWaitForDBGivesUp --> the last ping error is reported once the connect timeout is over
+func TestWaitForDBGivesUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := WaitForDB(ctx, func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, slog.New(slog.DiscardHandler))
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Less(t, time.Since(start), pingInitialBackoff*2) // * no sleeping past the deadline
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
+
+//! constraint errors --> postgres violations as domain errors, handlers check them with errors.Is
+var (
+	ErrDuplicate        = errors.New("value is already in use")          //* unique_violation
+	ErrInvalidReference = errors.New("referenced record does not exist") //* foreign_key_violation
+	ErrInvalidValue     = errors.New("value is not allowed")             //* check_violation
+)
+
+//! postgres SQLSTATE codes we translate (class 23, integrity constraint violation)
+const (
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+	pgCheckViolation      = "23514"
+)
+
+//! ConstraintError --> which constraint failed and the request field it is about
+type ConstraintError struct {
+	Err        error  //* ErrDuplicate, ErrInvalidReference or ErrInvalidValue
+	Constraint string //* postgres constraint name
+	Field      string //* json field the client sent, "" when the constraint isn't tied to one
+	Message    string //* safe to show to clients
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Constraint + ": " + e.Message
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+//! constraintFields --> client facing field + message per constraint, unknown ones fall back to the generic error text
+//? postgres names unnamed constraints <table>_<columns>_key / _fkey
+var constraintFields = map[string]struct {
+	field   string
+	message string
+}{
+	"users_username_key":        {"username", "username is already taken"},
+	"users_email_key":           {"email", "email is already in use"},
+	"users_email_lower_key":     {"email", "email is already in use"},
+	"valid_user_role":           {"role", "role must be user, coach or admin"},
+	"valid_workout_entry":       {"entries", "each entry needs either reps or duration_seconds, not both"},
+	"workouts_user_id_fkey":     {"user_id", "user does not exist"},
+	"api_keys_user_id_name_key": {"name", "an api key with this name already exists"},
+	"valid_coach_access":        {"access", "access must be read or read_write"},
+	"coach_is_not_athlete":      {"athlete_username", "you cannot coach yourself"},
+	"unique_provider_subject":   {"", "this account of the identity provider is already linked to a user"},
+	"unique_user_provider":      {"", "an account of this identity provider is already linked, unlink it first"},
+}
+
+//! newConstraintError --> builds the error for a known constraint name
+func newConstraintError(kind error, constraint string) *ConstraintError {
+	known, ok := constraintFields[constraint]
+	if !ok {
+		return &ConstraintError{Err: kind, Constraint: constraint, Message: kind.Error()}
+	}
+	return &ConstraintError{Err: kind, Constraint: constraint, Field: known.field, Message: known.message}
+}
+
+//! translateError --> wraps constraint violations in a ConstraintError, every other error passes through unchanged
+func translateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		return newConstraintError(ErrDuplicate, pgErr.ConstraintName)
+	case pgForeignKeyViolation:
+		return newConstraintError(ErrInvalidReference, pgErr.ConstraintName)
+	case pgCheckViolation:
+		return newConstraintError(ErrInvalidValue, pgErr.ConstraintName)
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestTranslateError --> constraint violations become ConstraintErrors, everything else is untouched
+func TestTranslateError(t *testing.T) {
+	test := []struct {
+		name      string
+		err       error
+		wantKind  error
+		wantField string
+	}{
+		{name: "duplicate username", err: &pgconn.PgError{Code: "23505", ConstraintName: "users_username_key"}, wantKind: ErrDuplicate, wantField: "username"},
+		{name: "email differing in case", err: &pgconn.PgError{Code: "23505", ConstraintName: "users_email_lower_key"}, wantKind: ErrDuplicate, wantField: "email"},
+		{name: "entry check", err: &pgconn.PgError{Code: "23514", ConstraintName: "valid_workout_entry"}, wantKind: ErrInvalidValue, wantField: "entries"},
+		{name: "wrapped foreign key", err: fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23503", ConstraintName: "workouts_user_id_fkey"}), wantKind: ErrInvalidReference, wantField: "user_id"},
+		{name: "unknown constraint", err: &pgconn.PgError{Code: "23505", ConstraintName: "something_new_key"}, wantKind: ErrDuplicate},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			err := translateError(tt.err)
+			assert.ErrorIs(t, err, tt.wantKind)
+
+			var constraintErr *ConstraintError
+			require.ErrorAs(t, err, &constraintErr)
+			assert.Equal(t, tt.wantField, constraintErr.Field)
+			assert.NotEmpty(t, constraintErr.Message)
+		})
+	}
+
+	// ? not a constraint violation --> same error back
+	for _, err := range []error{nil, sql.ErrNoRows, &pgconn.PgError{Code: "40001"}} {
+		assert.Equal(t, err, translateError(err))
+	}
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//! UserIdentity --> an account at an external OpenID provider linked to one of our users
+type UserIdentity struct {
+	ID          int64      `json:"id"`
+	UserID      int        `json:"-"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"-"` //* provider's stable user id, never shown to clients
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+//! OIDCLoginState --> what we must remember between redirecting to the provider and its callback
+type OIDCLoginState struct {
+	Hash         []byte //* sha256 of the state parameter
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	LinkUserID   *int //* set when a logged in user links a provider instead of logging in
+	Expiry       time.Time
+}
+
+type PostgresIdentityStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresIdentityStore --> constructor that creates identity store instance
+func NewPostgresIdentityStore(db *sql.DB) *PostgresIdentityStore {
+	return &PostgresIdentityStore{db: db}
+}
+
+//! IdentityStore interface --> contract for external logins (OpenID Connect)
+type IdentityStore interface {
+	GetUserForIdentity(ctx context.Context, provider string, subject string) (*User, error)  //* login lookup, bumps last_login_at --> nil when unknown
+	GetIdentity(ctx context.Context, provider string, subject string) (*UserIdentity, error) //* nil when unknown
+	CreateUserWithIdentity(ctx context.Context, user *User, identity *UserIdentity) error    //* sign-up, both rows or neither
+	LinkIdentity(ctx context.Context, identity *UserIdentity) error
+	ListIdentities(ctx context.Context, userID int) ([]*UserIdentity, error)
+	DeleteIdentity(ctx context.Context, userID int, id int64) error //* sql.ErrNoRows if not the user's identity
+	CreateOIDCLoginState(ctx context.Context, state *OIDCLoginState) error
+	ConsumeOIDCLoginState(ctx context.Context, hash []byte) (*OIDCLoginState, error) //* deletes it --> nil on second use
+}
+
+func (s *PostgresIdentityStore) GetUserForIdentity(ctx context.Context, provider string, subject string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  WITH identity AS (
+    UPDATE user_identities
+    SET last_login_at = CURRENT_TIMESTAMP
+    WHERE provider = $1 AND subject = $2
+    RETURNING user_id
+  )
+  SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.role, u.suspended_at, u.created_at, u.updated_at
+  FROM users u
+  INNER JOIN identity i ON i.user_id = u.id
+  `
+	user := &User{PasswordHash: password{}}
+	err := queryRowContext(ctx, s.db, query, provider, subject).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *PostgresIdentityStore) GetIdentity(ctx context.Context, provider string, subject string) (*UserIdentity, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	identities, err := s.queryIdentities(ctx, `WHERE provider = $1 AND subject = $2`, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, nil
+	}
+	return identities[0], nil
+}
+
+//! CreateUserWithIdentity --> a user without the identity (or the reverse) would be unreachable
+func (s *PostgresIdentityStore) CreateUserWithIdentity(ctx context.Context, user *User, identity *UserIdentity) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+  INSERT INTO users (username, email, password_hash, bio, role)
+  VALUES ($1, $2, $3, $4, $5)
+  RETURNING id, created_at, updated_at
+  `
+	err = queryRowContext(ctx, tx, query, user.Username, user.Email, user.PasswordHash.hash, user.Bio, user.Role).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return translateError(err)
+	}
+
+	identity.UserID = user.ID
+	err = insertIdentity(ctx, tx, identity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresIdentityStore) LinkIdentity(ctx context.Context, identity *UserIdentity) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return insertIdentity(ctx, s.db, identity)
+}
+
+func (s *PostgresIdentityStore) ListIdentities(ctx context.Context, userID int) ([]*UserIdentity, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return s.queryIdentities(ctx, `WHERE user_id = $1 ORDER BY provider`, userID)
+}
+
+func (s *PostgresIdentityStore) DeleteIdentity(ctx context.Context, userID int, id int64) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := execContext(ctx, s.db, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *PostgresIdentityStore) CreateOIDCLoginState(ctx context.Context, state *OIDCLoginState) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO oidc_login_states (hash, provider, nonce, code_verifier, link_user_id, expiry)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  `
+	_, err := execContext(ctx, s.db, query, state.Hash, state.Provider, state.Nonce, state.CodeVerifier, state.LinkUserID, state.Expiry)
+	return err
+}
+
+//! ConsumeOIDCLoginState --> DELETE ... RETURNING so a callback can't be replayed
+func (s *PostgresIdentityStore) ConsumeOIDCLoginState(ctx context.Context, hash []byte) (*OIDCLoginState, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  DELETE FROM oidc_login_states
+  WHERE hash = $1
+  RETURNING hash, provider, nonce, code_verifier, link_user_id, expiry
+  `
+	state := &OIDCLoginState{}
+	err := queryRowContext(ctx, s.db, query, hash).Scan(&state.Hash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.LinkUserID, &state.Expiry)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+//* insertIdentity --> q is the db or the sign-up transaction
+func insertIdentity(ctx context.Context, q queryer, identity *UserIdentity) error {
+	query := `
+  INSERT INTO user_identities (user_id, provider, subject, email)
+  VALUES ($1, $2, $3, $4)
+  RETURNING id, created_at
+  `
+	err := queryRowContext(ctx, q, query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+	return translateError(err)
+}
+
+func (s *PostgresIdentityStore) queryIdentities(ctx context.Context, where string, args ...interface{}) ([]*UserIdentity, error) {
+	query := `
+  SELECT id, user_id, provider, subject, email, created_at, last_login_at
+  FROM user_identities
+  ` + where
+
+	rows, err := queryContext(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*UserIdentity{}
+	for rows.Next() {
+		identity := &UserIdentity{}
+		err = rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
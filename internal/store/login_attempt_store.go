@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//! subject types --> failed logins are tracked per account and per client address
+const (
+	LoginSubjectUsername = "username"
+	LoginSubjectIP       = "ip"
+)
+
+//! LoginAttempt --> failure counter for one username or one IP address
+type LoginAttempt struct {
+	SubjectType   string
+	Subject       string
+	Failures      int
+	LastFailureAt *time.Time
+	BlockedUntil  *time.Time //* nil or in the past --> not blocked
+}
+
+type PostgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresLoginAttemptStore --> state lives in postgres so every instance sees the same counters
+func NewPostgresLoginAttemptStore(db *sql.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db: db}
+}
+
+//! LoginAttemptStore interface --> contract for brute-force bookkeeping
+type LoginAttemptStore interface {
+	GetLoginAttempt(ctx context.Context, subjectType string, subject string) (*LoginAttempt, error)                               //* nil when nothing recorded
+	RecordLoginFailure(ctx context.Context, subjectType string, subject string, now time.Time, window time.Duration) (int, error) //* returns failures inside window
+	BlockLogin(ctx context.Context, subjectType string, subject string, until time.Time) error                                    //* sets backoff / lockout
+	ClearLoginAttempts(ctx context.Context, subjectType string, subject string) error                                             //* after a successful login
+}
+
+func (s *PostgresLoginAttemptStore) GetLoginAttempt(ctx context.Context, subjectType string, subject string) (*LoginAttempt, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	attempt := &LoginAttempt{}
+	query := `
+  SELECT subject_type, subject, failures, last_failure_at, blocked_until
+  FROM login_attempts
+  WHERE subject_type = $1 AND subject = $2
+  `
+	err := queryRowContext(ctx, s.db, query, subjectType, subject).Scan(&attempt.SubjectType, &attempt.Subject, &attempt.Failures, &attempt.LastFailureAt, &attempt.BlockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+//! RecordLoginFailure --> atomic upsert so concurrent requests on different instances can't lose a failure
+//? failures older than the window don't count anymore --> counter starts over at 1
+func (s *PostgresLoginAttemptStore) RecordLoginFailure(ctx context.Context, subjectType string, subject string, now time.Time, window time.Duration) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO login_attempts (subject_type, subject, failures, last_failure_at)
+  VALUES ($1, $2, 1, $3)
+  ON CONFLICT (subject_type, subject) DO UPDATE
+  SET failures = CASE
+        WHEN login_attempts.last_failure_at IS NULL OR login_attempts.last_failure_at < $4 THEN 1
+        ELSE login_attempts.failures + 1
+      END,
+      last_failure_at = EXCLUDED.last_failure_at
+  RETURNING failures
+  `
+	var failures int
+	err := queryRowContext(ctx, s.db, query, subjectType, subject, now, now.Add(-window)).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (s *PostgresLoginAttemptStore) BlockLogin(ctx context.Context, subjectType string, subject string, until time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE login_attempts
+  SET blocked_until = GREATEST(COALESCE(blocked_until, $3), $3)
+  WHERE subject_type = $1 AND subject = $2
+  `
+	_, err := execContext(ctx, s.db, query, subjectType, subject, until)
+	return err
+}
+
+func (s *PostgresLoginAttemptStore) ClearLoginAttempts(ctx context.Context, subjectType string, subject string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  DELETE FROM login_attempts
+  WHERE subject_type = $1 AND subject = $2
+  `
+	_, err := execContext(ctx, s.db, query, subjectType, subject)
+	return err
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"fem/internal/tokens"
+	"math"
+	"sync"
+	"time"
+)
+
+//! MemoryDB --> the tables behind the in-memory workout, user and token stores
+//? one value shared by all three, so tokens resolve to users and deleting a user cascades like the foreign keys do
+//? same semantics as the postgres stores (ids, nil for not found, sql.ErrNoRows, constraint errors, token expiry)
+//? --> handler tests run on httptest without a database, conformance_test.go keeps both in line
+type MemoryDB struct {
+	mu            sync.Mutex
+	users         map[int]*User
+	workouts      map[int]*Workout
+	tokens        map[string]*tokens.Token   //* keyed by hash, like the tokens primary key
+	emailChanges  map[int]*memoryEmailChange //* keyed by user id, one pending change per user
+	lastUserID    int
+	lastWorkoutID int
+	lastEntryID   int
+}
+
+//* memoryEmailChange --> a row of email_changes
+type memoryEmailChange struct {
+	hash     string
+	newEmail string
+	expiry   time.Time
+}
+
+//! NewMemoryDB --> empty tables, ids start at 1 like a fresh BIGSERIAL
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:        map[int]*User{},
+		workouts:     map[int]*Workout{},
+		tokens:       map[string]*tokens.Token{},
+		emailChanges: map[int]*memoryEmailChange{},
+	}
+}
+
+//! deleteUser --> the user row plus everything ON DELETE CASCADE / SET NULL touches in these tables
+func (db *MemoryDB) deleteUser(id int) {
+	delete(db.users, id)
+	delete(db.emailChanges, id)
+	for hash, token := range db.tokens {
+		if token.UserID == id {
+			delete(db.tokens, hash)
+		}
+	}
+	for workoutID, workout := range db.workouts {
+		if workout.UserID == id {
+			delete(db.workouts, workoutID)
+			continue
+		}
+		if workout.CreatedBy == id {
+			workout.CreatedBy = 0 //* created_by SET NULL, read back through COALESCE(created_by, 0)
+		}
+	}
+}
+
+//! copyUser --> what a SELECT would return, callers can't change the stored row through the pointer
+func copyUser(user *User) *User {
+	copied := *user
+	copied.PasswordHash = password{hash: user.PasswordHash.hash}
+	if user.SuspendedAt != nil {
+		suspendedAt := *user.SuspendedAt
+		copied.SuspendedAt = &suspendedAt
+	}
+	return &copied
+}
+
+//! copyWorkout --> deep copy, entries and their optional fields included
+func copyWorkout(workout *Workout) *Workout {
+	copied := *workout
+	copied.Entries = nil
+	for _, entry := range workout.Entries {
+		copied.Entries = append(copied.Entries, copyEntry(entry))
+	}
+	return &copied
+}
+
+func copyEntry(entry WorkoutEntry) WorkoutEntry {
+	if entry.Reps != nil {
+		reps := *entry.Reps
+		entry.Reps = &reps
+	}
+	if entry.DurationSeconds != nil {
+		duration := *entry.DurationSeconds
+		entry.DurationSeconds = &duration
+	}
+	if entry.Weight != nil {
+		weight := math.Round(*entry.Weight*100) / 100 //* DECIMAL(5, 2)
+		entry.Weight = &weight
+	}
+	return entry
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fem/internal/scopes"
+	"strings"
+	"time"
+)
+
+//! OAuthClient --> third-party app registered by a user (e.g. a gym's kiosk)
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	UserID       int       `json:"-"` //* who registered (and may delete) the client
+	Name         string    `json:"name"`
+	SecretHash   []byte    `json:"-"` //* nil for public clients that rely on PKCE alone
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"` //* upper bound of what the client may ask for
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+//! IsConfidential --> confidential clients must also authenticate at /oauth/token
+func (c *OAuthClient) IsConfidential() bool {
+	return len(c.SecretHash) > 0
+}
+
+//! AllowsRedirectURI --> exact string match, no prefix or wildcard matching
+func (c *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+//! OAuthAuthorizationCode --> short-lived, single-use result of the consent page
+type OAuthAuthorizationCode struct {
+	Hash          []byte
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string //* PKCE S256 challenge the code_verifier must match
+	Expiry        time.Time
+}
+
+type PostgresOAuthStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresOAuthStore --> constructor that creates oauth store instance
+func NewPostgresOAuthStore(db *sql.DB) *PostgresOAuthStore {
+	return &PostgresOAuthStore{db: db}
+}
+
+//! OAuthStore interface --> contract for clients and authorization codes
+type OAuthStore interface {
+	CreateClient(ctx context.Context, client *OAuthClient) error
+	GetClient(ctx context.Context, id string) (*OAuthClient, error) //* nil when unknown
+	ListClients(ctx context.Context, userID int) ([]*OAuthClient, error)
+	DeleteClient(ctx context.Context, userID int, id string) error //* sql.ErrNoRows if not the user's client
+	CreateAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, hash []byte) (*OAuthAuthorizationCode, error) //* deletes it --> nil on second use
+}
+
+func (s *PostgresOAuthStore) CreateClient(ctx context.Context, client *OAuthClient) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO oauth_clients (id, user_id, name, secret_hash, redirect_uris, scopes)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  RETURNING created_at
+  `
+	return queryRowContext(ctx, s.db, query, client.ID, client.UserID, client.Name, client.SecretHash, strings.Join(client.RedirectURIs, " "), scopes.Join(client.Scopes)).Scan(&client.CreatedAt)
+}
+
+func (s *PostgresOAuthStore) GetClient(ctx context.Context, id string) (*OAuthClient, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	clients, err := s.queryClients(ctx, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, nil
+	}
+	return clients[0], nil
+}
+
+func (s *PostgresOAuthStore) ListClients(ctx context.Context, userID int) ([]*OAuthClient, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return s.queryClients(ctx, `WHERE user_id = $1 ORDER BY created_at`, userID)
+}
+
+func (s *PostgresOAuthStore) DeleteClient(ctx context.Context, userID int, id string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := execContext(ctx, s.db, `DELETE FROM oauth_clients WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *PostgresOAuthStore) CreateAuthorizationCode(ctx context.Context, code *OAuthAuthorizationCode) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO oauth_authorization_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+  VALUES ($1, $2, $3, $4, $5, $6, $7)
+  `
+	_, err := execContext(ctx, s.db, query, code.Hash, code.ClientID, code.UserID, code.RedirectURI, scopes.Join(code.Scopes), code.CodeChallenge, code.Expiry)
+	return err
+}
+
+//! ConsumeAuthorizationCode --> DELETE ... RETURNING makes redeeming a code atomic
+//? two parallel token requests with the same code can't both succeed
+func (s *PostgresOAuthStore) ConsumeAuthorizationCode(ctx context.Context, hash []byte) (*OAuthAuthorizationCode, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  DELETE FROM oauth_authorization_codes
+  WHERE hash = $1
+  RETURNING hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry
+  `
+	code := &OAuthAuthorizationCode{}
+	var rawScopes string
+	err := queryRowContext(ctx, s.db, query, hash).Scan(&code.Hash, &code.ClientID, &code.UserID, &code.RedirectURI, &rawScopes, &code.CodeChallenge, &code.Expiry)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	code.Scopes = scopes.Split(rawScopes)
+	return code, nil
+}
+
+func (s *PostgresOAuthStore) queryClients(ctx context.Context, where string, args ...interface{}) ([]*OAuthClient, error) {
+	query := `
+  SELECT id, user_id, name, secret_hash, redirect_uris, scopes, created_at
+  FROM oauth_clients
+  ` + where
+
+	rows, err := queryContext(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		client := &OAuthClient{}
+		var redirectURIs, rawScopes string
+		err = rows.Scan(&client.ID, &client.UserID, &client.Name, &client.SecretHash, &redirectURIs, &rawScopes, &client.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		client.RedirectURIs = strings.Fields(redirectURIs)
+		client.Scopes = scopes.Split(rawScopes)
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"fem/internal/tokens"
+	"time"
+)
+
+//! MemoryTokenStore --> TokenStore on a MemoryDB
+//? oauth client ids are stored as given, there is no oauth_clients table to check them against
+type MemoryTokenStore struct {
+	db *MemoryDB
+}
+
+//! NewMemoryTokenStore --> constructor, pass the same MemoryDB to the workout and user stores
+func NewMemoryTokenStore(db *MemoryDB) *MemoryTokenStore {
+	return &MemoryTokenStore{db: db}
+}
+
+//! Insert --> keeps the hash and never the plaintext, expiry rounded to seconds like TIMESTAMP(0)
+func (m *MemoryTokenStore) Insert(ctx context.Context, token *tokens.Token) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if m.db.users[token.UserID] == nil {
+		return newConstraintError(ErrInvalidReference, "tokens_user_id_fkey")
+	}
+	if m.db.tokens[string(token.Hash)] != nil {
+		return newConstraintError(ErrDuplicate, "tokens_pkey")
+	}
+
+	stored := &tokens.Token{
+		Hash:   token.Hash,
+		UserID: token.UserID,
+		Expiry: token.Expiry.Round(time.Second),
+		Scope:  token.Scope,
+	}
+	//* first-party tokens have no client and no scope restriction
+	if token.ClientID != "" {
+		stored.ClientID = token.ClientID
+		stored.GrantedScopes = append([]string{}, token.GrantedScopes...)
+	}
+	m.db.tokens[string(token.Hash)] = stored
+	return nil
+}
+
+func (m *MemoryTokenStore) CreateNewToken(ctx context.Context, userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m *MemoryTokenStore) DeleteAllTokensForUser(ctx context.Context, userID int, scope string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	m.db.deleteTokens(func(token *tokens.Token) bool {
+		return token.UserID == userID && token.Scope == scope
+	})
+	return nil
+}
+
+//! DeleteOtherTokensForUser --> like DeleteAllTokensForUser but spares the token of the current request
+func (m *MemoryTokenStore) DeleteOtherTokensForUser(ctx context.Context, userID int, scope string, keepPlainText string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	keep := string(tokens.Hash(keepPlainText))
+	m.db.deleteTokens(func(token *tokens.Token) bool {
+		return token.UserID == userID && token.Scope == scope && string(token.Hash) != keep
+	})
+	return nil
+}
+
+func (m *MemoryTokenStore) CreateOAuthToken(ctx context.Context, userID int, clientID string, granted []string, ttl time.Duration) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(userID, ttl, tokens.ScopeOAuthAccess)
+	if err != nil {
+		return nil, err
+	}
+	token.ClientID = clientID
+	token.GrantedScopes = granted
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+//! GetTokenGrant --> user + the stored token (no plaintext), nil,nil,nil if invalid or expired
+func (m *MemoryTokenStore) GetTokenGrant(ctx context.Context, scope string, tokenPlainText string) (*User, *tokens.Token, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token := m.db.validToken(scope, tokenPlainText)
+	if token == nil {
+		return nil, nil, nil
+	}
+	grant := *token
+	grant.GrantedScopes = append([]string(nil), token.GrantedScopes...)
+	return copyUser(m.db.users[token.UserID]), &grant, nil
+}
+
+//! DeleteClientToken --> only the client's own tokens
+func (m *MemoryTokenStore) DeleteClientToken(ctx context.Context, clientID string, tokenPlainText string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	hash := string(tokens.Hash(tokenPlainText))
+	if token := m.db.tokens[hash]; token != nil && token.ClientID == clientID {
+		delete(m.db.tokens, hash)
+	}
+	return nil
+}
+
+//! DeleteToken --> no error if it is already gone
+func (m *MemoryTokenStore) DeleteToken(ctx context.Context, scope string, tokenPlainText string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	hash := string(tokens.Hash(tokenPlainText))
+	if token := m.db.tokens[hash]; token != nil && token.Scope == scope {
+		delete(m.db.tokens, hash)
+	}
+	return nil
+}
+
+//! ConsumeToken --> single use, nil on the second call and for expired tokens
+func (m *MemoryTokenStore) ConsumeToken(ctx context.Context, scope string, tokenPlainText string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token := m.db.validToken(scope, tokenPlainText)
+	if token == nil {
+		return nil, nil
+	}
+	delete(m.db.tokens, string(token.Hash))
+	return copyUser(m.db.users[token.UserID]), nil
+}
+
+func (m *MemoryTokenStore) HasTokenExpiringAfter(ctx context.Context, userID int, scope string, after time.Time) (bool, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	for _, token := range m.db.tokens {
+		if token.UserID == userID && token.Scope == scope && token.Expiry.After(after) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//! validToken --> the stored token for plaintext if scope matches and it hasn't expired (caller holds the lock)
+func (db *MemoryDB) validToken(scope string, tokenPlainText string) *tokens.Token {
+	token := db.tokens[string(tokens.Hash(tokenPlainText))]
+	if token == nil || token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return nil
+	}
+	return token
+}
+
+//! deleteTokens --> every token match returns true for (caller holds the lock)
+func (db *MemoryDB) deleteTokens(match func(token *tokens.Token) bool) {
+	for hash, token := range db.tokens {
+		if match(token) {
+			delete(db.tokens, hash)
+		}
+	}
+}
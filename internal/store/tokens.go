@@ -1,7 +1,9 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"fem/internal/scopes"
 	"fem/internal/tokens"
 	"time"
 )
@@ -21,45 +23,186 @@ func NewPostgresTokenStore (db *sql.DB) *PostgresTokenStore {
 
 //! TokenStore interface --> contract for token operations
 type TokenStore interface {
-	Insert(token *tokens.Token) error //* saves token to database
-	CreateNewToken(userID int,ttl time.Duration,scope string) (*tokens.Token, error) //* generates and saves new token
-	DeleteAllTokensForUser(userID int,scope string) error //* cleanup old tokens for user
+	Insert(ctx context.Context,token *tokens.Token) error //* saves token to database
+	CreateNewToken(ctx context.Context,userID int,ttl time.Duration,scope string) (*tokens.Token, error) //* generates and saves new token
+	DeleteAllTokensForUser(ctx context.Context,userID int,scope string) error //* cleanup old tokens for user
+	DeleteOtherTokensForUser(ctx context.Context,userID int,scope string,keepPlainText string) error //* e.g. password change --> every other device is logged out
+	CreateOAuthToken(ctx context.Context,userID int,clientID string,granted []string,ttl time.Duration) (*tokens.Token, error) //* access token for a third-party app
+	GetTokenGrant(ctx context.Context,scope string,tokenPlainText string) (*User,*tokens.Token,error) //* user + client/scopes behind a token, nil if invalid
+	DeleteClientToken(ctx context.Context,clientID string,tokenPlainText string) error //* oauth revocation, only for the client's own tokens
+	DeleteToken(ctx context.Context,scope string,tokenPlainText string) error //* logout, no error if it is already gone
+	ConsumeToken(ctx context.Context,scope string,tokenPlainText string) (*User,error) //* single-use tokens, deletes it --> nil on second use
+	HasTokenExpiringAfter(ctx context.Context,userID int,scope string,after time.Time) (bool,error) //* is a token issued recently still around
 }
 
 //! CreateNewToken --> generates random token and saves it to database
-func (t *PostgresTokenStore) CreateNewToken(userID int,ttl time.Duration,scope string) (*tokens.Token,error) {
+func (t *PostgresTokenStore) CreateNewToken(ctx context.Context,userID int,ttl time.Duration,scope string) (*tokens.Token,error) {
 	//* generate cryptographically secure random token
 	token,err := tokens.GenerateToken(userID,ttl,scope)
 	if err != nil {
 		return nil,err
 	}
 	//* save token hash to database
-	err = t.Insert(token)
+	err = t.Insert(ctx,token)
 	return token,err //* return plaintext token to send to client
 }
 
 //! Insert --> saves token hash to database (NOT plaintext for security)
-func (t *PostgresTokenStore) Insert(token *tokens.Token) error {
+func (t *PostgresTokenStore) Insert(ctx context.Context,token *tokens.Token) error {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
 	query := `
-		insert into tokens (hash,user_id,expiry,scope)
-		values ($1,$2,$3,$4)
+		insert into tokens (hash,user_id,expiry,scope,client_id,scopes)
+		values ($1,$2,$3,$4,$5,$6)
 	`
+	//* first-party tokens have no client and no scope restriction --> NULL columns
+	var clientID,grantedScopes interface{}
+	if token.ClientID != "" {
+		clientID = token.ClientID
+		grantedScopes = scopes.Join(token.GrantedScopes)
+	}
 	//* execute query with parameterized values (prevents SQL injection)
-	_,err := t.db.Exec(query,token.Hash,token.UserID,token.Expiry,token.Scope)
+	_,err := execContext(ctx,t.db,query,token.Hash,token.UserID,token.Expiry,token.Scope,clientID,grantedScopes)
 		return err
 
 }
 
 //! DeleteAllTokensForUser --> removes all tokens for specific user and scope
 //? useful when user logs out or password changes
-func (t *PostgresTokenStore) DeleteAllTokensForUser(userId int,scope string) error {
+func (t *PostgresTokenStore) DeleteAllTokensForUser(ctx context.Context,userId int,scope string) error {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
 	query := `
 		delete from tokens
 		where user_id=$1 and scope=$2
 	`
 
 	//* removes all matching tokens from database
-	_,err := t.db.Exec(query,userId,scope)
+	_,err := execContext(ctx,t.db,query,userId,scope)
+	return err
+}
+
+//! DeleteOtherTokensForUser --> like DeleteAllTokensForUser but spares the token of the current request
+func (t *PostgresTokenStore) DeleteOtherTokensForUser(ctx context.Context,userId int,scope string,keepPlainText string) error {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+		delete from tokens
+		where user_id=$1 and scope=$2 and hash<>$3
+	`
+	_,err := execContext(ctx,t.db,query,userId,scope,tokens.Hash(keepPlainText))
 	return err
 }
 
+
+//! CreateOAuthToken --> like CreateNewToken but bound to a client and a set of scopes
+func (t *PostgresTokenStore) CreateOAuthToken(ctx context.Context,userID int,clientID string,granted []string,ttl time.Duration) (*tokens.Token,error) {
+	token,err := tokens.GenerateToken(userID,ttl,tokens.ScopeOAuthAccess)
+	if err != nil {
+		return nil,err
+	}
+	token.ClientID = clientID
+	token.GrantedScopes = granted
+
+	err = t.Insert(ctx,token)
+	return token,err
+}
+
+//! GetTokenGrant --> resolves a token to its user plus the client/scopes it was issued with
+func (t *PostgresTokenStore) GetTokenGrant(ctx context.Context,scope string,tokenPlainText string) (*User,*tokens.Token,error) {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+	 SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.role, u.suspended_at, u.created_at, u.updated_at,
+	        t.expiry, t.scope, COALESCE(t.client_id, ''), COALESCE(t.scopes, '')
+	 FROM users u
+	 INNER JOIN tokens t ON t.user_id = u.id
+	 WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3
+	`
+	user := &User{PasswordHash: password{}}
+	token := &tokens.Token{Hash: tokens.Hash(tokenPlainText)}
+	var grantedScopes string
+
+	err := queryRowContext(ctx,t.db,query,token.Hash,scope,time.Now()).Scan(
+		&user.ID,&user.Username,&user.Email,&user.PasswordHash.hash,&user.Bio,&user.Role,&user.SuspendedAt,&user.CreatedAt,&user.UpdatedAt,
+		&token.Expiry,&token.Scope,&token.ClientID,&grantedScopes,
+	)
+	if err == sql.ErrNoRows {
+		return nil,nil,nil
+	}
+	if err != nil {
+		return nil,nil,err
+	}
+
+	token.UserID = user.ID
+	if token.ClientID != "" {
+		token.GrantedScopes = scopes.Split(grantedScopes)
+	}
+	return user,token,nil
+}
+
+//! DeleteClientToken --> client_id in the WHERE clause so a client can't revoke other clients' tokens
+func (t *PostgresTokenStore) DeleteClientToken(ctx context.Context,clientID string,tokenPlainText string) error {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+		delete from tokens
+		where hash=$1 and client_id=$2
+	`
+	_,err := execContext(ctx,t.db,query,tokens.Hash(tokenPlainText),clientID)
+	return err
+}
+
+//! DeleteToken --> removes a single token (logout of one device)
+func (t *PostgresTokenStore) DeleteToken(ctx context.Context,scope string,tokenPlainText string) error {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+		delete from tokens
+		where hash=$1 and scope=$2
+	`
+	_,err := execContext(ctx,t.db,query,tokens.Hash(tokenPlainText),scope)
+	return err
+}
+
+//! ConsumeToken --> DELETE ... RETURNING so two requests can't both redeem the same token
+func (t *PostgresTokenStore) ConsumeToken(ctx context.Context,scope string,tokenPlainText string) (*User,error) {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+	 WITH consumed AS (
+	   DELETE FROM tokens
+	   WHERE hash = $1 AND scope = $2 AND expiry > $3
+	   RETURNING user_id
+	 )
+	 SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.role, u.suspended_at, u.created_at, u.updated_at
+	 FROM users u
+	 INNER JOIN consumed c ON c.user_id = u.id
+	`
+	user := &User{PasswordHash: password{}}
+	err := queryRowContext(ctx,t.db,query,tokens.Hash(tokenPlainText),scope,time.Now()).Scan(
+		&user.ID,&user.Username,&user.Email,&user.PasswordHash.hash,&user.Bio,&user.Role,&user.SuspendedAt,&user.CreatedAt,&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil,nil
+	}
+	if err != nil {
+		return nil,err
+	}
+	return user,nil
+}
+
+//! HasTokenExpiringAfter --> tokens don't store their creation time, a late expiry means a recent issue
+func (t *PostgresTokenStore) HasTokenExpiringAfter(ctx context.Context,userID int,scope string,after time.Time) (bool,error) {
+	ctx,cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+		select exists (
+			select 1 from tokens
+			where user_id=$1 and scope=$2 and expiry > $3
+		)
+	`
+	var exists bool
+	err := queryRowContext(ctx,t.db,query,userID,scope,after).Scan(&exists)
+	return exists,err
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+//! TOTPCredential --> a user's authenticator app secret and its enrollment state
+type TOTPCredential struct {
+	UserID       int
+	Secret       string     //* base32 shared secret
+	ConfirmedAt  *time.Time //* nil until the user proves the app is set up
+	LastUsedStep int64      //* last accepted time step --> blocks code replays
+}
+
+//! IsConfirmed --> 2FA is only enforced on login once enrollment was confirmed
+func (c *TOTPCredential) IsConfirmed() bool {
+	return c != nil && c.ConfirmedAt != nil
+}
+
+type PostgresTOTPStore struct {
+	db *sql.DB
+}
+
+//! NewPostgresTOTPStore --> constructor that creates totp store instance
+func NewPostgresTOTPStore(db *sql.DB) *PostgresTOTPStore {
+	return &PostgresTOTPStore{db: db}
+}
+
+//! TOTPStore interface --> contract for two-factor authentication data
+type TOTPStore interface {
+	GetTOTP(ctx context.Context, userID int) (*TOTPCredential, error)                //* nil when user never enrolled
+	SaveTOTPSecret(ctx context.Context, userID int, secret string) error             //* starts (or restarts) an enrollment
+	ConfirmTOTP(ctx context.Context, userID int) error                               //* marks enrollment as done
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)           //* false if step was already used
+	DeleteTOTP(ctx context.Context, userID int) error                                //* disables 2FA and drops recovery codes
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes [][]byte) error //* invalidates old codes
+	UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error)  //* single use --> false if unknown or spent
+}
+
+func (s *PostgresTOTPStore) GetTOTP(ctx context.Context, userID int) (*TOTPCredential, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	credential := &TOTPCredential{}
+	query := `
+  SELECT user_id, secret, confirmed_at, last_used_step
+  FROM user_totp
+  WHERE user_id = $1
+  `
+	err := queryRowContext(ctx, s.db, query, userID).Scan(&credential.UserID, &credential.Secret, &credential.ConfirmedAt, &credential.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+//! SaveTOTPSecret --> new secret always starts unconfirmed
+//? re-enrolling while already confirmed is rejected by the handler, not here
+func (s *PostgresTOTPStore) SaveTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  INSERT INTO user_totp (user_id, secret)
+  VALUES ($1, $2)
+  ON CONFLICT (user_id) DO UPDATE
+  SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+  `
+	_, err := execContext(ctx, s.db, query, userID, secret)
+	return err
+}
+
+//! ConfirmTOTP --> last_used_step was already moved by UseTOTPStep when the confirming code was checked
+func (s *PostgresTOTPStore) ConfirmTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE user_totp
+  SET confirmed_at = CURRENT_TIMESTAMP
+  WHERE user_id = $1
+  `
+	result, err := execContext(ctx, s.db, query, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//! UseTOTPStep --> atomically moves last_used_step forward
+//? a code can be valid for ~90s (skew window) so without this it could be replayed
+func (s *PostgresTOTPStore) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE user_totp
+  SET last_used_step = $2
+  WHERE user_id = $1 AND last_used_step < $2
+  `
+	result, err := execContext(ctx, s.db, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func (s *PostgresTOTPStore) DeleteTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = execContext(ctx, tx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = execContext(ctx, tx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresTOTPStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes [][]byte) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	//* wiping old codes first --> a new set always replaces the previous one
+	_, err = execContext(ctx, tx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = execContext(ctx, tx, `INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresTOTPStore) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE totp_recovery_codes
+  SET used_at = CURRENT_TIMESTAMP
+  WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+  `
+	result, err := execContext(ctx, s.db, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
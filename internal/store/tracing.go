@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//! tracerName --> instrumentation scope of the query spans
+const tracerName = "fem/internal/store"
+
+//! queryer --> what *sql.DB and *sql.Tx have in common, the traced helpers work inside transactions too
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+//* collectionPattern --> first table after FROM / INTO / UPDATE, good enough for the queries in this package
+var collectionPattern = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE)\s+([a-z_][a-z0-9_]*)`)
+
+//! startQuerySpan --> child span of whatever is in ctx (usually the request span), named "SELECT workouts"
+//? otel.Tracer on every call --> picks up the provider set in app.NewApplication or by a test
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation, collection := describeQuery(query)
+	name := operation
+	if collection != "" {
+		name += " " + collection
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system.name", "postgresql"),
+		attribute.String("db.operation.name", operation),
+		attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+	}
+	if collection != "" {
+		attrs = append(attrs, attribute.String("db.collection.name", collection))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+//! describeQuery --> ("SELECT", "workouts") from the SQL text
+func describeQuery(query string) (string, string) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "QUERY", ""
+	}
+	operation := strings.ToUpper(fields[0])
+
+	collection := ""
+	if match := collectionPattern.FindStringSubmatch(query); match != nil {
+		collection = strings.ToLower(match[1])
+	}
+	return operation, collection
+}
+
+//! endQuerySpan --> marks the span failed unless err is nil or just "no rows" (database/sql or pgx)
+func endQuerySpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//! execContext --> q.ExecContext inside a query span
+func execContext(ctx context.Context, q queryer, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	result, err := q.ExecContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return result, err
+}
+
+//! queryContext --> q.QueryContext inside a query span
+//? the span covers the round trip, not the time the caller spends reading rows
+func queryContext(ctx context.Context, q queryer, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	rows, err := q.QueryContext(ctx, query, args...)
+	endQuerySpan(span, err)
+	return rows, err
+}
+
+//! queryRowContext --> q.QueryRowContext inside a query span
+//? row.Err is the query error, sql.ErrNoRows only shows up in Scan --> "not found" never marks the span failed
+func queryRowContext(ctx context.Context, q queryer, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	row := q.QueryRowContext(ctx, query, args...)
+	endQuerySpan(span, row.Err())
+	return row
+}
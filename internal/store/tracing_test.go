@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// * fakeQueryer --> answers Exec/Query with a fixed error, no database needed
+type fakeQueryer struct {
+	err error
+}
+
+func (f fakeQueryer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return nil, f.err
+}
+
+func (f fakeQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, f.err
+}
+
+func (f fakeQueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	panic("not used")
+}
+
+// ! TestDescribeQuery --> span names come from the SQL operation and the first table
+func TestDescribeQuery(t *testing.T) {
+	tests := []struct {
+		query      string
+		operation  string
+		collection string
+	}{
+		{"\n  SELECT id, user_id FROM workouts WHERE id = $1", "SELECT", "workouts"},
+		{"INSERT INTO workout_entries (workout_id) VALUES ($1)", "INSERT", "workout_entries"},
+		{"update users SET role = $1 WHERE id = $2", "UPDATE", "users"},
+		{"DELETE FROM tokens WHERE hash = $1", "DELETE", "tokens"},
+		{"SELECT EXISTS (SELECT 1 FROM tokens WHERE user_id = $1)", "SELECT", "tokens"},
+		{selectWorkoutsQuery + "WHERE w.id = $1", "SELECT", "workouts"}, // * the entries subquery comes after FROM workouts
+		{"SELECT 1", "SELECT", ""},
+	}
+
+	for _, tt := range tests {
+		operation, collection := describeQuery(tt.query)
+		assert.Equal(t, tt.operation, operation, tt.query)
+		assert.Equal(t, tt.collection, collection, tt.query)
+	}
+}
+
+// ! TestQuerySpans --> every query is a client span below the caller's span, failures are recorded
+func TestQuerySpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GET /workouts/{id}")
+
+	_, err := execContext(ctx, fakeQueryer{}, "DELETE FROM workouts WHERE id = $1", 1)
+	require.NoError(t, err)
+
+	broken := errors.New("connection reset")
+	_, err = queryContext(ctx, fakeQueryer{err: broken}, "SELECT id FROM workout_entries WHERE workout_id = $1", 1)
+	assert.ErrorIs(t, err, broken)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	deleted, selected := spans[0], spans[1]
+
+	assert.Equal(t, "DELETE workouts", deleted.Name)
+	assert.Equal(t, parent.SpanContext().SpanID(), deleted.Parent.SpanID())
+	assert.Equal(t, codes.Unset, deleted.Status.Code)
+
+	attrs := map[string]string{}
+	for _, kv := range deleted.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsString()
+	}
+	assert.Equal(t, "postgresql", attrs["db.system.name"])
+	assert.Equal(t, "DELETE", attrs["db.operation.name"])
+	assert.Equal(t, "workouts", attrs["db.collection.name"])
+	assert.Equal(t, "DELETE FROM workouts WHERE id = $1", attrs["db.query.text"])
+
+	assert.Equal(t, "SELECT workout_entries", selected.Name)
+	assert.Equal(t, codes.Error, selected.Status.Code)
+	assert.Equal(t, "connection reset", selected.Status.Description)
+}
@@ -1,43 +1,55 @@
 package store
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
-	"errors"
+	"fem/internal/passhash"
+	"fem/internal/tokens"
+	"fem/internal/validator"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
-	_ "golang.org/x/crypto/bcrypt"
 )
 
+//! passwordHasher --> algorithm behind password.Set/Matches (Argon2id, bcrypt still verified)
+var passwordHasher = passhash.Default()
+
+//! SetPasswordHasher --> app.go installs the configured parameters before serving requests
+func SetPasswordHasher(hasher *passhash.Manager) {
+	passwordHasher = hasher
+}
+
 // types declaration
 type password struct {
 	plaintText *string
 	hash       []byte
+	rehash     bool //* set by Matches when the hash was made with outdated parameters
 }
 
 // * password hashing func to be exported for use in user validations
 func ( p *password) Set(plainTextPass string)error {
-	hash,err := bcrypt.GenerateFromPassword([]byte(plainTextPass),12) // takes what to hash, hashingCostUnits
+	hash,err := passwordHasher.Hash(plainTextPass) // PHC string, algorithm + parameters + salt travel with the hash
 	if err != nil {
 		return err
 	}
 	p.plaintText = &plainTextPass
-	p.hash = hash
+	p.hash = []byte(hash)
+	p.rehash = false
 	return nil
 } 
 
+//! Matches --> false,nil for a wrong password; errors mean the stored hash itself is broken
 func (p *password) Matches (plainTextPass string) (bool,error) {
-	err := bcrypt.CompareHashAndPassword(p.hash,[]byte(plainTextPass)) // passing in hashed pass n normal inputted
+	match,rehash,err := passwordHasher.Verify(plainTextPass,string(p.hash))
 	if err != nil {
-		switch {
-		case errors.Is(err,bcrypt.ErrMismatchedHashAndPassword) :
-			return false,nil
-			default :
-			return false,nil
-		}
+		return false,err
 	}
-	return true,nil
+	p.rehash = rehash
+	return match,nil
+}
+
+//! NeedsRehash --> true after a successful Matches on a hash that isn't up to the configured parameters
+func (p *password) NeedsRehash() bool {
+	return p.rehash
 }
 
 type User struct { // LOGGED IN USER
@@ -46,10 +58,44 @@ type User struct { // LOGGED IN USER
 	Email        string    `json:"email"`
 	PasswordHash password  `json:"-"`
 	Bio          string    `json:"bio"`
+	Role         string    `json:"role"`
+	SuspendedAt  *time.Time `json:"suspended_at,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
+//! roles --> every user has exactly one, checked through internal/rbac permissions
+const (
+	RoleUser  = "user"
+	RoleCoach = "coach"
+	RoleAdmin = "admin"
+)
+
+//! ValidRole --> guards role changes before they hit the valid_user_role CHECK
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleCoach || role == RoleAdmin
+}
+
+//! ValidateUsername --> shared by registration and profile updates, 50 is the column size
+func ValidateUsername(v *validator.Validator, username string) {
+	if v.Check(validator.NotBlank(username), "username", "username is required") {
+		v.Check(validator.MaxChars(username, 50), "username", "username cannot be longer than 50 characters")
+	}
+}
+
+//! ValidateEmail --> format only, GetUserByEmail/the unique constraint decide whether it is free
+func ValidateEmail(v *validator.Validator, email string) {
+	if v.Check(validator.NotBlank(email), "email", "email is required") {
+		v.Check(validator.MaxChars(email, 255), "email", "email cannot be longer than 255 characters")
+		v.Check(validator.Matches(email, validator.EmailRX), "email", "email must be a valid email address")
+	}
+}
+
+//! IsSuspended --> suspended users can't log in and their tokens stop working
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
 //* Determines which user is coming -- Auth purpose
 var AnonymousUser = &User{} //* adding empty User type struct saved to this variable
 func (u *User) IsAnonymousUser() bool {
@@ -70,45 +116,66 @@ func NewPostUserStore(db *sql.DB) *PostgresUserStore {
 
 // interface --> let us connect methods to parent type to access these
 type UserStore interface {
-	CreateUser(*User) error
-	GetUserByUsername(username string) (*User,error)
-	UpdateUser(*User) error
-	GetUserToken(scope string,tokenPlainText string) (*User, error)
+	CreateUser(ctx context.Context, user *User) error
+	GetUserByUsername(ctx context.Context, username string) (*User,error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error) //* case-insensitive, nil when nobody uses the address
+	UpdateUser(ctx context.Context, user *User) error
+	GetUserToken(ctx context.Context, scope string,tokenPlainText string) (*User, error)
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	ListUsers(ctx context.Context, limit int, offset int) ([]*User, int, error) //* page of users + total count
+	SetUserSuspended(ctx context.Context, id int64, suspended bool) error
+	SetUserRole(ctx context.Context, id int64, role string) error
+	DeleteUser(ctx context.Context, id int64) error //* cascades to workouts, tokens, keys ...
+	RehashPassword(ctx context.Context, user *User, plaintext string) error //* upgrades an outdated hash after a successful login
+	UpdatePassword(ctx context.Context, user *User) error //* stores the hash set with user.PasswordHash.Set
+	CreateEmailChange(ctx context.Context, userID int, newEmail string, ttl time.Duration) (*tokens.Token, error) //* replaces any pending change, token goes to the new address
+	ConfirmEmailChange(ctx context.Context, tokenPlainText string) (*User, error) //* applies the change once --> nil when expired/invalid, ErrDuplicate if someone got the address first
  }
 
 //! CREATEUSER METHOD -  directly access type PUsrStore
-func ( s *PostgresUserStore) CreateUser(user *User) error {
+func ( s *PostgresUserStore) CreateUser(ctx context.Context, user *User) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	//* new accounts are plain users unless the caller decided otherwise
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
 	query := `
-  INSERT INTO users (username, email, password_hash, bio)
-  VALUES ($1, $2, $3, $4)
+  INSERT INTO users (username, email, password_hash, bio, role)
+  VALUES ($1, $2, $3, $4, $5)
   RETURNING id, created_at, updated_at
   `
 
-	err := s.db.QueryRow(query, user.Username, user.Email, user.PasswordHash.hash, user.Bio).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := queryRowContext(ctx, s.db, query, user.Username, user.Email, user.PasswordHash.hash, user.Bio, user.Role).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	return nil
 }
 
-func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
+func (s *PostgresUserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	user := &User{
 		PasswordHash: password{},
 	}
 
 	query := `
-  SELECT id, username, email, password_hash, bio, created_at, updated_at
+  SELECT id, username, email, password_hash, bio, role, suspended_at, created_at, updated_at
   FROM users
   WHERE username = $1
   `
 
-	err := s.db.QueryRow(query, username).Scan(
+	err := queryRowContext(ctx, s.db, query, username).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash.hash,
 		&user.Bio,
+		&user.Role,
+		&user.SuspendedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -124,7 +191,9 @@ func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
-func (s *PostgresUserStore) UpdateUser(user *User) error {
+func (s *PostgresUserStore) UpdateUser(ctx context.Context, user *User) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	query := `
   UPDATE users
   SET username = $1, email = $2, bio = $3, updated_at = CURRENT_TIMESTAMP
@@ -132,9 +201,9 @@ func (s *PostgresUserStore) UpdateUser(user *User) error {
   RETURNING updated_at
   `
 
-	result, err := s.db.Exec(query, user.Username, user.Email, user.Bio, user.ID)
+	result, err := execContext(ctx, s.db, query, user.Username, user.Email, user.Bio, user.ID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -150,11 +219,13 @@ func (s *PostgresUserStore) UpdateUser(user *User) error {
 }
 
 // !auth tokenzation
-func (s *PostgresUserStore) GetUserToken(scope string,plaintextpassword string) (*User,error) {
+func (s *PostgresUserStore) GetUserToken(ctx context.Context, scope string,plaintextpassword string) (*User,error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	tokenHash := sha256.Sum256([]byte(plaintextpassword)) //* get hashed pass using sha256 salt
 
 	query := `
-	 Select u.id, u.username, u.email,u.password_hash, u.bio, u.created_at, u.updated_at 
+	 Select u.id, u.username, u.email,u.password_hash, u.bio, u.role, u.suspended_at, u.created_at, u.updated_at 
 	 from users u
 	 INNER JOIN tokens t
 	 ON
@@ -167,13 +238,15 @@ func (s *PostgresUserStore) GetUserToken(scope string,plaintextpassword string)
 		PasswordHash: password{},
 	}
 
-	err := s.db.QueryRow(query,tokenHash[:],scope,time.Now()).Scan(
+	err := queryRowContext(ctx, s.db, query,tokenHash[:],scope,time.Now()).Scan(
 		// scaaning values from this query --> accessing n implementing those
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash.hash,
 		&user.Bio,
+		&user.Role,
+		&user.SuspendedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -187,4 +260,269 @@ func (s *PostgresUserStore) GetUserToken(scope string,plaintextpassword string)
 	}
 
 	return user,nil
-}
\ No newline at end of file
+}
+
+//! GetUserByID --> nil,nil when no user has this id
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	user := &User{
+		PasswordHash: password{},
+	}
+
+	query := `
+  SELECT id, username, email, password_hash, bio, role, suspended_at, created_at, updated_at
+  FROM users
+  WHERE id = $1
+  `
+
+	err := queryRowContext(ctx, s.db, query, id).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+//! GetUserByEmail --> addresses are compared case-insensitively (Ayush@x.com == ayush@x.com)
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	user := &User{
+		PasswordHash: password{},
+	}
+
+	query := `
+  SELECT id, username, email, password_hash, bio, role, suspended_at, created_at, updated_at
+  FROM users
+  WHERE LOWER(email) = LOWER($1)
+  `
+
+	err := queryRowContext(ctx, s.db, query, email).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+//! ListUsers --> one page ordered by id, total is the count across all pages
+func (s *PostgresUserStore) ListUsers(ctx context.Context, limit int, offset int) ([]*User, int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  SELECT id, username, email, bio, role, suspended_at, created_at, updated_at, COUNT(*) OVER()
+  FROM users
+  ORDER BY id
+  LIMIT $1 OFFSET $2
+  `
+
+	rows, err := queryContext(ctx, s.db, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	total := 0
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.ID, &user.Username, &user.Email, &user.Bio, &user.Role, &user.SuspendedAt, &user.CreatedAt, &user.UpdatedAt, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	//? page past the end --> no row carries the window count, ask separately
+	if len(users) == 0 && offset > 0 {
+		err = queryRowContext(ctx, s.db, `SELECT COUNT(*) FROM users`).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return users, total, nil
+}
+
+func (s *PostgresUserStore) SetUserSuspended(ctx context.Context, id int64, suspended bool) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE users
+  SET suspended_at = CASE WHEN $2 THEN COALESCE(suspended_at, CURRENT_TIMESTAMP) ELSE NULL END,
+      updated_at = CURRENT_TIMESTAMP
+  WHERE id = $1
+  `
+	return s.execAffectingOne(ctx, query, id, suspended)
+}
+
+func (s *PostgresUserStore) SetUserRole(ctx context.Context, id int64, role string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE users
+  SET role = $2, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $1
+  `
+	return s.execAffectingOne(ctx, query, id, role)
+}
+
+//! DeleteUser --> ON DELETE CASCADE on every user_id foreign key removes the rest
+func (s *PostgresUserStore) DeleteUser(ctx context.Context, id int64) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return s.execAffectingOne(ctx, `DELETE FROM users WHERE id = $1`, id)
+}
+
+//! RehashPassword --> swaps the hash for one made with the current algorithm and parameters
+//? compares against the hash we verified, so a password change in between is never overwritten
+func (s *PostgresUserStore) RehashPassword(ctx context.Context, user *User, plaintext string) error {
+	fresh := password{}
+	err := fresh.Set(plaintext)
+	if err != nil {
+		return err
+	}
+
+	//* timeout starts after hashing, argon2 time must not eat into the query budget
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	_, err = execContext(ctx, s.db, `UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`, fresh.hash, user.ID, user.PasswordHash.hash)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = fresh
+	return nil
+}
+
+//! UpdatePassword --> password change by the user, the caller already checked the old one
+func (s *PostgresUserStore) UpdatePassword(ctx context.Context, user *User) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	query := `
+  UPDATE users
+  SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $2
+  `
+	return s.execAffectingOne(ctx, query, user.PasswordHash.hash, user.ID)
+}
+
+//! CreateEmailChange --> remembers the new address until its owner clicks the emailed link
+//? one pending change per user, asking again invalidates the previous link
+func (s *PostgresUserStore) CreateEmailChange(ctx context.Context, userID int, newEmail string, ttl time.Duration) (*tokens.Token, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	token, err := tokens.GenerateToken(userID, ttl, tokens.ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+  INSERT INTO email_changes (user_id, hash, new_email, expiry)
+  VALUES ($1, $2, $3, $4)
+  ON CONFLICT (user_id) DO UPDATE
+  SET hash = EXCLUDED.hash, new_email = EXCLUDED.new_email, expiry = EXCLUDED.expiry
+  `
+	_, err = execContext(ctx, s.db, query, userID, token.Hash, newEmail, token.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+//! ConfirmEmailChange --> deletes the pending change and moves the address over in one transaction
+func (s *PostgresUserStore) ConfirmEmailChange(ctx context.Context, tokenPlainText string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var newEmail string
+	err = queryRowContext(ctx, tx, `DELETE FROM email_changes WHERE hash = $1 AND expiry > $2 RETURNING user_id, new_email`, tokens.Hash(tokenPlainText), time.Now()).Scan(&userID, &newEmail)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//? users_email_lower_key would reject a taken address too, checking first keeps the pending change for a clean error
+	query := `
+  UPDATE users
+  SET email = $1, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2)
+  RETURNING id, username, email, password_hash, bio, role, suspended_at, created_at, updated_at
+  `
+	user := &User{PasswordHash: password{}}
+	err = queryRowContext(ctx, tx, query, newEmail, userID).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.Role,
+		&user.SuspendedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, newConstraintError(ErrDuplicate, "users_email_key")
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return user, tx.Commit()
+}
+
+//! execAffectingOne --> runs a statement and turns "no row matched" into sql.ErrNoRows
+func (s *PostgresUserStore) execAffectingOne(ctx context.Context, query string, args ...interface{}) error {
+	result, err := execContext(ctx, s.db, query, args...)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fem/internal/tokens"
+	"sort"
+	"strings"
+	"time"
+)
+
+//! MemoryUserStore --> UserStore on a MemoryDB
+type MemoryUserStore struct {
+	db *MemoryDB
+}
+
+//! NewMemoryUserStore --> constructor, pass the same MemoryDB to the workout and token stores
+func NewMemoryUserStore(db *MemoryDB) *MemoryUserStore {
+	return &MemoryUserStore{db: db}
+}
+
+func (m *MemoryUserStore) CreateUser(ctx context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	//* new accounts are plain users unless the caller decided otherwise
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	err := m.checkUnique(user)
+	if err != nil {
+		return err
+	}
+	if !ValidRole(user.Role) {
+		return newConstraintError(ErrInvalidValue, "valid_user_role")
+	}
+
+	m.db.lastUserID++
+	user.ID = m.db.lastUserID
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	m.db.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (m *MemoryUserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	return m.find(func(user *User) bool { return user.Username == username }), nil
+}
+
+//! GetUserByEmail --> case-insensitive like the LOWER(email) lookup
+func (m *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	return m.find(func(user *User) bool { return strings.EqualFold(user.Email, email) }), nil
+}
+
+//! UpdateUser --> username, email and bio, sql.ErrNoRows for unknown ids
+func (m *MemoryUserStore) UpdateUser(ctx context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored := m.db.users[user.ID]
+	if stored == nil {
+		return sql.ErrNoRows
+	}
+	err := m.checkUnique(user)
+	if err != nil {
+		return err
+	}
+
+	stored.Username = user.Username
+	stored.Email = user.Email
+	stored.Bio = user.Bio
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+//! GetUserToken --> owner of an unexpired token with this scope, nil otherwise
+func (m *MemoryUserStore) GetUserToken(ctx context.Context, scope string, tokenPlainText string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	token := m.db.validToken(scope, tokenPlainText)
+	if token == nil {
+		return nil, nil
+	}
+	return copyUser(m.db.users[token.UserID]), nil
+}
+
+//! GetUserByID --> nil,nil when no user has this id
+func (m *MemoryUserStore) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	user := m.db.users[int(id)]
+	if user == nil {
+		return nil, nil
+	}
+	return copyUser(user), nil
+}
+
+//! ListUsers --> one page ordered by id, total across all pages, no password hashes (the SELECT skips them)
+func (m *MemoryUserStore) ListUsers(ctx context.Context, limit int, offset int) ([]*User, int, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	ids := make([]int, 0, len(m.db.users))
+	for id := range m.db.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	users := []*User{}
+	for i := offset; i < len(ids) && len(users) < limit; i++ {
+		user := copyUser(m.db.users[ids[i]])
+		user.PasswordHash = password{}
+		users = append(users, user)
+	}
+	return users, len(ids), nil
+}
+
+//! SetUserSuspended --> keeps the first suspension time when suspended twice
+func (m *MemoryUserStore) SetUserSuspended(ctx context.Context, id int64, suspended bool) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	user := m.db.users[int(id)]
+	if user == nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	switch {
+	case !suspended:
+		user.SuspendedAt = nil
+	case user.SuspendedAt == nil:
+		user.SuspendedAt = &now
+	}
+	user.UpdatedAt = now
+	return nil
+}
+
+func (m *MemoryUserStore) SetUserRole(ctx context.Context, id int64, role string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	user := m.db.users[int(id)]
+	if user == nil {
+		return sql.ErrNoRows
+	}
+	if !ValidRole(role) {
+		return newConstraintError(ErrInvalidValue, "valid_user_role")
+	}
+	user.Role = role
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+//! DeleteUser --> cascades to workouts, tokens and pending email changes
+func (m *MemoryUserStore) DeleteUser(ctx context.Context, id int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if m.db.users[int(id)] == nil {
+		return sql.ErrNoRows
+	}
+	m.db.deleteUser(int(id))
+	return nil
+}
+
+//! RehashPassword --> only replaces the hash that was verified, a password change in between wins
+func (m *MemoryUserStore) RehashPassword(ctx context.Context, user *User, plaintext string) error {
+	fresh := password{}
+	err := fresh.Set(plaintext)
+	if err != nil {
+		return err
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	stored := m.db.users[user.ID]
+	if stored != nil && string(stored.PasswordHash.hash) == string(user.PasswordHash.hash) {
+		stored.PasswordHash = password{hash: fresh.hash}
+	}
+	user.PasswordHash = fresh
+	return nil
+}
+
+//! UpdatePassword --> stores the hash set with user.PasswordHash.Set
+func (m *MemoryUserStore) UpdatePassword(ctx context.Context, user *User) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored := m.db.users[user.ID]
+	if stored == nil {
+		return sql.ErrNoRows
+	}
+	stored.PasswordHash = password{hash: user.PasswordHash.hash}
+	stored.UpdatedAt = time.Now()
+	return nil
+}
+
+//! CreateEmailChange --> one pending change per user, asking again invalidates the previous link
+func (m *MemoryUserStore) CreateEmailChange(ctx context.Context, userID int, newEmail string, ttl time.Duration) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(userID, ttl, tokens.ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if m.db.users[userID] == nil {
+		return nil, newConstraintError(ErrInvalidReference, "email_changes_user_id_fkey")
+	}
+	m.db.emailChanges[userID] = &memoryEmailChange{hash: string(token.Hash), newEmail: newEmail, expiry: token.Expiry.Round(time.Second)}
+	return token, nil
+}
+
+//! ConfirmEmailChange --> nil when expired/invalid, ErrDuplicate (change kept, like the rolled back transaction) if the address got taken
+func (m *MemoryUserStore) ConfirmEmailChange(ctx context.Context, tokenPlainText string) (*User, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	hash := string(tokens.Hash(tokenPlainText))
+	for userID, change := range m.db.emailChanges {
+		if change.hash != hash || !change.expiry.After(time.Now()) {
+			continue
+		}
+
+		taken := m.find(func(user *User) bool { return strings.EqualFold(user.Email, change.newEmail) && user.ID != userID })
+		if taken != nil {
+			return nil, newConstraintError(ErrDuplicate, "users_email_key")
+		}
+		delete(m.db.emailChanges, userID)
+		user := m.db.users[userID]
+		user.Email = change.newEmail
+		user.UpdatedAt = time.Now()
+		return copyUser(user), nil
+	}
+	return nil, nil
+}
+
+//! find --> first user matching, as a copy (caller holds the lock)
+func (m *MemoryUserStore) find(match func(user *User) bool) *User {
+	for _, user := range m.db.users {
+		if match(user) {
+			return copyUser(user)
+		}
+	}
+	return nil
+}
+
+//! checkUnique --> the UNIQUE constraints on username (case-sensitive) and email (plus users_email_lower_key)
+func (m *MemoryUserStore) checkUnique(user *User) error {
+	for _, other := range m.db.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if other.Username == user.Username {
+			return newConstraintError(ErrDuplicate, "users_username_key")
+		}
+		if other.Email == user.Email {
+			return newConstraintError(ErrDuplicate, "users_email_key")
+		}
+		if strings.EqualFold(other.Email, user.Email) {
+			return newConstraintError(ErrDuplicate, "users_email_lower_key")
+		}
+	}
+	return nil
+}
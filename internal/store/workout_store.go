@@ -1,11 +1,19 @@
 package store
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fem/internal/validator"
+	"fmt"
+	"strings"
+)
 
 // ? - main workout data structure
 type Workout struct {
 	ID              int            `json:"id"`
 	UserID          int            `json:"user_id"`
+	CreatedBy       int            `json:"created_by"` // * differs from UserID when a coach programmed it
 	Title           string         `json:"title"`
 	Description     string         `json:"description"`
 	DurationMinutes int            `json:"duration_minutes"`
@@ -25,6 +33,43 @@ type WorkoutEntry struct {
 	OrderIndex      int      `json:"order_index"`
 }
 
+// ! ValidateWorkout --> everything the handlers accept for a workout, so bad input is a 422 and never a failed CHECK
+func ValidateWorkout(v *validator.Validator, workout *Workout) {
+	v.Check(validator.NotBlank(workout.Title), "title", "title is required")
+	v.Check(validator.MaxChars(workout.Title, 255), "title", "title cannot be longer than 255 characters")
+	v.Check(validator.Between(workout.DurationMinutes, 0, 24*60), "duration_minutes", "duration_minutes must be between 0 and 1440")
+	v.Check(validator.Between(workout.CaloriesBurned, 0, 20000), "calories_burned", "calories_burned must be between 0 and 20000")
+
+	for i := range workout.Entries {
+		ValidateWorkoutEntry(v, i, &workout.Entries[i])
+	}
+}
+
+// ! ValidateWorkoutEntry --> one exercise, field names point into the entries list ("entries[0].sets")
+func ValidateWorkoutEntry(v *validator.Validator, index int, entry *WorkoutEntry) {
+	field := func(name string) string { return validator.Field("entries", index, name) }
+
+	v.Check(validator.NotBlank(entry.ExerciseName), field("exercise_name"), "exercise_name is required")
+	v.Check(validator.MaxChars(entry.ExerciseName, 255), field("exercise_name"), "exercise_name cannot be longer than 255 characters")
+	v.Check(validator.Between(entry.Sets, 1, 100), field("sets"), "sets must be between 1 and 100")
+	v.Check(entry.OrderIndex >= 0, field("order_index"), "order_index cannot be negative")
+
+	// ? same rule as the valid_workout_entry CHECK --> exactly one of reps and duration_seconds
+	if v.Check(entry.Reps != nil || entry.DurationSeconds != nil, field("reps"), "either reps or duration_seconds is required") {
+		v.Check(entry.Reps == nil || entry.DurationSeconds == nil, field("reps"), "reps and duration_seconds cannot both be set")
+	}
+	if entry.Reps != nil {
+		v.Check(validator.Between(*entry.Reps, 1, 1000), field("reps"), "reps must be between 1 and 1000")
+	}
+	if entry.DurationSeconds != nil {
+		v.Check(validator.Between(*entry.DurationSeconds, 1, 24*60*60), field("duration_seconds"), "duration_seconds must be between 1 and 86400")
+	}
+	// * DECIMAL(5, 2) column --> 999.99 is the largest weight postgres can store
+	if entry.Weight != nil {
+		v.Check(validator.Between(*entry.Weight, 0, 999.99), field("weight"), "weight must be between 0 and 999.99")
+	}
+}
+
 // * holds the db connection for workout operations
 type PostgresWorkoutStore struct {
 	db *sql.DB
@@ -38,46 +83,46 @@ func NewPostgresWorkoutStore(db *sql.DB) *PostgresWorkoutStore {
 
 //! collection of methods
 type WorkoutStore interface {
-	CreateWorkout(*Workout) (*Workout, error)
-	GetWorkoutByID(id int64) (*Workout, error)
-	UpdateWorkout(*Workout)  error
-	DeleteWorkout(id int64)  error
-	GetWorkoutOwner(id int64) (int,error)
+	CreateWorkout(ctx context.Context, workout *Workout) (*Workout, error)
+	GetWorkoutByID(ctx context.Context, id int64) (*Workout, error)
+	UpdateWorkout(ctx context.Context, workout *Workout)  error
+	DeleteWorkout(ctx context.Context, id int64)  error
+	GetWorkoutOwner(ctx context.Context, id int64) (int,error)
 }
 
-func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error) {
+func (pg *PostgresWorkoutStore) CreateWorkout(ctx context.Context, workout *Workout) (*Workout, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	
 	// ! starting a transaction so both workout & entries get saved together
-	tx, err := pg.db.Begin()
+	tx, err := pg.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback() // ? - rolls back if anything fails
 
+	// ? - creator defaults to the owner, coaches set it explicitly
+	if workout.CreatedBy == 0 {
+		workout.CreatedBy = workout.UserID
+	}
+
 	// * inserting main workout data first
 	query :=
 		`
-  INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned)
-  VALUES ($1, $2, $3, $4, $5)
+  INSERT INTO workouts (user_id, created_by, title, description, duration_minutes, calories_burned)
+  VALUES ($1, $2, $3, $4, $5, $6)
   RETURNING id 
   `
 
-	err = tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID)
+	err = queryRowContext(ctx, tx, query, workout.UserID, workout.CreatedBy, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
-	// ? - now looping through each exercise entry and saving them
-	for i := range workout.Entries {
-		query := `
-    INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-    RETURNING id
-    `
-		err = tx.QueryRow(query, workout.ID, workout.Entries[i].ExerciseName, workout.Entries[i].Sets, workout.Entries[i].Reps, workout.Entries[i].DurationSeconds, workout.Entries[i].Weight, workout.Entries[i].Notes, workout.Entries[i].OrderIndex).Scan(&workout.Entries[i].ID)
-		if err != nil {
-			return nil, err
-		}
+	// ? - all exercise entries in one multi-row INSERT --> one round trip however many there are
+	err = insertEntries(ctx, tx, workout.ID, workout.Entries)
+	if err != nil {
+		return nil, err
 	}
 
 	// ! commit the transaction - makes everything permanent
@@ -89,62 +134,61 @@ func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error
 	return workout, nil
 }
 
-func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
-	workout := &Workout{}
-	// * fetching main workout info by id
-	query := `
-  SELECT id, title, description, duration_minutes, calories_burned
-  FROM workouts
-  WHERE id = $1
-  `
-	err := pg.db.QueryRow(query, id).Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
-	if err == sql.ErrNoRows {
-		return nil, nil // ? - workout doesn't exist
-	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	// ? - now grabbing all exercise entries for this workout
-	entryQuery := `
-  SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
-  FROM workout_entries
-  WHERE workout_id = $1
-  ORDER BY order_index
+// ! selectWorkoutsQuery --> workouts with their entries in one query, entries come back as a JSON array
+// ? aggregated per workout in a LATERAL subquery instead of a plain JOIN --> one row per workout, WHERE / ORDER BY / LIMIT work on workouts as usual
+// ? json_agg is NULL (not []) for a workout without entries --> Entries stays nil
+const selectWorkoutsQuery = `
+  SELECT w.id, w.user_id, COALESCE(w.created_by, 0), w.title, w.description, w.duration_minutes, w.calories_burned, e.entries
+  FROM workouts w
+  LEFT JOIN LATERAL (
+    SELECT json_agg(json_build_object(
+      'id', id, 'exercise_name', exercise_name, 'sets', sets, 'reps', reps,
+      'duration_seconds', duration_seconds, 'weight', weight, 'notes', notes, 'order_index', order_index
+    ) ORDER BY order_index) AS entries
+    FROM workout_entries
+    WHERE workout_id = w.id
+  ) e ON true
   `
 
-	rows, err := pg.db.Query(entryQuery, id)
+// ! scanWorkout --> one row of selectWorkoutsQuery
+func scanWorkout(scan func(dest ...any) error) (*Workout, error) {
+	workout := &Workout{}
+	var entries []byte
+	err := scan(&workout.ID, &workout.UserID, &workout.CreatedBy, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned, &entries)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close() // ! important - close when done
 
-	// * looping through all the entries
-	for rows.Next() {
-		var entry WorkoutEntry
-		err = rows.Scan(
-			&entry.ID,
-			&entry.ExerciseName,
-			&entry.Sets,
-			&entry.Reps,
-			&entry.DurationSeconds,
-			&entry.Weight,
-			&entry.Notes,
-			&entry.OrderIndex,
-		)
+	if entries != nil {
+		// * json_build_object keys are the WorkoutEntry json tags
+		err = json.Unmarshal(entries, &workout.Entries)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("workout %d entries : %w", workout.ID, err)
 		}
-		workout.Entries = append(workout.Entries, entry) // ? - attaching entry to workout
 	}
+	return workout, nil
+}
+
+// ! GetWorkoutByID --> workout and its entries in a single round trip
+func (pg *PostgresWorkoutStore) GetWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 
+	workout, err := scanWorkout(queryRowContext(ctx, pg.db, selectWorkoutsQuery+"WHERE w.id = $1", id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil // ? - workout doesn't exist
+	}
+	if err != nil {
+		return nil, err
+	}
 	return workout, nil
 }
 
-func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
+func (pg *PostgresWorkoutStore) UpdateWorkout(ctx context.Context, workout *Workout) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	// ! transaction for updating both workout and its entries
-	tx, err := pg.db.Begin()
+	tx, err := pg.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -157,42 +201,109 @@ func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
   WHERE id = $5
   `
 
-	_, err = tx.Exec(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID)
+	_, err = execContext(ctx, tx, query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID)
 	if err != nil {
 		return err
 	}
 
 	// ? - wiping old entries first
-	_, err = tx.Exec("DELETE FROM workout_entries WHERE workout_id = $1", workout.ID)
+	_, err = execContext(ctx, tx, "DELETE FROM workout_entries WHERE workout_id = $1", workout.ID)
 	if err != nil {
 		return err
 	}
 
-	// ? - inserting fresh entries
-	for _, entry := range workout.Entries {
-		query := `
-    INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-    `
-		_, err = tx.Exec(query, workout.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex)
-		if err != nil {
-			return err
-		}
+	// ? - inserting fresh entries, again in one statement
+	err = insertEntries(ctx, tx, workout.ID, workout.Entries)
+	if err != nil {
+		return err
 	}
 
 	// ! commit to save all changes
 	return tx.Commit()
 }
 
+// ! maxEntriesPerInsert --> 9 parameters per entry, postgres accepts at most 65535 per statement
+const maxEntriesPerInsert = 1000
+
+// * reserveEntryIDsQuery --> one fresh id from the workout_entries sequence per requested row
+const reserveEntryIDsQuery = `SELECT nextval(pg_get_serial_sequence('workout_entries', 'id')) FROM generate_series(1, $1)`
+
+// ! insertEntries --> ids are reserved up front, then one multi-row INSERT per maxEntriesPerInsert entries
+// ? the INSERT carries each entry's id itself --> nothing depends on the order postgres returns rows in,
+// ? entries sharing an order_index (all 0 when a client leaves it out) can't swap ids
+func insertEntries(ctx context.Context, q queryer, workoutID int, entries []WorkoutEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	err := reserveEntryIDs(ctx, q, entries)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(entries); start += maxEntriesPerInsert {
+		chunk := entries[start:min(start+maxEntriesPerInsert, len(entries))]
+
+		var query strings.Builder
+		query.WriteString("INSERT INTO workout_entries (id, workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index) VALUES ")
+		args := make([]any, 0, len(chunk)*9)
+		for i, entry := range chunk {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9)
+			args = append(args, entry.ID, workoutID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex)
+		}
+
+		_, err = execContext(ctx, q, query.String(), args...)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+// ! reserveEntryIDs --> one nextval per entry in a single round trip, written into entries[i].ID
+// ? any id can go to any entry, so the order the ids come back in doesn't matter
+func reserveEntryIDs(ctx context.Context, q queryer, entries []WorkoutEntry) error {
+	rows, err := queryContext(ctx, q, reserveEntryIDsQuery, len(entries))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	reserved := 0
+	for rows.Next() {
+		if reserved == len(entries) {
+			return fmt.Errorf("insert entries : more ids than the %d requested", len(entries))
+		}
+		err = rows.Scan(&entries[reserved].ID)
+		if err != nil {
+			return err
+		}
+		reserved++
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if reserved != len(entries) {
+		return fmt.Errorf("insert entries : reserved %d ids for %d entries", reserved, len(entries))
+	}
+	return nil
+}
+
 //! DeleteWorkout --> removes workout and its entries (CASCADE handles entries)
-func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
+func (pg *PostgresWorkoutStore) DeleteWorkout(ctx context.Context, id int64) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	//* query for delete
 	query := `
 	DELETE FROM workouts
 	where id=$1
 	`
 
-	result,err := pg.db.Exec(query,id)
+	result,err := execContext(ctx, pg.db, query,id)
 	if err!= nil {
 		return err
 	}
@@ -214,7 +325,9 @@ func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
 
 //! GetWorkoutOwner --> returns user ID who owns the workout
 //? used for authorization checks before update/delete
-func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int,error) {
+func (pg *PostgresWorkoutStore) GetWorkoutOwner(ctx context.Context, workoutID int64) (int,error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 		var userID int
 		
 		query := `
@@ -224,7 +337,7 @@ func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int,error) {
 		`
 
 		//* fetch owner's user ID
-		err := pg.db.QueryRow(query,workoutID).Scan(&userID)
+		err := queryRowContext(ctx, pg.db, query,workoutID).Scan(&userID)
 		if err != nil {
 			return 0,err
 		}
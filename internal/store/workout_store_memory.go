@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+//! MemoryWorkoutStore --> WorkoutStore on a MemoryDB
+type MemoryWorkoutStore struct {
+	db *MemoryDB
+}
+
+//! NewMemoryWorkoutStore --> constructor, pass the same MemoryDB to the user and token stores
+func NewMemoryWorkoutStore(db *MemoryDB) *MemoryWorkoutStore {
+	return &MemoryWorkoutStore{db: db}
+}
+
+func (m *MemoryWorkoutStore) CreateWorkout(ctx context.Context, workout *Workout) (*Workout, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	//? creator defaults to the owner, coaches set it explicitly
+	if workout.CreatedBy == 0 {
+		workout.CreatedBy = workout.UserID
+	}
+	if m.db.users[workout.UserID] == nil {
+		return nil, newConstraintError(ErrInvalidReference, "workouts_user_id_fkey")
+	}
+	if m.db.users[workout.CreatedBy] == nil {
+		return nil, newConstraintError(ErrInvalidReference, "workouts_created_by_fkey")
+	}
+	err := checkEntries(workout.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	//* ids are only handed out once every check passed, the transaction would have rolled back otherwise
+	m.db.lastWorkoutID++
+	workout.ID = m.db.lastWorkoutID
+	m.assignEntryIDs(workout.Entries)
+	m.db.workouts[workout.ID] = copyWorkout(workout)
+	return workout, nil
+}
+
+//! GetWorkoutByID --> nil,nil for unknown ids, entries ordered by order_index
+func (m *MemoryWorkoutStore) GetWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	workout := m.db.workouts[int(id)]
+	if workout == nil {
+		return nil, nil
+	}
+	copied := copyWorkout(workout)
+	sort.SliceStable(copied.Entries, func(i, j int) bool {
+		return copied.Entries[i].OrderIndex < copied.Entries[j].OrderIndex
+	})
+	return copied, nil
+}
+
+//! UpdateWorkout --> title, description, duration, calories and the whole entry list, owner and creator stay
+//? like the postgres UPDATE, an unknown id is no error unless there are entries to insert (foreign key)
+func (m *MemoryWorkoutStore) UpdateWorkout(ctx context.Context, workout *Workout) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	stored := m.db.workouts[workout.ID]
+	if stored == nil {
+		if len(workout.Entries) > 0 {
+			return newConstraintError(ErrInvalidReference, "workout_entries_workout_id_fkey")
+		}
+		return nil
+	}
+	err := checkEntries(workout.Entries)
+	if err != nil {
+		return err
+	}
+
+	m.assignEntryIDs(workout.Entries)
+	updated := copyWorkout(workout)
+	updated.UserID = stored.UserID
+	updated.CreatedBy = stored.CreatedBy
+	m.db.workouts[workout.ID] = updated
+	return nil
+}
+
+//! DeleteWorkout --> sql.ErrNoRows when nothing was deleted, entries go with the workout
+func (m *MemoryWorkoutStore) DeleteWorkout(ctx context.Context, id int64) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	if m.db.workouts[int(id)] == nil {
+		return sql.ErrNoRows
+	}
+	delete(m.db.workouts, int(id))
+	return nil
+}
+
+//! GetWorkoutOwner --> sql.ErrNoRows for unknown ids
+func (m *MemoryWorkoutStore) GetWorkoutOwner(ctx context.Context, workoutID int64) (int, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	workout := m.db.workouts[int(workoutID)]
+	if workout == nil {
+		return 0, sql.ErrNoRows
+	}
+	return workout.UserID, nil
+}
+
+//! assignEntryIDs --> one BIGSERIAL for all entries, written back like RETURNING id
+func (m *MemoryWorkoutStore) assignEntryIDs(entries []WorkoutEntry) {
+	for i := range entries {
+		m.db.lastEntryID++
+		entries[i].ID = m.db.lastEntryID
+	}
+}
+
+//! checkEntries --> the valid_workout_entry CHECK, exactly one of reps and duration_seconds
+func checkEntries(entries []WorkoutEntry) error {
+	for _, entry := range entries {
+		if (entry.Reps == nil) == (entry.DurationSeconds == nil) {
+			return newConstraintError(ErrInvalidValue, "valid_workout_entry")
+		}
+	}
+	return nil
+}
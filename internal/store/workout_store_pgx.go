@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+//! PgxWorkoutStore --> WorkoutStore on a native pgx pool (database.driver = pgxpool)
+//? same tables and semantics as PostgresWorkoutStore, but entries go out as one pgx batch --> one round trip however many there are
+type PgxWorkoutStore struct {
+	pool *pgxpool.Pool
+}
+
+//! NewPgxWorkoutStore --> constructor, the pool comes from OpenPool
+func NewPgxWorkoutStore(pool *pgxpool.Pool) *PgxWorkoutStore {
+	return &PgxWorkoutStore{pool: pool}
+}
+
+//! pgxQueryer --> what *pgxpool.Pool and pgx.Tx have in common
+type pgxQueryer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, batch *pgx.Batch) pgx.BatchResults
+}
+
+const pgxInsertWorkoutQuery = `
+  INSERT INTO workouts (user_id, created_by, title, description, duration_minutes, calories_burned)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  RETURNING id
+  `
+
+const pgxInsertEntryQuery = `
+  INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+  RETURNING id
+  `
+
+func (pg *PgxWorkoutStore) CreateWorkout(ctx context.Context, workout *Workout) (*Workout, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	//! workout and entries are saved together or not at all
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) //? no-op once committed
+
+	//? creator defaults to the owner, coaches set it explicitly
+	if workout.CreatedBy == 0 {
+		workout.CreatedBy = workout.UserID
+	}
+
+	err = pgxScanRow(ctx, tx, []any{&workout.ID}, pgxInsertWorkoutQuery, workout.UserID, workout.CreatedBy, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	err = pgxInsertEntries(ctx, tx, workout.ID, workout.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return workout, nil
+}
+
+//! GetWorkoutByID --> workout row and its entries queued in one batch, a single round trip
+func (pg *PgxWorkoutStore) GetWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	workoutQuery := `
+  SELECT id, user_id, COALESCE(created_by, 0), title, description, duration_minutes, calories_burned
+  FROM workouts
+  WHERE id = $1
+  `
+	entryQuery := `
+  SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
+  FROM workout_entries
+  WHERE workout_id = $1
+  ORDER BY order_index
+  `
+	batch := &pgx.Batch{}
+	batch.Queue(workoutQuery, id)
+	batch.Queue(entryQuery, id)
+
+	ctx, span := startQuerySpan(ctx, workoutQuery+";"+entryQuery)
+	span.SetAttributes(attribute.Int("db.operation.batch.size", batch.Len()))
+	workout, err := readWorkoutBatch(pg.pool.SendBatch(ctx, batch))
+	endQuerySpan(span, err)
+	return workout, err
+}
+
+//! readWorkoutBatch --> results of GetWorkoutByID's batch, closes them
+func readWorkoutBatch(results pgx.BatchResults) (workout *Workout, err error) {
+	defer func() {
+		closeErr := results.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	workout = &Workout{}
+	err = results.QueryRow().Scan(&workout.ID, &workout.UserID, &workout.CreatedBy, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil //? workout doesn't exist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := results.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry WorkoutEntry
+		err = rows.Scan(&entry.ID, &entry.ExerciseName, &entry.Sets, &entry.Reps, &entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex)
+		if err != nil {
+			return nil, err
+		}
+		workout.Entries = append(workout.Entries, entry)
+	}
+	return workout, rows.Err()
+}
+
+func (pg *PgxWorkoutStore) UpdateWorkout(ctx context.Context, workout *Workout) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+  UPDATE workouts
+  SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4
+  WHERE id = $5
+  `
+	_, err = pgxExec(ctx, tx, query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID)
+	if err != nil {
+		return err
+	}
+
+	//? entries are replaced as a whole, like PostgresWorkoutStore
+	_, err = pgxExec(ctx, tx, "DELETE FROM workout_entries WHERE workout_id = $1", workout.ID)
+	if err != nil {
+		return err
+	}
+
+	err = pgxInsertEntries(ctx, tx, workout.ID, workout.Entries)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+//! DeleteWorkout --> entries go with it (ON DELETE CASCADE), sql.ErrNoRows when nothing was deleted
+func (pg *PgxWorkoutStore) DeleteWorkout(ctx context.Context, id int64) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	tag, err := pgxExec(ctx, pg.pool, "DELETE FROM workouts WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//! GetWorkoutOwner --> sql.ErrNoRows for unknown ids, the handlers don't know about pgx
+func (pg *PgxWorkoutStore) GetWorkoutOwner(ctx context.Context, workoutID int64) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var userID int
+	err := pgxScanRow(ctx, pg.pool, []any{&userID}, "SELECT user_id FROM workouts WHERE id = $1", workoutID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, sql.ErrNoRows
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+//! pgxInsertEntries --> every entry INSERT queued in one batch, the returned ids are written back into entries
+func pgxInsertEntries(ctx context.Context, q pgxQueryer, workoutID int, entries []WorkoutEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, entry := range entries {
+		batch.Queue(pgxInsertEntryQuery, workoutID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex)
+	}
+
+	ctx, span := startQuerySpan(ctx, pgxInsertEntryQuery)
+	span.SetAttributes(attribute.Int("db.operation.batch.size", batch.Len()))
+	results := q.SendBatch(ctx, batch)
+	var err error
+	for i := range entries {
+		err = results.QueryRow().Scan(&entries[i].ID)
+		if err != nil {
+			break
+		}
+	}
+	closeErr := results.Close()
+	if err == nil {
+		err = closeErr
+	}
+	endQuerySpan(span, err)
+	return translateError(err)
+}
+
+//! pgxExec --> q.Exec inside a query span
+func pgxExec(ctx context.Context, q pgxQueryer, query string, args ...any) (pgconn.CommandTag, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	tag, err := q.Exec(ctx, query, args...)
+	endQuerySpan(span, err)
+	return tag, err
+}
+
+//! pgxScanRow --> q.QueryRow + Scan inside a query span, pgx only reports the query error from Scan
+func pgxScanRow(ctx context.Context, q pgxQueryer, dest []any, query string, args ...any) error {
+	ctx, span := startQuerySpan(ctx, query)
+	err := q.QueryRow(ctx, query, args...).Scan(dest...)
+	endQuerySpan(span, err)
+	return err
+}
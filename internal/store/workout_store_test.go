@@ -1,18 +1,55 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"fem/internal/validator"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// ! setupTestDB --> prepares fresh test database for each test
-func setupTestDB(t *testing.T) *sql.DB {
-	// * connecting to test db on port 5500 (separate from dev db on 5445)
-	db,err := sql.Open("pgx","host=localhost user=postgres password=postgres dbname=postgres port=5500 sslmode=disable")
+// * test db on port 5500 (separate from dev db on 5445)
+const testDSN = "host=localhost user=postgres password=postgres dbname=postgres port=5500 sslmode=disable"
+
+// * testDBErr --> result of one short ping per test binary, nil when the test db is up
+var (
+	testDBOnce sync.Once
+	testDBErr  error
+)
+
+// ! skipWithoutTestDB --> db tests skip instead of failing when nothing listens on :5500
+// ? go test ./... stays green on a laptop without docker, the memory backend still runs
+func skipWithoutTestDB(t testing.TB) {
+	testDBOnce.Do(func() {
+		db,err := sql.Open("pgx",testDSN)
+		if err != nil {
+			testDBErr = err
+			return
+		}
+		defer db.Close()
+		ctx,cancel := context.WithTimeout(context.Background(),time.Second)
+		defer cancel()
+		testDBErr = db.PingContext(ctx)
+	})
+	if testDBErr != nil {
+		t.Skipf("test db unreachable, start it with docker compose up test_db: %v",testDBErr)
+	}
+}
+
+// ! setupTestDB --> prepares fresh test database for each test (and benchmark)
+func setupTestDB(t testing.TB) *sql.DB {
+	skipWithoutTestDB(t)
+
+	// * connecting to test db
+	db,err := sql.Open("pgx",testDSN)
 	if err!= nil {
 		t.Fatalf("opening test db : %v",err)
 	}
@@ -103,7 +140,7 @@ func TestCreateWorkout(t *testing.T) {
 		// * t.Run creates subtest with name --> shows clearly which test failed
 		t.Run(tt.name,func(t *testing.T) {
 			// ? - attempting to create workout in test db
-			createWorkout,err := store.CreateWorkout(tt.workout)
+			createWorkout,err := store.CreateWorkout(context.Background(),tt.workout)
 			
 			// * if we expect an error
 			if tt.wantErr {
@@ -118,7 +155,7 @@ func TestCreateWorkout(t *testing.T) {
 			assert.Equal(t,tt.workout.DurationMinutes, createWorkout.DurationMinutes)
 			
 			// ? - now fetch the workout from db to verify it was really saved
-			retrieved,err := store.GetWorkoutByID(int64(createWorkout.ID))
+			retrieved,err := store.GetWorkoutByID(context.Background(),int64(createWorkout.ID))
 			require.NoError(t,err)
 
 			// * checking main workout fields match
@@ -135,6 +172,212 @@ func TestCreateWorkout(t *testing.T) {
 	}
 }
 
+// ! TestValidateWorkout --> pure validation, no db needed
+func TestValidateWorkout(t *testing.T) {
+	// * one workout with several problems --> every one of them is reported
+	workout := &Workout{
+		Title: "   ",
+		DurationMinutes: -5,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "squats", Sets: 0, Reps: intPointer(10), DurationSeconds: intPointer(60)},
+			{ExerciseName: "plank", Sets: 3, DurationSeconds: intPointer(90)},
+			{ExerciseName: "", Sets: 3},
+		},
+	}
+
+	v := validator.New()
+	ValidateWorkout(v,workout)
+
+	assert.False(t,v.Valid())
+	assert.Equal(t,[]string{"title is required"},v.Errors["title"])
+	assert.Contains(t,v.Errors,"duration_minutes")
+	assert.Equal(t,[]string{"sets must be between 1 and 100"},v.Errors["entries[0].sets"])
+	assert.Equal(t,[]string{"reps and duration_seconds cannot both be set"},v.Errors["entries[0].reps"])
+	assert.Equal(t,[]string{"either reps or duration_seconds is required"},v.Errors["entries[2].reps"])
+	assert.Contains(t,v.Errors,"entries[2].exercise_name")
+	// ? the valid plank entry adds nothing
+	assert.NotContains(t,v.Errors,"entries[1].sets")
+	assert.NotContains(t,v.Errors,"entries[1].reps")
+
+	// * a well formed workout passes
+	v = validator.New()
+	ValidateWorkout(v,&Workout{
+		Title: "push day",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{{ExerciseName: "bench press", Sets: 4, Reps: intPointer(8), Weight: floatPointer(100)}},
+	})
+	assert.True(t,v.Valid(),v.Errors)
+}
+
+// ! TestScanWorkout --> entries arrive as the json_agg array of selectWorkoutsQuery, no db needed
+func TestScanWorkout(t *testing.T) {
+	entries := []byte(`[{"id":3,"exercise_name":"bench press","sets":4,"reps":8,"duration_seconds":null,"weight":100.50,"notes":"","order_index":1},
+		{"id":4,"exercise_name":"plank","sets":3,"reps":null,"duration_seconds":60,"weight":null,"notes":"hold","order_index":2}]`)
+
+	workout,err := scanWorkout(fakeScan(7,1,2,"push day","",60,300,entries))
+	require.NoError(t,err)
+	assert.Equal(t,2,workout.CreatedBy)
+	assert.Equal(t,[]WorkoutEntry{
+		{ID: 3, ExerciseName: "bench press", Sets: 4, Reps: intPointer(8), Weight: floatPointer(100.5), OrderIndex: 1},
+		{ID: 4, ExerciseName: "plank", Sets: 3, DurationSeconds: intPointer(60), Notes: "hold", OrderIndex: 2},
+	},workout.Entries)
+
+	// ? no entries --> json_agg is NULL and Entries stays nil
+	workout,err = scanWorkout(fakeScan(8,1,1,"rest day","",0,0,[]byte(nil)))
+	require.NoError(t,err)
+	assert.Nil(t,workout.Entries)
+
+	// ? the row error (sql.ErrNoRows for a missing workout) is passed through untouched
+	_,err = scanWorkout(func(dest ...any) error { return sql.ErrNoRows })
+	assert.Equal(t,sql.ErrNoRows,err)
+}
+
+// * fakeScan --> a Scan func that copies the given column values into dest
+func fakeScan(values ...any) func(dest ...any) error {
+	return func(dest ...any) error {
+		for i := range dest {
+			reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(values[i]))
+		}
+		return nil
+	}
+}
+
+// ! BENCHMARKS --> need the test db like the tests above
+// ? go test ./internal/store -run '^$' -bench Workout -benchmem
+
+// * benchmarkWorkout --> a workout with n rep-based entries
+func benchmarkWorkout(n int) *Workout {
+	workout := &Workout{UserID: 1, Title: "benchmark day", DurationMinutes: 60, CaloriesBurned: 400}
+	for i := 0; i < n; i++ {
+		workout.Entries = append(workout.Entries,WorkoutEntry{
+			ExerciseName: fmt.Sprintf("exercise %d",i),
+			Sets: 3,
+			Reps: intPointer(10),
+			Weight: floatPointer(60),
+			OrderIndex: i,
+		})
+	}
+	return workout
+}
+
+// ! BenchmarkCreateWorkout --> one multi-row INSERT and the pgx batch against the baseline of one INSERT per entry
+func BenchmarkCreateWorkout(b *testing.B) {
+	db := setupTestDB(b)
+	defer db.Close()
+	pool,err := OpenPool(context.Background(),testDSN,PoolConfig{MaxOpenConns: 4})
+	require.NoError(b,err)
+	defer pool.Close()
+
+	ctx := context.Background()
+	pgStore := NewPostgresWorkoutStore(db)
+	pgxStore := NewPgxWorkoutStore(pool)
+
+	for _, n := range []int{1, 10, 50} {
+		b.Run(fmt.Sprintf("multi_row/entries=%d",n),func(b *testing.B) {
+			for b.Loop() {
+				_,err := pgStore.CreateWorkout(ctx,benchmarkWorkout(n))
+				require.NoError(b,err)
+			}
+		})
+		b.Run(fmt.Sprintf("pgx_batch/entries=%d",n),func(b *testing.B) {
+			for b.Loop() {
+				_,err := pgxStore.CreateWorkout(ctx,benchmarkWorkout(n))
+				require.NoError(b,err)
+			}
+		})
+		b.Run(fmt.Sprintf("row_by_row/entries=%d",n),func(b *testing.B) {
+			for b.Loop() {
+				require.NoError(b,createWorkoutRowByRow(ctx,db,benchmarkWorkout(n)))
+			}
+		})
+	}
+}
+
+// ! BenchmarkGetWorkoutByID --> json_agg in one query and the pgx batch against the baseline of two queries
+func BenchmarkGetWorkoutByID(b *testing.B) {
+	db := setupTestDB(b)
+	defer db.Close()
+	pool,err := OpenPool(context.Background(),testDSN,PoolConfig{MaxOpenConns: 4})
+	require.NoError(b,err)
+	defer pool.Close()
+
+	ctx := context.Background()
+	pgStore := NewPostgresWorkoutStore(db)
+	pgxStore := NewPgxWorkoutStore(pool)
+	workout,err := pgStore.CreateWorkout(ctx,benchmarkWorkout(10))
+	require.NoError(b,err)
+	id := int64(workout.ID)
+
+	b.Run("json_agg",func(b *testing.B) {
+		for b.Loop() {
+			_,err := pgStore.GetWorkoutByID(ctx,id)
+			require.NoError(b,err)
+		}
+	})
+	b.Run("pgx_batch",func(b *testing.B) {
+		for b.Loop() {
+			_,err := pgxStore.GetWorkoutByID(ctx,id)
+			require.NoError(b,err)
+		}
+	})
+	b.Run("two_queries",func(b *testing.B) {
+		for b.Loop() {
+			_,err := getWorkoutTwoQueries(ctx,db,id)
+			require.NoError(b,err)
+		}
+	})
+}
+
+// * createWorkoutRowByRow --> baseline, one INSERT round trip per entry
+func createWorkoutRowByRow(ctx context.Context,db *sql.DB,workout *Workout) error {
+	tx,err := db.BeginTx(ctx,nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,`INSERT INTO workouts (user_id, created_by, title, description, duration_minutes, calories_burned)
+		VALUES ($1, $1, $2, $3, $4, $5) RETURNING id`,workout.UserID,workout.Title,workout.Description,workout.DurationMinutes,workout.CaloriesBurned).Scan(&workout.ID)
+	if err != nil {
+		return err
+	}
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		err = tx.QueryRowContext(ctx,`INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,workout.ID,entry.ExerciseName,entry.Sets,entry.Reps,entry.DurationSeconds,entry.Weight,entry.Notes,entry.OrderIndex).Scan(&entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// * getWorkoutTwoQueries --> baseline, workout row then its entries
+func getWorkoutTwoQueries(ctx context.Context,db *sql.DB,id int64) (*Workout,error) {
+	workout := &Workout{}
+	err := db.QueryRowContext(ctx,`SELECT id, user_id, COALESCE(created_by, 0), title, description, duration_minutes, calories_burned
+		FROM workouts WHERE id = $1`,id).Scan(&workout.ID,&workout.UserID,&workout.CreatedBy,&workout.Title,&workout.Description,&workout.DurationMinutes,&workout.CaloriesBurned)
+	if err != nil {
+		return nil,err
+	}
+
+	rows,err := db.QueryContext(ctx,`SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
+		FROM workout_entries WHERE workout_id = $1 ORDER BY order_index`,id)
+	if err != nil {
+		return nil,err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry WorkoutEntry
+		err = rows.Scan(&entry.ID,&entry.ExerciseName,&entry.Sets,&entry.Reps,&entry.DurationSeconds,&entry.Weight,&entry.Notes,&entry.OrderIndex)
+		if err != nil {
+			return nil,err
+		}
+		workout.Entries = append(workout.Entries,entry)
+	}
+	return workout,rows.Err()
+}
+
 // ! HELPER FUNCTIONS for converting values to pointers
 
 // * intPointer --> some fields like reps/duration are optional pointers
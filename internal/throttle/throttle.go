@@ -0,0 +1,147 @@
+package throttle
+
+import (
+	"context"
+	"fem/internal/store"
+	"time"
+)
+
+//! Policy --> how failed logins for one subject turn into backoff and lockout
+type Policy struct {
+	FreeAttempts     int           //* failures allowed before any delay kicks in
+	BaseDelay        time.Duration //* first delay, doubled for every further failure
+	MaxDelay         time.Duration //* cap for the exponential backoff
+	LockoutThreshold int           //* failures that trigger a temporary lockout
+	LockoutDuration  time.Duration //* how long a lockout lasts
+	Window           time.Duration //* failures older than this are forgotten
+}
+
+//! DefaultUsernamePolicy --> strict, one account should never see many wrong passwords
+var DefaultUsernamePolicy = Policy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  15 * time.Minute,
+	Window:           time.Hour,
+}
+
+//! DefaultIPPolicy --> lenient, many users can share one address (office, gym wifi, NAT)
+var DefaultIPPolicy = Policy{
+	FreeAttempts:     20,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 100,
+	LockoutDuration:  time.Hour,
+	Window:           time.Hour,
+}
+
+//! BlockFor --> delay after the given number of consecutive failures
+//! Second value reports whether the delay is a full lockout rather than backoff
+func (p Policy) BlockFor(failures int) (time.Duration, bool) {
+	if p.LockoutThreshold > 0 && failures >= p.LockoutThreshold {
+		return p.LockoutDuration, true
+	}
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay, false
+		}
+	}
+	return delay, false
+}
+
+//! LoginThrottler --> applies a policy per username and per client IP
+type LoginThrottler struct {
+	store          store.LoginAttemptStore
+	UsernamePolicy Policy
+	IPPolicy       Policy
+	now            func() time.Time
+}
+
+//! NewLoginThrottler --> constructor using the default policies
+func NewLoginThrottler(loginAttemptStore store.LoginAttemptStore) *LoginThrottler {
+	return &LoginThrottler{
+		store:          loginAttemptStore,
+		UsernamePolicy: DefaultUsernamePolicy,
+		IPPolicy:       DefaultIPPolicy,
+		now:            time.Now,
+	}
+}
+
+//! Check --> how long the caller has to wait before the next attempt (0 means go ahead)
+func (t *LoginThrottler) Check(ctx context.Context, username string, ip string) (time.Duration, error) {
+	now := t.now()
+	var wait time.Duration
+
+	for _, subject := range t.subjects(username, ip) {
+		attempt, err := t.store.GetLoginAttempt(ctx, subject.kind, subject.value)
+		if err != nil {
+			return 0, err
+		}
+		if attempt == nil || attempt.BlockedUntil == nil {
+			continue
+		}
+		//* the longest block of username / ip wins
+		if remaining := attempt.BlockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+//! RecordFailure --> counts a failed attempt and blocks the subject if the policy says so
+//! Returns true when this failure pushed the username into a lockout
+func (t *LoginThrottler) RecordFailure(ctx context.Context, username string, ip string) (bool, error) {
+	now := t.now()
+	lockedOut := false
+
+	for _, subject := range t.subjects(username, ip) {
+		failures, err := t.store.RecordLoginFailure(ctx, subject.kind, subject.value, now, subject.policy.Window)
+		if err != nil {
+			return false, err
+		}
+
+		delay, lockout := subject.policy.BlockFor(failures)
+		if delay == 0 {
+			continue
+		}
+		err = t.store.BlockLogin(ctx, subject.kind, subject.value, now.Add(delay))
+		if err != nil {
+			return false, err
+		}
+		//? only report the threshold crossing once, not on every later failure
+		if lockout && failures == subject.policy.LockoutThreshold {
+			lockedOut = true
+		}
+	}
+	return lockedOut, nil
+}
+
+//! RecordSuccess --> resets the username counter
+//? the ip counter is left to expire on its own, otherwise an attacker could reset it with their own account
+func (t *LoginThrottler) RecordSuccess(ctx context.Context, username string) error {
+	return t.store.ClearLoginAttempts(ctx, store.LoginSubjectUsername, username)
+}
+
+type subject struct {
+	kind   string
+	value  string
+	policy Policy
+}
+
+func (t *LoginThrottler) subjects(username string, ip string) []subject {
+	subjects := []subject{}
+	if username != "" {
+		subjects = append(subjects, subject{kind: store.LoginSubjectUsername, value: username, policy: t.UsernamePolicy})
+	}
+	if ip != "" {
+		subjects = append(subjects, subject{kind: store.LoginSubjectIP, value: ip, policy: t.IPPolicy})
+	}
+	return subjects
+}
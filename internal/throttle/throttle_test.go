@@ -0,0 +1,121 @@
+package throttle
+
+import (
+	"context"
+	"fem/internal/store"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestBlockFor --> exponential backoff, cap and lockout threshold
+func TestBlockFor(t *testing.T) {
+	policy := Policy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		LockoutThreshold: 8,
+		LockoutDuration:  15 * time.Minute,
+	}
+
+	test := []struct {
+		failures    int
+		wantDelay   time.Duration
+		wantLockout bool
+	}{
+		{failures: 1, wantDelay: 0},
+		{failures: 3, wantDelay: 0},
+		{failures: 4, wantDelay: time.Second},
+		{failures: 5, wantDelay: 2 * time.Second},
+		{failures: 6, wantDelay: 4 * time.Second},
+		{failures: 7, wantDelay: 8 * time.Second},
+		{failures: 8, wantDelay: 15 * time.Minute, wantLockout: true},
+		{failures: 50, wantDelay: 15 * time.Minute, wantLockout: true},
+	}
+
+	for _, tt := range test {
+		delay, lockout := policy.BlockFor(tt.failures)
+		assert.Equal(t, tt.wantDelay, delay, "failures=%d", tt.failures)
+		assert.Equal(t, tt.wantLockout, lockout, "failures=%d", tt.failures)
+	}
+
+	// ? - cap applies before the lockout threshold is reached
+	policy.LockoutThreshold = 0
+	delay, _ := policy.BlockFor(20)
+	assert.Equal(t, 10*time.Second, delay)
+}
+
+// ! TestLoginThrottler --> failures block the username, success clears it
+func TestLoginThrottler(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	attempts := &memoryAttempts{rows: map[string]*store.LoginAttempt{}}
+
+	throttler := NewLoginThrottler(attempts)
+	throttler.now = func() time.Time { return now } // * fake clock
+	throttler.UsernamePolicy = Policy{FreeAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutThreshold: 3, LockoutDuration: time.Hour, Window: time.Hour}
+
+	lockedOut, err := throttler.RecordFailure(ctx, "ayush", "10.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, lockedOut)
+
+	wait, err := throttler.Check(ctx, "ayush", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait) // ? - first failure is free
+
+	_, err = throttler.RecordFailure(ctx, "ayush", "10.0.0.1")
+	require.NoError(t, err)
+	wait, err = throttler.Check(ctx, "ayush", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, wait)
+
+	lockedOut, err = throttler.RecordFailure(ctx, "ayush", "10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, lockedOut)
+	wait, err = throttler.Check(ctx, "ayush", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, wait) // ! lockout follows the username to any address
+
+	now = now.Add(2 * time.Hour)
+	wait, err = throttler.Check(ctx, "ayush", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+
+	require.NoError(t, throttler.RecordSuccess(ctx, "ayush"))
+	assert.Nil(t, attempts.rows[store.LoginSubjectUsername+"/ayush"])
+}
+
+// * memoryAttempts --> tiny map backed LoginAttemptStore for the test above
+type memoryAttempts struct {
+	rows map[string]*store.LoginAttempt
+}
+
+func (m *memoryAttempts) GetLoginAttempt(ctx context.Context, kind string, subject string) (*store.LoginAttempt, error) {
+	return m.rows[kind+"/"+subject], nil
+}
+
+func (m *memoryAttempts) RecordLoginFailure(ctx context.Context, kind string, subject string, now time.Time, window time.Duration) (int, error) {
+	row := m.rows[kind+"/"+subject]
+	if row == nil {
+		row = &store.LoginAttempt{SubjectType: kind, Subject: subject}
+		m.rows[kind+"/"+subject] = row
+	}
+	if row.LastFailureAt != nil && row.LastFailureAt.Before(now.Add(-window)) {
+		row.Failures = 0
+	}
+	row.Failures++
+	row.LastFailureAt = &now
+	return row.Failures, nil
+}
+
+func (m *memoryAttempts) BlockLogin(ctx context.Context, kind string, subject string, until time.Time) error {
+	m.rows[kind+"/"+subject].BlockedUntil = &until
+	return nil
+}
+
+func (m *memoryAttempts) ClearLoginAttempts(ctx context.Context, kind string, subject string) error {
+	delete(m.rows, kind+"/"+subject)
+	return nil
+}
@@ -4,12 +4,21 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"strings"
 	"time"
 )
 
 //! ScopeAuth --> token type identifier for authentication tokens
+//! ScopeMFAPending --> short-lived token proving the password step of a 2FA login passed
+//! ScopeOAuthAccess --> access token issued to a third-party app through /oauth/token
+//! ScopeMagicLink --> single-use token emailed in a passwordless login link
+//! ScopeEmailChange --> single-use token emailed to a new address to confirm it
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth        = "authentication"
+	ScopeMFAPending  = "mfa-pending"
+	ScopeOAuthAccess = "oauth-access"
+	ScopeMagicLink   = "magic-link"
+	ScopeEmailChange = "email-change"
 )
 
 //! Token struct --> represents authentication token with both plaintext and hashed versions
@@ -19,6 +28,8 @@ type Token struct {
 	UserID    int       `json:"-"` //* which user owns this token
 	Expiry    time.Time `json:"expiry"` //* when token expires
 	Scope     string    `json:"-"` //* token type (authentication, password-reset, etc.)
+	ClientID  string    `json:"-"` //* oauth client the token was issued to ("" for first-party tokens)
+	GrantedScopes []string `json:"-"` //* permission scopes for oauth tokens (nil --> full access)
 }
 
 //! GenerateToken --> creates cryptographically secure random token
@@ -31,16 +42,47 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 	}
 
 	//* generate 32 random bytes using crypto/rand (secure random)
-	emptyBytes := make([]byte, 32)
-	_, err := rand.Read(emptyBytes)
+	plaintext, err := GenerateSecret(32)
 	if err != nil {
 		return nil, err
 	}
 
-	//* encode to base32 for URL-safe string (no padding)
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
+	token.Plaintext = plaintext
 	//* hash plaintext using SHA-256 for database storage
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:] //* convert array to slice
+	token.Hash = Hash(token.Plaintext)
 	return token, nil
+}
+
+//! GenerateSecret --> random bytes encoded to base32 (URL-safe, no padding)
+//? shared by tokens, oauth client secrets and authorization codes
+func GenerateSecret(byteLength int) (string, error) {
+	randomBytes := make([]byte, byteLength)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+//! APIKeyPrefix --> marks personal API keys so middleware can tell them from login tokens
+const APIKeyPrefix = "fem_"
+
+//! GenerateAPIKey --> long-lived random key for scripts and integrations
+//! Returns plaintext (shown once), a short display prefix and the hash to store
+func GenerateAPIKey() (plaintext string, displayPrefix string, hash []byte, err error) {
+	secret, err := GenerateSecret(32)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	secret = strings.ToLower(secret)
+	plaintext = APIKeyPrefix + secret
+	//* prefix lets users recognise a key in the list without ever seeing it again
+	return plaintext, plaintext[:len(APIKeyPrefix)+8], Hash(plaintext), nil
+}
+
+//! Hash --> SHA-256 of a plaintext secret, the only form we ever store
+func Hash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:] //* convert array to slice
 }
\ No newline at end of file
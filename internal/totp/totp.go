@@ -0,0 +1,159 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+//! ErrInvalidSecret --> returned when a stored secret is not valid base32
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+//! Config --> RFC 6238 parameters (time step, code length and allowed clock drift)
+//? authenticator apps only understand SHA-1 / 30s / 6 digits reliably, so that is the default
+type Config struct {
+	Period time.Duration //* length of one time step
+	Digits int           //* number of digits in a code
+	Skew   int           //* how many steps before/after "now" are still accepted
+}
+
+//! DefaultConfig --> settings used for every enrolled user
+var DefaultConfig = Config{
+	Period: 30 * time.Second,
+	Digits: 6,
+	Skew:   1,
+}
+
+//* secrets are encoded without padding, the same way authenticator apps display them
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//! GenerateSecret --> creates a random 160 bit shared secret (RFC 4226 recommended length)
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+//! Step --> time step counter for the given instant
+func (c Config) Step(t time.Time) int64 {
+	return t.Unix() / int64(c.Period/time.Second)
+}
+
+//! Code --> generates the one-time code for the given instant
+func (c Config) Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return c.codeForStep(key, c.Step(t)), nil
+}
+
+//! Validate --> checks a code against every step inside the skew window
+//! Returns the matched step so callers can reject replays of the same code
+func (c Config) Validate(secret string, code string, t time.Time) (int64, bool, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != c.Digits {
+		return 0, false, nil
+	}
+
+	current := c.Step(t)
+	for offset := -c.Skew; offset <= c.Skew; offset++ {
+		step := current + int64(offset)
+		//* constant time compare so response timing doesn't leak digits
+		if subtle.ConstantTimeCompare([]byte(c.codeForStep(key, step)), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+//! codeForStep --> HOTP (RFC 4226) value for a single counter
+func (c Config) codeForStep(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	//* dynamic truncation --> last nibble picks which 4 bytes to use
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < c.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", c.Digits, value%mod)
+}
+
+//! URI --> otpauth:// URI understood by Google Authenticator, 1Password, Authy etc.
+func (c Config) URI(issuer string, account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(c.Digits))
+	params.Set("period", fmt.Sprint(int64(c.Period/time.Second)))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+//! QRCodePNG --> renders the otpauth URI as a PNG so clients don't need a QR library
+func QRCodePNG(uri string, size int) ([]byte, error) {
+	return qrcode.Encode(uri, qrcode.Medium, size)
+}
+
+//! GenerateRecoveryCodes --> single-use backup codes shown once after enrollment
+//? format is xxxxx-xxxxx so they are easy to read out and type
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		raw := make([]byte, 7)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(secretEncoding.EncodeToString(raw))[:10]
+		codes = append(codes, encoded[:5]+"-"+encoded[5:])
+	}
+	return codes, nil
+}
+
+//! NormalizeRecoveryCode --> lets users type codes with or without dash / in any case
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) == 10 {
+		return code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
@@ -0,0 +1,107 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// * ASCII "12345678901234567890" --> the SHA-1 seed used by RFC 6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// ! TestCodeMatchesRFCVectors --> checks generated codes against the published test vectors
+func TestCodeMatchesRFCVectors(t *testing.T) {
+	cfg := Config{Period: 30 * time.Second, Digits: 8, Skew: 1}
+
+	test := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.want, func(t *testing.T) {
+			// * fake clock --> fixed instant instead of time.Now()
+			code, err := cfg.Code(rfcSecret, time.Unix(tt.unix, 0))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, code)
+		})
+	}
+}
+
+// ! TestValidate --> skew window, replay step and malformed input
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := DefaultConfig.Code(rfcSecret, now)
+	require.NoError(t, err)
+
+	test := []struct {
+		name    string
+		code    string
+		at      time.Time
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "same step", code: code, at: now, wantOK: true},
+		{name: "one step late", code: code, at: now.Add(30 * time.Second), wantOK: true},
+		{name: "one step early", code: code, at: now.Add(-30 * time.Second), wantOK: true},
+		{name: "outside skew window", code: code, at: now.Add(2 * time.Minute), wantOK: false},
+		{name: "wrong length", code: "123", at: now, wantOK: false},
+		{name: "spaces are ignored", code: code[:3] + " " + code[3:], at: now, wantOK: true},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := DefaultConfig.Validate(rfcSecret, tt.code, tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOK, ok)
+			if ok {
+				// ? - matched step is always the step the code was generated for
+				assert.Equal(t, DefaultConfig.Step(now), step)
+			}
+		})
+	}
+
+	// ! corrupted secrets surface as errors, not as "wrong code"
+	_, _, err = DefaultConfig.Validate("not base32!", code, now)
+	assert.ErrorIs(t, err, ErrInvalidSecret)
+}
+
+// ! TestURI --> otpauth URI carries everything an authenticator app needs
+func TestURI(t *testing.T) {
+	uri := DefaultConfig.URI("FitTrack", "coach@example.com", rfcSecret)
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/FitTrack:coach@example.com?"))
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=FitTrack")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+
+	png, err := QRCodePNG(uri, 256)
+	require.NoError(t, err)
+	assert.Equal(t, "\x89PNG", string(png[:4]))
+}
+
+// ! TestRecoveryCodes --> generated codes are unique and survive normalization
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Len(t, code, 11)
+		assert.False(t, seen[code])
+		seen[code] = true
+		assert.Equal(t, code, NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	}
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+//! exporter names --> values of OTEL_TRACES_EXPORTER
+const (
+	ExporterNone   = "none"   //* spans are created (trace ids in logs, traceparent is honoured) but never sent
+	ExporterStdout = "stdout" //* pretty printed JSON, handy locally
+	ExporterOTLP   = "otlp"   //* OTLP over HTTP, endpoint from OTEL_EXPORTER_OTLP_ENDPOINT (default localhost:4318)
+)
+
+//! Propagator --> W3C traceparent/tracestate plus baggage, the same one the HTTP middleware reads
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+//! New --> tracer provider for the named exporter, registered as the global provider and propagator
+//! Call Shutdown on the result before exiting so buffered spans get flushed
+//? stdout writes to out, the other exporters ignore it
+func New(ctx context.Context, exporter string, serviceName string, out io.Writer) (*sdktrace.TracerProvider, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(), //* OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES win over the default name
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tracing : resource %w", err)
+	}
+
+	options := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	switch strings.ToLower(strings.TrimSpace(exporter)) {
+	case "", ExporterNone:
+		//* no processor --> nothing leaves the process
+	case ExporterStdout:
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(out), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("tracing : stdout exporter %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(spanExporter))
+	case ExporterOTLP:
+		//? the exporter reads OTEL_EXPORTER_OTLP_ENDPOINT, _HEADERS, _INSECURE ... itself
+		spanExporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("tracing : otlp exporter %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(spanExporter))
+	default:
+		return nil, fmt.Errorf("tracing : unknown exporter %q (want %s, %s or %s)", exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator())
+	return provider, nil
+}
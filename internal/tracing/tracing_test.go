@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+// ! TestNewStdout --> spans end up on the writer once the provider is shut down
+func TestNewStdout(t *testing.T) {
+	var buf bytes.Buffer
+	provider, err := New(context.Background(), "stdout", "fem-test", &buf)
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "GET /workouts/{id}")
+	span.End()
+	require.NoError(t, provider.Shutdown(context.Background()))
+
+	assert.Contains(t, buf.String(), `"Name": "GET /workouts/{id}"`)
+	assert.Contains(t, buf.String(), "fem-test")
+}
+
+// ! TestNewUnknownExporter
+func TestNewUnknownExporter(t *testing.T) {
+	_, err := New(context.Background(), "zipkin", "fem-test", nil)
+	assert.Error(t, err)
+
+	provider, err := New(context.Background(), "", "fem-test", nil)
+	require.NoError(t, err)
+	assert.NoError(t, provider.Shutdown(context.Background()))
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"fem/internal/logging"
+	"net/http"
+)
+
+//! ProblemContentType --> RFC 9457 media type every error response is sent with
+const ProblemContentType = "application/problem+json"
+
+//! problemTypePrefix --> "type" member of a problem, the code makes it unique per kind of error
+const problemTypePrefix = "urn:fem:problem:"
+
+//! stable error codes --> clients branch on these, never rename or reuse one
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInvalidBody          = "invalid_body"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInvalidToken         = "invalid_token"
+	CodeInvalidOTP           = "invalid_otp"
+	CodeTooManyAttempts      = "too_many_attempts"
+	CodeAccountSuspended     = "account_suspended"
+	CodeCSRFFailed           = "csrf_failed"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeLoginSessionRequired = "login_session_required"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeAlreadyExists        = "already_exists"
+	CodeInvalidReference     = "invalid_reference"
+	CodeInvalidValue         = "invalid_value"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+	CodeInternal             = "internal_error"
+)
+
+//* titles --> the same short summary for every occurrence of a code, detail carries the specifics
+var titles = map[string]string{
+	CodeBadRequest:           "Bad request",
+	CodeInvalidBody:          "Request body could not be read",
+	CodeBodyTooLarge:         "Request body is too large",
+	CodeValidationFailed:     "Validation failed",
+	CodeUnauthorized:         "Authentication required",
+	CodeInvalidCredentials:   "Invalid credentials",
+	CodeInvalidToken:         "Invalid or expired token",
+	CodeInvalidOTP:           "Invalid one-time code",
+	CodeTooManyAttempts:      "Too many attempts",
+	CodeAccountSuspended:     "Account suspended",
+	CodeCSRFFailed:           "CSRF check failed",
+	CodeInsufficientScope:    "Insufficient scope",
+	CodeLoginSessionRequired: "Login session required",
+	CodeForbidden:            "Forbidden",
+	CodeNotFound:             "Not found",
+	CodeMethodNotAllowed:     "Method not allowed",
+	CodeConflict:             "Conflict",
+	CodeAlreadyExists:        "Already exists",
+	CodeInvalidReference:     "Invalid reference",
+	CodeInvalidValue:         "Invalid value",
+	CodeUpstreamUnavailable:  "Upstream service unavailable",
+	CodeInternal:             "Internal server error",
+}
+
+//! APIError --> one error response, rendered by WriteError as application/problem+json
+type APIError struct {
+	Status int
+	Code   string              //* stable, machine readable --> one of the Code constants
+	Detail string              //* human readable, specific to this occurrence
+	Fields map[string][]string //* field --> messages, only for errors about the request body
+}
+
+//! NewError --> APIError for any status/code pair, the helpers below cover the common ones
+func NewError(status int, code string, detail string) *APIError {
+	return &APIError{Status: status, Code: code, Detail: detail}
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Detail
+}
+
+//! WithFields --> attaches per field messages (validation, constraint violations)
+func (e *APIError) WithFields(fields map[string][]string) *APIError {
+	e.Fields = fields
+	return e
+}
+
+//! Title --> short summary for the code, falls back to the status text for unknown codes
+func (e *APIError) Title() string {
+	title, ok := titles[e.Code]
+	if !ok {
+		return http.StatusText(e.Status)
+	}
+	return title
+}
+
+//! problem --> RFC 9457 body, code/request_id/fields are extension members
+type problem struct {
+	Type      string              `json:"type"`
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Detail    string              `json:"detail,omitempty"`
+	Instance  string              `json:"instance,omitempty"`
+	Code      string              `json:"code"`
+	RequestID string              `json:"request_id,omitempty"`
+	Fields    map[string][]string `json:"fields,omitempty"`
+}
+
+//! WriteError --> the only way handlers and middleware answer with an error
+func WriteError(w http.ResponseWriter, r *http.Request, apiErr *APIError) {
+	body := problem{
+		Type:      problemTypePrefix + apiErr.Code,
+		Title:     apiErr.Title(),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Detail,
+		Instance:  r.URL.Path,
+		Code:      apiErr.Code,
+		RequestID: RequestID(r),
+		Fields:    apiErr.Fields,
+	}
+	writeBody(w, apiErr.Status, ProblemContentType, body)
+}
+
+//! RequestID --> id of the current request, set by middleware.RequestID on the router
+func RequestID(r *http.Request) string {
+	return logging.RequestID(r.Context())
+}
+
+//! shorthands for the errors every handler needs, all with the generic code for their status
+
+func BadRequest(w http.ResponseWriter, r *http.Request, detail string) {
+	WriteError(w, r, NewError(http.StatusBadRequest, CodeBadRequest, detail))
+}
+
+func Unauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	WriteError(w, r, NewError(http.StatusUnauthorized, CodeUnauthorized, detail))
+}
+
+func Forbidden(w http.ResponseWriter, r *http.Request, detail string) {
+	WriteError(w, r, NewError(http.StatusForbidden, CodeForbidden, detail))
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request, detail string) {
+	WriteError(w, r, NewError(http.StatusNotFound, CodeNotFound, detail))
+}
+
+func Conflict(w http.ResponseWriter, r *http.Request, detail string) {
+	WriteError(w, r, NewError(http.StatusConflict, CodeConflict, detail))
+}
+
+//? the cause is logged by the caller, never sent to the client
+func InternalError(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, NewError(http.StatusInternalServerError, CodeInternal, "the server could not process the request"))
+}
@@ -3,8 +3,12 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -15,6 +19,11 @@ type Envelope map[string]interface{}
 
 //! WriteJson --> standardized JSON response writer used across all handlers
 func WriteJson(w http.ResponseWriter, status int, data Envelope) error {
+	return writeBody(w,status,"application/json",data)
+}
+
+//! writeBody --> shared by WriteJson and WriteError, only the content type differs
+func writeBody(w http.ResponseWriter, status int, contentType string, data any) error {
 	//* using MarshalIndent for pretty formatted JSON output (easier to read in browser/postman)
 	json,err := json.MarshalIndent(data,""," ")
 	
@@ -23,12 +32,71 @@ func WriteJson(w http.ResponseWriter, status int, data Envelope) error {
 	}
 
 	json = append(json, '\n') //* adding newline at end for cleaner terminal output
-	w.Header().Set("Content-type","application/json") //* setting response content type
+	w.Header().Set("Content-type",contentType) //* setting response content type
 	w.WriteHeader(status) //* HTTP status code (200, 400, 500, etc.)
 	w.Write(json) //* writing JSON to response
 	return nil
 }
 
+//! MaxBodyBytes --> largest JSON body ReadJSON accepts (1MB), bigger bodies are refused before decoding
+const MaxBodyBytes = 1 << 20
+
+//* ErrBodyTooLarge --> handlers answer 413 for this one, every other ReadJSON error is a 400
+var ErrBodyTooLarge = fmt.Errorf("body must not be larger than %d bytes", MaxBodyBytes)
+
+//! ReadJSON --> strict decoding of a request body into dst, shared by every handler
+//! Rejects unknown fields, a second JSON value after the first and bodies over MaxBodyBytes.
+//! The returned error is safe to show to the client as is.
+func ReadJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w,r.Body,MaxBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields() //* typos like "pasword" fail loudly instead of being dropped
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err,&syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)",syntaxError.Offset)
+		case errors.Is(err,io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err,&typeError):
+			if typeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q",typeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)",typeError.Offset)
+		case errors.Is(err,io.EOF):
+			return errors.New("body must not be empty")
+		//? encoding/json has no typed error for this one, only the message
+		case strings.HasPrefix(err.Error(),"json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(),"json: unknown field ")
+			return fmt.Errorf("body contains unknown field %s",fieldName)
+		case errors.As(err,&maxBytesError):
+			return ErrBodyTooLarge
+		case errors.As(err,&invalidUnmarshalError):
+			panic(err) //! programming error --> dst was not a non-nil pointer
+		default:
+			return err
+		}
+	}
+
+	//* a second Decode must hit EOF, otherwise there is more after the first value
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err,io.EOF) {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err,&maxBytesError) {
+			return ErrBodyTooLarge
+		}
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
 //! ReadIDParam --> extracts and validates ID from URL path parameter
 //! Used by GET/PUT/DELETE endpoints like /workouts/{id}
 func ReadIDParam(r *http.Request) (int64,error) {
@@ -45,4 +113,13 @@ func ReadIDParam(r *http.Request) (int64,error) {
 	}
 	
 	return id,err
-}
\ No newline at end of file
+}
+//! ClientIP --> address of the connecting client, used for throttling and audit entries
+//? X-Forwarded-For is ignored on purpose, it is client controlled unless a trusted proxy strips it
+func ClientIP(r *http.Request) string {
+	host,_,err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr //* RemoteAddr without port (unix sockets, tests)
+	}
+	return host
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fem/internal/logging"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ! TestReadJSON --> every way a body can be refused, with the message the client sees
+func TestReadJSON(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string // * empty --> body must decode
+	}{
+		{name: "valid", body: `{"name":"squat","count":3}`},
+		{name: "empty body", body: ``, wantErr: "body must not be empty"},
+		{name: "syntax error", body: `{"name":"squat",}`, wantErr: "body contains badly-formed JSON (at character 17)"},
+		{name: "truncated", body: `{"name":"squat"`, wantErr: "body contains badly-formed JSON"},
+		{name: "wrong type", body: `{"count":"three"}`, wantErr: `body contains incorrect JSON type for field "count"`},
+		{name: "wrong top level type", body: `["squat"]`, wantErr: "body contains incorrect JSON type (at character 1)"},
+		{name: "unknown field", body: `{"name":"squat","cuont":3}`, wantErr: `body contains unknown field "cuont"`},
+		{name: "two values", body: `{"name":"squat"}{"name":"bench"}`, wantErr: "body must only contain a single JSON value"},
+		{name: "trailing garbage", body: `{"name":"squat"} trailing`, wantErr: "body must only contain a single JSON value"},
+		{name: "too large", body: `{"name":"` + strings.Repeat("a", MaxBodyBytes) + `"}`, wantErr: ErrBodyTooLarge.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			var dst payload
+			err := ReadJSON(httptest.NewRecorder(), req, &dst)
+
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				assert.Equal(t, payload{Name: "squat", Count: 3}, dst)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
+
+// ! TestWriteError --> RFC 9457 members plus code, request id and fields
+func TestWriteError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/workouts", nil)
+	req = req.WithContext(logging.WithRequestID(req.Context(), "req-123"))
+	WriteError(rr, req, NewError(http.StatusUnprocessableEntity, CodeValidationFailed, "one or more fields are invalid").WithFields(map[string][]string{"title": {"title is required"}}))
+
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:fem:problem:validation_failed",
+		"title": "Validation failed",
+		"status": 422,
+		"detail": "one or more fields are invalid",
+		"instance": "/workouts",
+		"code": "validation_failed",
+		"request_id": "req-123",
+		"fields": {"title": ["title is required"]}
+	}`, rr.Body.String())
+
+	// ? unknown codes still get a title
+	assert.Equal(t, "I'm a teapot", NewError(http.StatusTeapot, "teapot", "").Title())
+}
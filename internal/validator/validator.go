@@ -0,0 +1,80 @@
+//! Package validator --> collects every field error of a payload so clients can fix them in one go
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//! EmailRX --> standard email format, checked on registration and email changes
+var EmailRX = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+//! Validator --> field name (as the client sent it) --> every message about that field
+type Validator struct {
+	Errors map[string][]string
+}
+
+//! New --> empty validator, ready for Check calls
+func New() *Validator {
+	return &Validator{Errors: map[string][]string{}}
+}
+
+//! Valid --> true when no check failed
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+//! AddError --> records a message for the field, repeated messages are kept once
+func (v *Validator) AddError(field string, message string) {
+	for _, existing := range v.Errors[field] {
+		if existing == message {
+			return
+		}
+	}
+	v.Errors[field] = append(v.Errors[field], message)
+}
+
+//! Check --> adds the message when ok is false, returns ok so dependent checks can be skipped
+func (v *Validator) Check(ok bool, field string, message string) bool {
+	if !ok {
+		v.AddError(field, message)
+	}
+	return ok
+}
+
+//! Field --> name of a field inside a list element, Field("entries", 2, "sets") == "entries[2].sets"
+func Field(list string, index int, name string) string {
+	return fmt.Sprintf("%s[%d].%s", list, index, name)
+}
+
+//! NotBlank --> something besides whitespace
+func NotBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+//! MaxChars --> counts characters, not bytes (VARCHAR limits in postgres do the same)
+func MaxChars(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+//! Between --> min <= value <= max
+func Between[T int | float64](value T, min T, max T) bool {
+	return value >= min && value <= max
+}
+
+//! Matches --> value matches the pattern
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+//! PermittedValue --> value is one of the permitted ones
+func PermittedValue[T comparable](value T, permitted ...T) bool {
+	for _, p := range permitted {
+		if value == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ! TestValidatorCollectsEveryError --> all failed checks end up in Errors, per field
+func TestValidatorCollectsEveryError(t *testing.T) {
+	v := New()
+	v.Check(NotBlank("  "), "title", "title is required")
+	v.Check(Between(-1, 0, 10), "sets", "sets must be between 0 and 10")
+	v.Check(MaxChars("héllo", 5), "name", "name is too long")
+	v.Check(Matches("nope", EmailRX), "email", "email must be a valid email address")
+	v.Check(PermittedValue("x", "a", "b"), "email", "email must be a or b")
+	v.AddError("email", "email must be a or b") // * duplicates are kept once
+
+	assert.False(t, v.Valid())
+	assert.Equal(t, map[string][]string{
+		"title": {"title is required"},
+		"sets":  {"sets must be between 0 and 10"},
+		"email": {"email must be a valid email address", "email must be a or b"},
+	}, v.Errors)
+}
+
+func TestField(t *testing.T) {
+	assert.Equal(t, "entries[2].sets", Field("entries", 2, "sets"))
+	assert.True(t, New().Valid())
+}
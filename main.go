@@ -2,34 +2,57 @@ package main
 
 // imports
 import (
+	"context"
+	"errors"
 	"fem/internal/app"
+	"fem/internal/config"
 	"fem/internal/routes"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 // Main function where go application spins up
 func main() {
+	// run returns instead of exiting --> deferred cleanup (db pool, span exporter) always happens
+	err := run()
+	if errors.Is(err,flag.ErrHelp) {
+		return // -h prints the flags, not an error
+	}
+	if err != nil {
+		slog.Error("server stopped","error",err)
+		os.Exit(1)
+	}
+}
 
-	// fallback port if not specified
-	var port int
-	flag.IntVar(&port,"port",8080,"GO BACKEND SERVER!")
-	flag.Parse() // execute it
+//! run --> serves until SIGINT/SIGTERM, then drains in-flight requests before returning
+func run() error {
+
+	// defaults < -config file < environment < flags, validated before anything starts
+	cfg,err := config.Load(os.Args[1:],os.LookupEnv)
+	if err != nil {
+		return err
+	}
 
-	app,err := app.NewApplication() //! returns Logger's output
+	app,err := app.NewApplication(cfg) //! returns Logger's output
 
 	//  if caught any error intiting app
 	if err !=nil {
-		panic(err) // exits the app 
+		return err
 	}
 
 	// closing db connection
 	defer app.DB.Close() //!defer the execution to the very end of the application
-
-	// ? - otherwise successfully imported function and executed
-	fmt.Println("app is running!")
+	if app.Pool != nil {
+		defer app.Pool.Close() //* database.driver = pgxpool
+	}
+	// flushing spans that are still buffered in the exporter
+	defer app.TracerProvider.Shutdown(context.Background())
 
 	//! server management
 
@@ -37,23 +60,83 @@ func main() {
 	r := routes.SetupRoutes(app)	// needs to pass logger as it points to application struct
 	// creating instance of a server
 	server := &http.Server{
-		Addr: fmt.Sprintf(":%d",port),
-		IdleTimeout: time.Minute,
+		Addr: fmt.Sprintf(":%d",cfg.Server.Port),
+		IdleTimeout: cfg.Server.IdleTimeout,
 		Handler: r, //! now parent handler is set for all route req --> handled through chi routes
-		ReadTimeout: 10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		ReadTimeout: cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ErrorLog: slog.NewLogLogger(app.Logger.Handler(),slog.LevelError), //! net/http's own errors (tls, panics) as JSON too
 	}
 
-	app.Logger.Printf("App is running on port : %d\n",port)
+	// * metrics.addr --> /metrics gets its own listener (internal network only), the public port stops serving it
+	var metricsServer *http.Server
+	if cfg.Metrics.Addr != "" {
+		metricsServer = &http.Server{
+			Addr: cfg.Metrics.Addr,
+			Handler: routes.SetupMetricsRoutes(app),
+			ReadTimeout: cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			ErrorLog: slog.NewLogLogger(app.Logger.Handler(),slog.LevelError),
+		}
+	} else if cfg.Metrics.Token == "" {
+		app.Logger.Info("/metrics is not served, set METRICS_ADDR or METRICS_TOKEN to enable it")
+	}
 
+	// ctrl+c locally, docker stop / kubernetes send SIGTERM
+	ctx,stop := signal.NotifyContext(context.Background(),os.Interrupt,syscall.SIGTERM)
+	defer stop()
 
+	// * server listens for any incoming request in the background, main waits for a signal
+	servers := 1
+	serverErr := make(chan error,2)
+	go func() {
+		serverErr <- server.ListenAndServe() // returns error if failed to listen for a sever
+	}()
+	if metricsServer != nil {
+		go func() {
+			serverErr <- metricsServer.ListenAndServe()
+		}()
+		servers++
+		app.Logger.Info("metrics listening","addr",cfg.Metrics.Addr)
+	}
 
-	// * server listens for any incoming request
-	err = server.ListenAndServe() // returns error if failed to listen for a sever
-   // if caught error listening for a server
-	if err !=nil {
-		app.Logger.Fatal(err)
-	} 
+	app.Logger.Info("app is running","port",cfg.Server.Port)
+	app.Health.SetReady() //! /readyz starts answering 200 once the checks pass
 
-}
+	select {
+	case err = <-serverErr:
+		// ? never got going (port taken ...) --> nothing to drain
+		return err
+	case <-ctx.Done():
+	}
+	stop() //! a second ctrl+c kills the process right away
+
+	// ! shutdown --> readiness fails first, then no new connections, then in-flight requests finish
+	app.Logger.Info("shutting down","timeout",cfg.Server.ShutdownTimeout,"delay",cfg.Server.ShutdownDelay)
+	app.Health.SetStopping()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	shutdownCtx,cancel := context.WithTimeout(context.Background(),cfg.Server.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		// ? drain timeout hit --> closing the connections cancels the request contexts and their queries
+		app.Logger.Warn("requests still running after the shutdown timeout, closing connections","error",err)
+		server.Close()
+	}
 
+	if metricsServer != nil {
+		// ? scrapes are short and retried --> no drain, the api requests were the ones worth waiting for
+		metricsServer.Close()
+	}
+
+	// ListenAndServe returns ErrServerClosed as soon as Shutdown starts
+	for range servers {
+		err = <-serverErr
+		if err != nil && !errors.Is(err,http.ErrServerClosed) {
+			return err
+		}
+	}
+	app.Logger.Info("server stopped")
+	return nil
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user',
+ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP WITH TIME ZONE,
+ADD CONSTRAINT valid_user_role CHECK (role IN ('user', 'coach', 'admin'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS valid_user_role,
+DROP COLUMN IF EXISTS suspended_at,
+DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
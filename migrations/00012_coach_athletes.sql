@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS coach_athletes (
+  id BIGSERIAL PRIMARY KEY,
+  coach_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  athlete_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  access TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  responded_at TIMESTAMP WITH TIME ZONE,
+  UNIQUE (coach_id, athlete_id),
+  CONSTRAINT valid_coach_access CHECK (access IN ('read', 'read_write')),
+  CONSTRAINT valid_coach_status CHECK (status IN ('pending', 'accepted', 'declined')),
+  CONSTRAINT coach_is_not_athlete CHECK (coach_id <> athlete_id)
+)
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE coach_athletes;
+-- +goose StatementEnd
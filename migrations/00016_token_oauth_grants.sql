@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tokens
+ADD COLUMN IF NOT EXISTS client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE,
+ADD COLUMN IF NOT EXISTS scopes TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE tokens
+DROP COLUMN IF EXISTS scopes,
+DROP COLUMN IF EXISTS client_id;
+-- +goose StatementEnd
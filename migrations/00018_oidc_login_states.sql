@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  hash BYTEA PRIMARY KEY,
+  provider VARCHAR(50) NOT NULL,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  link_user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+  expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+)
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE oidc_login_states;
+-- +goose StatementEnd
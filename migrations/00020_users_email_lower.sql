@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- emails are looked up with LOWER(email) --> Bob@x.com and bob@x.com must not be two accounts
+-- fails if such duplicates already exist, merge or rename them by hand first
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (LOWER(email));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_email_lower_key;
+-- +goose StatementEnd